@@ -109,12 +109,13 @@ func findManifest() (*model.Manifest, error) {
 
 	// Re-decode the manifest, disallowing unknown fields. When we write the manifest back out,
 	// we don't want to accidentally clobber anything we won't preserve.
-	var manifest model.Manifest
+	var decoded secretManifest
 	decoder := json.NewDecoder(manifestFile)
 	decoder.DisallowUnknownFields()
-	if err = decoder.Decode(&manifest); err != nil {
+	if err = decoder.Decode(&decoded); err != nil {
 		return nil, errors.Wrap(err, "failed to parse manifest")
 	}
+	manifest := decoded.unwrap()
 
 	// If no version is listed in the manifest, generate one based on the state of the current
 	// commit, and use the first version we find (to prevent causing errors)
@@ -142,7 +143,7 @@ func findManifest() (*model.Manifest, error) {
 		manifest.ReleaseNotesURL = manifest.HomepageURL + "releases/tag/" + BuildTagLatest
 	}
 
-	return &manifest, nil
+	return manifest, nil
 }
 
 // dumpPluginId writes the plugin id from the given manifest to standard out
@@ -159,7 +160,7 @@ func dumpPluginVersion(manifest *model.Manifest) {
 func applyManifest(manifest *model.Manifest) error {
 	if manifest.HasServer() {
 		// generate JSON representation of Manifest.
-		manifestBytes, err := json.MarshalIndent(manifest, "", "  ")
+		manifestBytes, err := json.MarshalIndent(wrapManifest(manifest), "", "  ")
 		if err != nil {
 			return err
 		}
@@ -179,7 +180,7 @@ func applyManifest(manifest *model.Manifest) error {
 		// generate JSON representation of Manifest.
 		// JSON is very similar and compatible with JS's object literals. so, what we do here
 		// is actually JS code generation.
-		manifestBytes, err := json.MarshalIndent(manifest, "", "    ")
+		manifestBytes, err := json.MarshalIndent(wrapManifest(manifest), "", "    ")
 		if err != nil {
 			return err
 		}
@@ -203,7 +204,7 @@ func applyManifest(manifest *model.Manifest) error {
 
 // distManifest writes the manifest file to the dist directory
 func distManifest(manifest *model.Manifest) error {
-	manifestBytes, err := json.MarshalIndent(manifest, "", "    ")
+	manifestBytes, err := json.MarshalIndent(wrapManifest(manifest), "", "    ")
 	if err != nil {
 		return err
 	}
@@ -214,3 +215,74 @@ func distManifest(manifest *model.Manifest) error {
 
 	return nil
 }
+
+// secretSettings are the keys of the settings marked as secret. The server masks their values
+// before sending the configuration to the System Console. model.PluginSetting in server/public
+// v0.0.18 has no Secret field, so the flag is kept here and written back out with the manifest.
+// Drop secretManifest and wrapManifest once server/public is bumped to a release that has it.
+var secretSettings = map[string]bool{}
+
+// secretManifest is a manifest whose settings may carry the secret flag.
+type secretManifest struct {
+	*model.Manifest
+	SettingsSchema *secretSettingsSchema `json:"settings_schema,omitempty"`
+}
+
+type secretSettingsSchema struct {
+	Header   string           `json:"header"`
+	Footer   string           `json:"footer"`
+	Settings []*secretSetting `json:"settings"`
+}
+
+type secretSetting struct {
+	*model.PluginSetting
+	Secret bool `json:"secret,omitempty"`
+}
+
+// unwrap records the secret settings and returns the plain manifest.
+func (m *secretManifest) unwrap() *model.Manifest {
+	manifest := m.Manifest
+	if manifest == nil {
+		manifest = &model.Manifest{}
+	}
+	if m.SettingsSchema == nil {
+		return manifest
+	}
+
+	manifest.SettingsSchema = &model.PluginSettingsSchema{
+		Header: m.SettingsSchema.Header,
+		Footer: m.SettingsSchema.Footer,
+	}
+	for _, setting := range m.SettingsSchema.Settings {
+		if setting.PluginSetting == nil {
+			setting.PluginSetting = &model.PluginSetting{}
+		}
+		if setting.Secret {
+			secretSettings[setting.Key] = true
+		}
+		manifest.SettingsSchema.Settings = append(manifest.SettingsSchema.Settings, setting.PluginSetting)
+	}
+
+	return manifest
+}
+
+// wrapManifest adds the secret flag back to the settings of the manifest.
+func wrapManifest(manifest *model.Manifest) *secretManifest {
+	wrapped := &secretManifest{Manifest: manifest}
+	if manifest.SettingsSchema == nil {
+		return wrapped
+	}
+
+	wrapped.SettingsSchema = &secretSettingsSchema{
+		Header: manifest.SettingsSchema.Header,
+		Footer: manifest.SettingsSchema.Footer,
+	}
+	for _, setting := range manifest.SettingsSchema.Settings {
+		wrapped.SettingsSchema.Settings = append(wrapped.SettingsSchema.Settings, &secretSetting{
+			PluginSetting: setting,
+			Secret:        secretSettings[setting.Key],
+		})
+	}
+
+	return wrapped
+}
@@ -0,0 +1,609 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/tailscale/hujson"
+	"tailscale.com/client/tailscale"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/pluginapi/cluster"
+)
+
+const (
+	accessGrantKeyPrefix = "access_grant_"
+
+	// accessGroupPrefix prefixes the policy groups temporary access is granted through. The policy
+	// must contain rules for these groups, e.g. group:jit-prod for access to tag:prod.
+	accessGroupPrefix = "group:jit-"
+
+	// maxAccessGrantDuration is the longest time access can be requested for.
+	maxAccessGrantDuration = 24 * time.Hour
+
+	// defaultAccessGrantCheckInterval is how often expired grants are looked for if no valid
+	// interval is configured.
+	defaultAccessGrantCheckInterval = time.Minute
+
+	accessGrantPending   = "pending"
+	accessGrantApproving = "approving"
+	accessGrantActive    = "active"
+	accessGrantDenied    = "denied"
+	accessGrantExpired   = "expired"
+	accessGrantFailed    = "failed"
+)
+
+// accessGrant is a request for temporary access to a tag. Once approved, the requester's email is
+// a member of Group until ExpireAt. ExpireAt is stored before the requester is added, so a grant
+// stuck approving is revoked, too. Grants are removed from the KV store once they are denied,
+// failed or expired.
+//
+// MemberBefore records that the requester already was a member of Group without a grant, in which
+// case the membership is left in place when the grant expires. It is only set once the policy
+// showed the member, so a grant whose activation was not recorded is revoked.
+type accessGrant struct {
+	ID         string
+	UserID     string
+	Email      string
+	Tailnet    string
+	Tag        string
+	Group      string
+	Duration   time.Duration
+	Reason     string
+	Status     string
+	Detail     string
+	ApproverID string
+	ChannelID  string
+	PostID     string
+	CreateAt   int64
+	ExpireAt   int64
+
+	MemberBefore bool
+
+	// RevocationError is the error revoking the expired grant failed with, once it was reported.
+	RevocationError string
+}
+
+// errAccessGrantNotFound is returned when updating a grant that no longer exists.
+var errAccessGrantNotFound = errors.New("the access request no longer exists")
+
+func accessGrantKey(id string) string {
+	return accessGrantKeyPrefix + id
+}
+
+// isExpired reports whether the grant may have added the requester to the group and its time is
+// up.
+func (g *accessGrant) isExpired(now int64) bool {
+	return (g.Status == accessGrantActive || g.Status == accessGrantApproving) && g.ExpireAt != 0 && g.ExpireAt <= now
+}
+
+// accessGroup returns the policy group temporary access to a tag is granted through.
+func accessGroup(tag string) string {
+	return accessGroupPrefix + strings.TrimPrefix(tag, "tag:")
+}
+
+// parseAccessRequest parses the arguments of /tailscale access request.
+func parseAccessRequest(fields []string) (string, time.Duration, string, error) {
+	var tag, reason string
+	var duration time.Duration
+	for i := 0; i < len(fields); i++ {
+		switch fields[i] {
+		case "--for", "--reason":
+			if i+1 == len(fields) {
+				return "", 0, "", errors.Errorf("missing value for %s", fields[i])
+			}
+			if fields[i] == "--reason" {
+				reason = fields[i+1]
+			} else {
+				d, err := parseDuration(fields[i+1])
+				if err != nil {
+					return "", 0, "", err
+				}
+				duration = d
+			}
+			i++
+		default:
+			if tag != "" {
+				return "", 0, "", errors.Errorf("unexpected argument %q", fields[i])
+			}
+			tag = fields[i]
+		}
+	}
+
+	switch {
+	case !isTag(tag):
+		return "", 0, "", errors.New("access can only be requested to a tag, like tag:prod")
+	case duration == 0:
+		return "", 0, "", errors.New("the duration is missing, use --for to set it, like --for 2h")
+	case duration > maxAccessGrantDuration:
+		return "", 0, "", errors.Errorf("access can be requested for at most %s", formatDuration(maxAccessGrantDuration))
+	case strings.TrimSpace(reason) == "":
+		return "", 0, "", errors.New("the reason is missing, use --reason to set it")
+	}
+
+	return tag, duration, reason, nil
+}
+
+func (p *Plugin) handleAccessRequest(args *model.CommandArgs, fields []string) error {
+	tag, duration, reason, err := parseAccessRequest(fields)
+	if err != nil {
+		p.postEphemeral(args.UserId, args.ChannelId, fmt.Sprintf("%s\nUsage: /tailscale access request <tag> --for <duration> --reason \"<reason>\"", err.Error()))
+		return nil
+	}
+
+	if len(p.getConfiguration().getPolicyApprovers()) == 0 {
+		return errors.New("access requests require approval, but no approvers are configured. Ask a System Admin to configure the policy approvers")
+	}
+
+	client, config, err := p.getTailscaleClient(args)
+	if err != nil || client == nil {
+		return err
+	}
+
+	user, appErr := p.API.GetUser(args.UserId)
+	if appErr != nil {
+		return fmt.Errorf("failed to get user: %w", appErr)
+	}
+
+	acl, err := p.fetchACL(client)
+	if err != nil {
+		return err
+	}
+
+	group := accessGroup(tag)
+	referenced, err := isGroupReferenced(acl.ACL, group)
+	if err != nil {
+		return err
+	}
+	if !referenced {
+		p.postEphemeral(args.UserId, args.ChannelId, fmt.Sprintf("Temporary access to `%s` is not set up. Ask an admin to add a rule for `%s` to the policy of tailnet %s.", tag, group, config.Tailnet))
+		return nil
+	}
+
+	grant := &accessGrant{
+		ID:        model.NewId(),
+		UserID:    args.UserId,
+		Email:     strings.ToLower(user.Email),
+		Tailnet:   config.Tailnet,
+		Tag:       tag,
+		Group:     group,
+		Duration:  duration,
+		Reason:    reason,
+		Status:    accessGrantPending,
+		ChannelID: args.ChannelId,
+		CreateAt:  model.GetMillis(),
+	}
+
+	post := &model.Post{
+		ChannelId: args.ChannelId,
+		RootId:    args.RootId,
+		UserId:    p.botID,
+	}
+	p.renderAccessGrant(post, grant)
+	if err := p.client.Post.CreatePost(post); err != nil {
+		return fmt.Errorf("failed to post access request: %w", err)
+	}
+
+	grant.PostID = post.Id
+	data, err := json.Marshal(grant)
+	if err != nil {
+		return fmt.Errorf("failed to marshal access request: %w", err)
+	}
+	if appErr := p.API.KVSet(accessGrantKey(grant.ID), data); appErr != nil {
+		return fmt.Errorf("failed to store access request: %w", appErr)
+	}
+
+	p.API.LogInfo("Access requested", "grant_id", grant.ID, "tailnet", grant.Tailnet, "tag", tag, "duration", formatDuration(duration), "user_id", args.UserId)
+
+	return nil
+}
+
+// isGroupReferenced reports whether a group is used outside of the groups section of a policy.
+func isGroupReferenced(huJSON, group string) (bool, error) {
+	data, err := hujson.Standardize([]byte(huJSON))
+	if err != nil {
+		return false, fmt.Errorf("failed to parse policy: %w", err)
+	}
+
+	var raw map[string]any
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return false, fmt.Errorf("failed to decode policy: %w", err)
+	}
+
+	return isReferenced(collectPolicyStrings(raw, "groups"), group), nil
+}
+
+// renderAccessGrant sets the message and buttons of an access request post.
+func (p *Plugin) renderAccessGrant(post *model.Post, grant *accessGrant) {
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("#### Access request for %s\n", grant.Tailnet))
+	b.WriteString(fmt.Sprintf("%s requests access to `%s` for %s.\n", p.mentionUser(grant.UserID), grant.Tag, formatDuration(grant.Duration)))
+	b.WriteString(fmt.Sprintf("**Reason:** %s\n", grant.Reason))
+
+	switch grant.Status {
+	case accessGrantActive:
+		b.WriteString(fmt.Sprintf("**Status:** active until %s, approved by %s",
+			time.UnixMilli(grant.ExpireAt).UTC().Format(time.RFC3339), p.mentionUser(grant.ApproverID)))
+	case accessGrantDenied:
+		b.WriteString(fmt.Sprintf("**Status:** denied by %s", p.mentionUser(grant.ApproverID)))
+	case accessGrantExpired:
+		b.WriteString(fmt.Sprintf("**Status:** expired at %s, approved by %s",
+			time.UnixMilli(grant.ExpireAt).UTC().Format(time.RFC3339), p.mentionUser(grant.ApproverID)))
+	case accessGrantFailed:
+		b.WriteString(fmt.Sprintf("**Status:** failed: %s", grant.Detail))
+	default:
+		b.WriteString(fmt.Sprintf("**Status:** %s", grant.Status))
+	}
+
+	post.Message = b.String()
+	post.DelProp("attachments")
+
+	if grant.Status != accessGrantPending {
+		return
+	}
+
+	model.ParseSlackAttachment(post, []*model.SlackAttachment{{
+		Actions: []*model.PostAction{
+			accessGrantAction("Approve", "approve", "success", grant.ID),
+			accessGrantAction("Deny", "deny", "danger", grant.ID),
+		},
+	}})
+}
+
+func accessGrantAction(name, action, style, grantID string) *model.PostAction {
+	return &model.PostAction{
+		Type:  model.PostActionTypeButton,
+		Name:  name,
+		Style: style,
+		Integration: &model.PostActionIntegration{
+			URL: actionURL("/api/v1/access/grants"),
+			Context: map[string]any{
+				"grant_id": grantID,
+				"action":   action,
+			},
+		},
+	}
+}
+
+// updateAccessGrant atomically modifies a stored access grant. update returns an error to abort
+// the modification.
+func (p *Plugin) updateAccessGrant(id string, update func(grant *accessGrant) error) (*accessGrant, error) {
+	var updated accessGrant
+	err := p.client.KV.SetAtomicWithRetries(accessGrantKey(id), func(oldValue []byte) (any, error) {
+		if oldValue == nil {
+			return nil, errAccessGrantNotFound
+		}
+
+		updated = accessGrant{}
+		if err := json.Unmarshal(oldValue, &updated); err != nil {
+			return nil, err
+		}
+
+		if err := update(&updated); err != nil {
+			return nil, err
+		}
+
+		return updated, nil
+	})
+	if err != nil {
+		return nil, errors.Cause(err)
+	}
+
+	return &updated, nil
+}
+
+func (p *Plugin) handleAccessGrantAction(userID string, actionRequest *model.PostActionIntegrationRequest) (string, error) {
+	isApprover, err := p.isPolicyApprover(userID)
+	if err != nil {
+		p.API.LogError("Failed to check policy approver", "error", err.Error())
+		return "", errors.New("failed to check policy approver")
+	}
+	if !isApprover {
+		return "", errors.New("only the configured policy approvers can approve or deny access requests")
+	}
+
+	action := contextString(actionRequest, "action")
+	grant, err := p.updateAccessGrant(contextString(actionRequest, "grant_id"), func(grant *accessGrant) error {
+		if err := checkDecision("access request", grant.Status, grant.UserID, userID); err != nil {
+			return err
+		}
+
+		grant.ApproverID = userID
+		switch action {
+		case "approve":
+			grant.Status = accessGrantApproving
+		case "deny":
+			grant.Status = accessGrantDenied
+		default:
+			return fmt.Errorf("unknown action %q", action)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	p.API.LogInfo("Access request decision", "grant_id", grant.ID, "user_id", userID, "action", action)
+
+	if grant.Status == accessGrantApproving {
+		grant = p.activateAccessGrant(grant)
+	}
+
+	if err := p.updateAccessGrantPost(grant); err != nil {
+		p.API.LogError("Failed to update access request post", "grant_id", grant.ID, "error", err.Error())
+	}
+	if grant.Status == accessGrantDenied || grant.Status == accessGrantFailed {
+		p.deleteAccessGrant(grant)
+	}
+
+	return "", nil
+}
+
+// activateAccessGrant adds the requester to the access group using the approver's credentials.
+// The expiry is stored first, so the requester is removed again even if storing the outcome fails.
+func (p *Plugin) activateAccessGrant(grant *accessGrant) *accessGrant {
+	expireAt := time.Now().Add(grant.Duration).UnixMilli()
+	scheduled, err := p.updateAccessGrant(grant.ID, func(grant *accessGrant) error {
+		grant.ExpireAt = expireAt
+		return nil
+	})
+	if err != nil {
+		p.API.LogError("Failed to store expiry of access grant", "grant_id", grant.ID, "error", err.Error())
+		grant.Status, grant.Detail = accessGrantFailed, "failed to store the expiry of the access grant"
+		return grant
+	}
+	grant = scheduled
+
+	status, detail, memberBefore := accessGrantActive, "", false
+	diff, err := p.updatePolicyGroupAs(grant.ApproverID, grant.Tailnet, grant.Group, []string{grant.Email}, nil,
+		fmt.Sprintf("access grant for %s", grant.Email))
+	if err != nil {
+		status, detail = accessGrantFailed, err.Error()
+		p.API.LogWarn("Failed to grant access", "grant_id", grant.ID, "tailnet", grant.Tailnet, "group", grant.Group, "error", err.Error())
+	} else {
+		// An unchanged policy means the requester already was a member, either on their own or
+		// through another grant, which then removes the membership when it expires.
+		memberBefore = diff == "" && !p.hasOtherAccessGrant(grant)
+		p.API.LogInfo("Granted temporary access", "grant_id", grant.ID, "tailnet", grant.Tailnet, "group", grant.Group, "user_id", grant.UserID, "approver_id", grant.ApproverID, "member_before", memberBefore)
+	}
+
+	updated, err := p.updateAccessGrant(grant.ID, func(grant *accessGrant) error {
+		grant.Status = status
+		grant.Detail = detail
+		grant.MemberBefore = memberBefore
+		return nil
+	})
+	if err != nil {
+		// The grant stays approving with its expiry, so the expiry job still revokes it.
+		p.API.LogError("Failed to update access grant", "grant_id", grant.ID, "error", err.Error())
+		grant.Status, grant.Detail = status, detail
+		return grant
+	}
+
+	return updated
+}
+
+// hasOtherAccessGrant reports whether another grant added the requester to the same group. If the
+// grants cannot be listed, it reports true, so the membership is removed when the grant expires.
+func (p *Plugin) hasOtherAccessGrant(grant *accessGrant) bool {
+	grants, err := p.listAccessGrants()
+	if err != nil {
+		p.API.LogWarn("Failed to list access grants", "error", err.Error())
+		return true
+	}
+
+	for _, other := range grants {
+		if other.ID != grant.ID && (other.Status == accessGrantActive || other.Status == accessGrantApproving) && !other.MemberBefore &&
+			other.Tailnet == grant.Tailnet && other.Group == grant.Group && other.Email == grant.Email {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (p *Plugin) updateAccessGrantPost(grant *accessGrant) error {
+	post, err := p.client.Post.GetPost(grant.PostID)
+	if err != nil {
+		return fmt.Errorf("failed to get post: %w", err)
+	}
+
+	p.renderAccessGrant(post, grant)
+
+	return p.client.Post.UpdatePost(post)
+}
+
+func (p *Plugin) deleteAccessGrant(grant *accessGrant) {
+	if appErr := p.API.KVDelete(accessGrantKey(grant.ID)); appErr != nil {
+		p.API.LogWarn("Failed to delete access grant", "grant_id", grant.ID, "error", appErr.Error())
+	}
+}
+
+// listAccessGrants returns all stored access grants.
+func (p *Plugin) listAccessGrants() ([]*accessGrant, error) {
+	keys, err := p.listKeysWithPrefix(accessGrantKeyPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	var grants []*accessGrant
+	for _, key := range keys {
+		var grant accessGrant
+		if err := p.client.KV.Get(key, &grant); err != nil {
+			return nil, fmt.Errorf("failed to get access grant: %w", err)
+		}
+		if grant.ID != "" {
+			grants = append(grants, &grant)
+		}
+	}
+
+	return grants, nil
+}
+
+func (p *Plugin) handleAccessActive(args *model.CommandArgs) error {
+	config, err := p.getUserTailscaleConfig(args.UserId)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve Tailscale configuration: %w", err)
+	}
+
+	if config == nil {
+		p.postEphemeral(args.UserId, args.ChannelId, "Please authenticate first using: `/tailscale connect <tailnet> <api-key>`")
+		return nil
+	}
+
+	grants, err := p.listAccessGrants()
+	if err != nil {
+		return err
+	}
+
+	var b strings.Builder
+	count := 0
+	for _, grant := range grants {
+		if grant.Tailnet != config.Tailnet || grant.Status != accessGrantActive {
+			continue
+		}
+
+		count++
+		b.WriteString(fmt.Sprintf("| %s | `%s` | %s | %s | %s |\n",
+			p.mentionUser(grant.UserID), grant.Tag, time.UnixMilli(grant.ExpireAt).UTC().Format(time.RFC3339),
+			p.mentionUser(grant.ApproverID), joinCell([]string{grant.Reason})))
+	}
+
+	if count == 0 {
+		p.postEphemeral(args.UserId, args.ChannelId, fmt.Sprintf("There are no active access grants for tailnet %s.", config.Tailnet))
+		return nil
+	}
+
+	message := fmt.Sprintf("#### Active access grants for %s\n| User | Tag | Expires | Approved by | Reason |\n| --- | --- | --- | --- | --- |\n%s", config.Tailnet, b.String())
+	return p.postEphemeralOrFile(args.UserId, args.ChannelId, message, "access-grants.md", []byte(message), "")
+}
+
+// scheduleAccessGrantExpiry starts the background job that revokes expired access grants.
+func (p *Plugin) scheduleAccessGrantExpiry() (*cluster.Job, error) {
+	return cluster.Schedule(p.API, "AccessGrantExpiry", func(now time.Time, metadata cluster.JobMetadata) time.Duration {
+		return cluster.MakeWaitForInterval(p.getConfiguration().getAccessGrantCheckInterval())(now, metadata)
+	}, p.expireAccessGrants)
+}
+
+func (p *Plugin) expireAccessGrants() {
+	grants, err := p.listAccessGrants()
+	if err != nil {
+		p.API.LogError("Failed to list access grants", "error", err.Error())
+		return
+	}
+
+	now := model.GetMillis()
+	for _, grant := range grants {
+		if !grant.isExpired(now) {
+			continue
+		}
+
+		if err := p.expireAccessGrant(grant, grants, now); err != nil {
+			p.API.LogWarn("Failed to revoke expired access grant", "grant_id", grant.ID, "tailnet", grant.Tailnet, "group", grant.Group, "error", err.Error())
+			p.reportRevocationFailure(grant, err)
+		}
+	}
+}
+
+// expireAccessGrant removes the requester from the access group, unless they were a member before
+// the grant or another active grant still gives them access, and marks the grant as expired.
+func (p *Plugin) expireAccessGrant(grant *accessGrant, grants []*accessGrant, now int64) error {
+	keepMember := grant.MemberBefore
+	for _, other := range grants {
+		if other.ID != grant.ID && (other.Status == accessGrantActive || other.Status == accessGrantApproving) && other.ExpireAt > now &&
+			other.Tailnet == grant.Tailnet && other.Group == grant.Group && other.Email == grant.Email {
+			keepMember = true
+			break
+		}
+	}
+
+	if !keepMember {
+		client, authorID, err := p.accessRevocationClient(grant)
+		if err != nil {
+			return err
+		}
+
+		_, err = p.updatePolicyGroup(client, authorID, grant.Tailnet, grant.Group, nil, []string{grant.Email},
+			fmt.Sprintf("expiry of access grant for %s", grant.Email))
+		if err != nil {
+			return err
+		}
+	}
+
+	p.API.LogInfo("Revoked expired access grant", "grant_id", grant.ID, "tailnet", grant.Tailnet, "group", grant.Group, "user_id", grant.UserID)
+
+	grant.Status = accessGrantExpired
+	if err := p.updateAccessGrantPost(grant); err != nil {
+		p.API.LogWarn("Failed to update access request post", "grant_id", grant.ID, "error", err.Error())
+	}
+	p.deleteAccessGrant(grant)
+
+	return nil
+}
+
+// accessRevocationClient returns the client expired grants are revoked with and the author the
+// change is recorded with. The admin credential is used if it manages the tailnet of the grant, so
+// revoking does not depend on the approver staying connected. Otherwise the approver's
+// credentials are used.
+func (p *Plugin) accessRevocationClient(grant *accessGrant) (*tailscale.Client, string, error) {
+	if client, tailnet, err := p.adminClient(); err == nil && tailnet == grant.Tailnet {
+		return client, p.botID, nil
+	}
+
+	client, err := p.connectedClient(grant.ApproverID, grant.Tailnet)
+	if err != nil {
+		return nil, "", fmt.Errorf("the admin API key is not configured for tailnet %s and %w", grant.Tailnet, err)
+	}
+
+	return client, grant.ApproverID, nil
+}
+
+// reportRevocationFailure tells the requester, the approver and the audit channel that an expired
+// grant could not be revoked, so the access can be removed by hand. Each grant is reported once,
+// while the expiry job keeps retrying.
+func (p *Plugin) reportRevocationFailure(grant *accessGrant, revokeErr error) {
+	if grant.RevocationError != "" {
+		return
+	}
+
+	reported := false
+	_, err := p.updateAccessGrant(grant.ID, func(grant *accessGrant) error {
+		reported = grant.RevocationError != ""
+		grant.RevocationError = revokeErr.Error()
+		return nil
+	})
+	if err != nil {
+		p.API.LogError("Failed to update access grant", "grant_id", grant.ID, "error", err.Error())
+		return
+	}
+	if reported {
+		return
+	}
+
+	message := fmt.Sprintf("#### Access grant could not be revoked\nThe access of %s to `%s` in tailnet %s expired at %s, but `%s` could not be removed from `%s`: %s\nThe plugin keeps retrying. To revoke the access now, remove `%s` from `%s` in the policy.",
+		p.mentionUser(grant.UserID), grant.Tag, grant.Tailnet, time.UnixMilli(grant.ExpireAt).UTC().Format(time.RFC3339),
+		grant.Email, grant.Group, revokeErr.Error(), grant.Email, grant.Group)
+
+	for _, userID := range []string{grant.UserID, grant.ApproverID} {
+		if err := p.sendDirectMessage(userID, message); err != nil {
+			p.API.LogWarn("Failed to notify about failed revocation", "grant_id", grant.ID, "user_id", userID, "error", err.Error())
+		}
+	}
+
+	auditChannel := strings.TrimSpace(p.getConfiguration().AuditChannel)
+	if auditChannel == "" {
+		return
+	}
+	channel, err := p.resolveChannel(auditChannel)
+	if err != nil {
+		p.API.LogWarn("Failed to get audit channel", "error", err.Error())
+		return
+	}
+	if err := p.client.Post.CreatePost(&model.Post{ChannelId: channel.Id, UserId: p.botID, Message: message}); err != nil {
+		p.API.LogWarn("Failed to post failed revocation to audit channel", "grant_id", grant.ID, "error", err.Error())
+	}
+}
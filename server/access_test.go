@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+func TestParseAccessRequest(t *testing.T) {
+	for name, tc := range map[string]struct {
+		args     []string
+		tag      string
+		duration time.Duration
+		reason   string
+		err      string
+	}{
+		"valid": {
+			args:     []string{"tag:prod", "--for", "2h", "--reason", "fix the database"},
+			tag:      "tag:prod",
+			duration: 2 * time.Hour,
+			reason:   "fix the database",
+		},
+		"options first": {
+			args:     []string{"--reason", "deploy", "--for", "30m", "tag:prod"},
+			tag:      "tag:prod",
+			duration: 30 * time.Minute,
+			reason:   "deploy",
+		},
+		"longest duration": {
+			args:     []string{"tag:prod", "--for", "1d", "--reason", "on call"},
+			tag:      "tag:prod",
+			duration: 24 * time.Hour,
+			reason:   "on call",
+		},
+		"not a tag": {
+			args: []string{"prod", "--for", "2h", "--reason", "deploy"},
+			err:  "access can only be requested to a tag, like tag:prod",
+		},
+		"missing tag": {
+			args: []string{"--for", "2h", "--reason", "deploy"},
+			err:  "access can only be requested to a tag, like tag:prod",
+		},
+		"second tag": {
+			args: []string{"tag:prod", "tag:db", "--for", "2h", "--reason", "deploy"},
+			err:  `unexpected argument "tag:db"`,
+		},
+		"missing duration": {
+			args: []string{"tag:prod", "--reason", "deploy"},
+			err:  "the duration is missing, use --for to set it, like --for 2h",
+		},
+		"invalid duration": {
+			args: []string{"tag:prod", "--for", "soon", "--reason", "deploy"},
+			err:  `invalid duration "soon"`,
+		},
+		"too long": {
+			args: []string{"tag:prod", "--for", "25h", "--reason", "deploy"},
+			err:  "access can be requested for at most 1d",
+		},
+		"overflowing duration": {
+			args: []string{"tag:prod", "--for", "106752d", "--reason", "deploy"},
+			err:  `invalid duration "106752d"`,
+		},
+		"missing reason": {
+			args: []string{"tag:prod", "--for", "2h"},
+			err:  "the reason is missing, use --reason to set it",
+		},
+		"blank reason": {
+			args: []string{"tag:prod", "--for", "2h", "--reason", " "},
+			err:  "the reason is missing, use --reason to set it",
+		},
+		"missing value": {
+			args: []string{"tag:prod", "--for", "2h", "--reason"},
+			err:  "missing value for --reason",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			tag, duration, reason, err := parseAccessRequest(tc.args)
+			if tc.err != "" {
+				assert.EqualError(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.tag, tag)
+			assert.Equal(t, tc.duration, duration)
+			assert.Equal(t, tc.reason, reason)
+		})
+	}
+}
+
+func TestAccessGrantIsExpired(t *testing.T) {
+	now := time.Now().UnixMilli()
+	for name, tc := range map[string]struct {
+		grant    accessGrant
+		expected bool
+	}{
+		"active and expired":    {grant: accessGrant{Status: accessGrantActive, ExpireAt: now - 1}, expected: true},
+		"active":                {grant: accessGrant{Status: accessGrantActive, ExpireAt: now + 1}},
+		"approving and expired": {grant: accessGrant{Status: accessGrantApproving, ExpireAt: now}, expected: true},
+		"approving":             {grant: accessGrant{Status: accessGrantApproving}},
+		"pending":               {grant: accessGrant{Status: accessGrantPending}},
+		"already expired":       {grant: accessGrant{Status: accessGrantExpired, ExpireAt: now - 1}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, tc.grant.isExpired(now))
+		})
+	}
+}
+
+func TestAccessGrantMembership(t *testing.T) {
+	setup := func(t *testing.T, members string) (*Plugin, *fakeTailscale) {
+		p, api, ts := newTestPlugin(t)
+		ts.addTailnet("key", &fakeTailnet{ID: "1001", Policy: `{
+			"groups": {"group:jit-prod": [` + members + `]},
+			"acls": [{"action": "accept", "src": ["group:jit-prod"], "dst": ["tag:prod:*"]}],
+		}`})
+		connectUser(t, p, "carol", "example.com", "key")
+		api.users["carol"] = &model.User{Id: "carol", Username: "carol"}
+		return p, ts
+	}
+
+	approve := func(t *testing.T, p *Plugin) *accessGrant {
+		post, err := p.API.CreatePost(&model.Post{ChannelId: "channel", UserId: p.botID})
+		require.Nil(t, err)
+
+		grant := &accessGrant{
+			ID:         model.NewId(),
+			UserID:     "alice",
+			Email:      "alice@example.com",
+			Tailnet:    "example.com",
+			Tag:        "tag:prod",
+			Group:      "group:jit-prod",
+			Duration:   time.Hour,
+			Status:     accessGrantApproving,
+			ApproverID: "carol",
+			PostID:     post.Id,
+		}
+		data, jsonErr := json.Marshal(grant)
+		require.NoError(t, jsonErr)
+		require.Nil(t, p.API.KVSet(accessGrantKey(grant.ID), data))
+
+		grant = p.activateAccessGrant(grant)
+		require.Equal(t, accessGrantActive, grant.Status, grant.Detail)
+		return grant
+	}
+
+	expire := func(t *testing.T, p *Plugin, grant *accessGrant) {
+		_, err := p.updateAccessGrant(grant.ID, func(grant *accessGrant) error {
+			grant.ExpireAt = model.GetMillis() - 1
+			return nil
+		})
+		require.NoError(t, err)
+		p.expireAccessGrants()
+	}
+
+	members := func(t *testing.T, ts *fakeTailscale) []string {
+		var pol *policy
+		ts.tailnet("key", func(tailnet *fakeTailnet) {
+			var err error
+			pol, err = parsePolicy(tailnet.Policy)
+			require.NoError(t, err)
+		})
+		return pol.Groups["group:jit-prod"]
+	}
+
+	t.Run("membership added by the grant is removed", func(t *testing.T) {
+		p, ts := setup(t, "")
+
+		grant := approve(t, p)
+		assert.False(t, grant.MemberBefore)
+		assert.Equal(t, []string{"alice@example.com"}, members(t, ts))
+
+		expire(t, p, grant)
+		assert.Empty(t, members(t, ts))
+	})
+
+	t.Run("existing membership is kept", func(t *testing.T) {
+		p, ts := setup(t, `"alice@example.com"`)
+
+		grant := approve(t, p)
+		assert.True(t, grant.MemberBefore)
+
+		expire(t, p, grant)
+		assert.Equal(t, []string{"alice@example.com"}, members(t, ts))
+	})
+
+	t.Run("overlapping grants", func(t *testing.T) {
+		p, ts := setup(t, "")
+
+		first := approve(t, p)
+		second := approve(t, p)
+		assert.False(t, second.MemberBefore)
+
+		expire(t, p, first)
+		assert.Equal(t, []string{"alice@example.com"}, members(t, ts))
+
+		expire(t, p, second)
+		assert.Empty(t, members(t, ts))
+	})
+}
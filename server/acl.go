@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"tailscale.com/client/tailscale"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// fetchACL retrieves the tailnet policy file in its original HuJSON form, including comments,
+// groups, tagOwners, hosts, ssh rules and tests. The policy is recorded in the policy history.
+func (p *Plugin) fetchACL(client *tailscale.Client) (*tailscale.ACLHuJSON, error) {
+	acl, _, err := p.fetchTailnetACL(client)
+	return acl, err
+}
+
+// fetchTailnetACL is like fetchACL, but also returns the ID of the tailnet the policy belongs to.
+func (p *Plugin) fetchTailnetACL(client *tailscale.Client) (*tailscale.ACLHuJSON, string, error) {
+	tailnetID, err := resolveTailnetID(context.Background(), client)
+	if err != nil {
+		return nil, "", err
+	}
+
+	acl, err := client.ACLHuJSON(context.Background())
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to retrieve ACL from Tailscale API: %w", err)
+	}
+
+	p.recordPolicyVersion(tailnetID, acl.ACL, acl.ETag, "", policyVersionObserved, "")
+
+	return acl, tailnetID, nil
+}
+
+func (p *Plugin) handleACL(args *model.CommandArgs) error {
+	client, config, err := p.getTailscaleClient(args)
+	if err != nil || client == nil {
+		return err
+	}
+
+	acl, err := p.fetchACL(client)
+	if err != nil {
+		return err
+	}
+
+	policy := strings.TrimSpace(acl.ACL)
+
+	var warnings strings.Builder
+	for _, warning := range acl.Warnings {
+		warnings.WriteString(fmt.Sprintf("\n- %s", warning))
+	}
+	if warnings.Len() > 0 {
+		warnings.WriteString("\n")
+	}
+
+	// A json code block would highlight the comments and trailing commas HuJSON allows as errors.
+	message := fmt.Sprintf("#### Tailscale ACL\n```\n%s\n```", policy)
+	fileMessage := fmt.Sprintf("#### Tailscale ACL\nThe policy file for tailnet %s is attached.", config.Tailnet)
+	if warnings.Len() > 0 {
+		message += "\n**Warnings:**" + warnings.String()
+		fileMessage += "\n\n**Warnings:**" + warnings.String()
+	}
+
+	return p.postEphemeralOrFile(args.UserId, args.ChannelId, message, "policy.hujson", []byte(policy+"\n"), fileMessage)
+}
+
+// handleACLSection renders a single section of the tailnet policy as a table.
+func (p *Plugin) handleACLSection(args *model.CommandArgs, section string) error {
+	client, _, err := p.getTailscaleClient(args)
+	if err != nil || client == nil {
+		return err
+	}
+
+	acl, err := p.fetchACL(client)
+	if err != nil {
+		return err
+	}
+
+	pol, err := parsePolicy(acl.ACL)
+	if err != nil {
+		return err
+	}
+
+	var message string
+	switch section {
+	case "groups":
+		message = renderPolicyGroups(pol)
+	case "tagowners":
+		message = renderPolicyTagOwners(pol)
+	case "hosts":
+		message = renderPolicyHosts(pol)
+	case "ssh":
+		message = renderPolicySSH(pol)
+	case "autoapprovers":
+		message = renderPolicyAutoApprovers(pol)
+	case "tests":
+		message = renderPolicyTests(pol)
+	default:
+		return fmt.Errorf("unknown policy section %q", section)
+	}
+
+	return p.postEphemeralOrFile(args.UserId, args.ChannelId, message, section+".md", []byte(message), "")
+}
+
+func renderPolicyGroups(pol *policy) string {
+	if len(pol.Groups) == 0 {
+		return "#### Groups\nThe policy does not define any groups."
+	}
+
+	var b strings.Builder
+	b.WriteString("#### Groups\n| Group | Members |\n| --- | --- |\n")
+	for _, name := range sortedKeys(pol.Groups) {
+		b.WriteString(fmt.Sprintf("| %s | %s |\n", name, joinCell(pol.Groups[name])))
+	}
+
+	return b.String()
+}
+
+func renderPolicyTagOwners(pol *policy) string {
+	if len(pol.TagOwners) == 0 {
+		return "#### Tag Owners\nThe policy does not define any tag owners."
+	}
+
+	var b strings.Builder
+	b.WriteString("#### Tag Owners\n| Tag | Owners |\n| --- | --- |\n")
+	for _, tag := range sortedKeys(pol.TagOwners) {
+		b.WriteString(fmt.Sprintf("| %s | %s |\n", tag, joinCell(pol.TagOwners[tag])))
+	}
+
+	return b.String()
+}
+
+func renderPolicyHosts(pol *policy) string {
+	if len(pol.Hosts) == 0 {
+		return "#### Hosts\nThe policy does not define any hosts."
+	}
+
+	var b strings.Builder
+	b.WriteString("#### Hosts\n| Host | Address |\n| --- | --- |\n")
+	for _, host := range sortedKeys(pol.Hosts) {
+		b.WriteString(fmt.Sprintf("| %s | %s |\n", host, pol.Hosts[host]))
+	}
+
+	return b.String()
+}
+
+func renderPolicySSH(pol *policy) string {
+	if len(pol.SSH) == 0 {
+		return "#### SSH Rules\nThe policy does not define any SSH rules."
+	}
+
+	var b strings.Builder
+	b.WriteString("#### SSH Rules\n| Action | Source | Destination | Users | Check Period |\n| --- | --- | --- | --- | --- |\n")
+	for _, rule := range pol.SSH {
+		checkPeriod := rule.CheckPeriod
+		if checkPeriod == "" {
+			checkPeriod = "-"
+		}
+		b.WriteString(fmt.Sprintf("| %s | %s | %s | %s | %s |\n",
+			rule.Action, joinCell(rule.Src), joinCell(rule.Dst), joinCell(rule.Users), checkPeriod))
+	}
+
+	return b.String()
+}
+
+func renderPolicyAutoApprovers(pol *policy) string {
+	if len(pol.AutoApprovers.Routes) == 0 && len(pol.AutoApprovers.ExitNode) == 0 {
+		return "#### Auto Approvers\nThe policy does not define any auto approvers."
+	}
+
+	var b strings.Builder
+	b.WriteString("#### Auto Approvers\n| Type | Route | Approvers |\n| --- | --- | --- |\n")
+	for _, route := range sortedKeys(pol.AutoApprovers.Routes) {
+		b.WriteString(fmt.Sprintf("| Route | %s | %s |\n", route, joinCell(pol.AutoApprovers.Routes[route])))
+	}
+	if len(pol.AutoApprovers.ExitNode) > 0 {
+		b.WriteString(fmt.Sprintf("| Exit Node | - | %s |\n", joinCell(pol.AutoApprovers.ExitNode)))
+	}
+
+	return b.String()
+}
+
+func renderPolicyTests(pol *policy) string {
+	if len(pol.Tests) == 0 {
+		return "#### Tests\nThe policy does not define any tests."
+	}
+
+	var b strings.Builder
+	b.WriteString("#### Tests\n| Source | Protocol | Accept | Deny |\n| --- | --- | --- | --- |\n")
+	for _, test := range pol.Tests {
+		src := test.Src
+		if src == "" {
+			src = test.User
+		}
+		proto := test.Proto
+		if proto == "" {
+			proto = "-"
+		}
+		accept := append(append([]string{}, test.Accept...), test.Allow...)
+		b.WriteString(fmt.Sprintf("| %s | %s | %s | %s |\n", src, proto, joinCell(accept), joinCell(test.Deny)))
+	}
+
+	return b.String()
+}
+
+// joinCell formats a list of values for use in a markdown table cell.
+func joinCell(values []string) string {
+	if len(values) == 0 {
+		return "-"
+	}
+
+	return escapeCell(strings.Join(values, ", "))
+}
+
+// escapeCell escapes text for use in a markdown table cell, where a pipe would end the cell.
+func escapeCell(text string) string {
+	return strings.ReplaceAll(text, "|", "\\|")
+}
+
+// postEphemeralOrFile posts message as an ephemeral post. If message exceeds the post size limit,
+// data is sent to the user as a file attachment with fileMessage instead.
+func (p *Plugin) postEphemeralOrFile(userID, channelID, message, fileName string, data []byte, fileMessage string) error {
+	if utf8.RuneCountInString(message) <= model.PostMessageMaxRunesV2 {
+		p.postEphemeral(userID, channelID, message)
+		return nil
+	}
+
+	if fileMessage == "" {
+		fileMessage = "The requested output is attached."
+	}
+	if err := p.sendFileToUser(userID, fileName, data, fileMessage); err != nil {
+		return fmt.Errorf("failed to send %s as file: %w", fileName, err)
+	}
+
+	p.postEphemeral(userID, channelID, "The output is too large to display here. It has been sent to you as a direct message.")
+	return nil
+}
+
+// sendFileToUser uploads data as a file attachment into the direct message channel between the
+// bot and the user. Files are not shared into the originating channel, as policies and other
+// tailnet details may be sensitive.
+func (p *Plugin) sendFileToUser(userID, fileName string, data []byte, message string) error {
+	channel, err := p.client.Channel.GetDirect(userID, p.botID)
+	if err != nil {
+		return fmt.Errorf("failed to get direct message channel: %w", err)
+	}
+
+	fileInfo, err := p.client.File.Upload(bytes.NewReader(data), fileName, channel.Id)
+	if err != nil {
+		return fmt.Errorf("failed to upload file: %w", err)
+	}
+
+	post := &model.Post{
+		ChannelId: channel.Id,
+		UserId:    p.botID,
+		Message:   message,
+		FileIds:   model.StringArray{fileInfo.Id},
+	}
+	if err := p.client.Post.CreatePost(post); err != nil {
+		return fmt.Errorf("failed to create post: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"tailscale.com/client/tailscale"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+const (
+	// pendingPolicyChangeTTL is how long a proposed policy change can be confirmed, in seconds.
+	pendingPolicyChangeTTL = 60 * 60
+
+	// maxPolicyFileSize limits the size of policy files read from attachments.
+	maxPolicyFileSize = 1 << 20
+
+	// policySearchDepth is the number of recent channel posts searched for a policy attachment.
+	policySearchDepth = 20
+)
+
+// pendingPolicyChange is a validated policy waiting for the user's confirmation. ETag is the
+// version of the policy the change was computed against; applying fails if the policy has been
+// modified in the meantime.
+type pendingPolicyChange struct {
+	ID        string
+	UserID    string
+	Tailnet   string
+	TailnetID string
+	Policy    string
+	ETag      string
+	Source    string
+}
+
+func pendingPolicyChangeKey(id string) string {
+	return "acl_change_" + id
+}
+
+func (c *pendingPolicyChange) proposedBy() string {
+	return c.UserID
+}
+
+func (p *Plugin) handleACLApply(args *model.CommandArgs) error {
+	client, config, err := p.getTailscaleClient(args)
+	if err != nil || client == nil {
+		return err
+	}
+
+	fileInfo, proposed, err := p.findAttachedPolicy(args)
+	if err != nil {
+		return err
+	}
+
+	return p.proposePolicyChange(args, client, config, proposed, fileInfo.Name)
+}
+
+// proposePolicyChange validates a policy and shows the diff against the current policy. Depending on
+// the configuration, the user is asked to confirm applying it or a change request is posted for
+// the policy approvers.
+func (p *Plugin) proposePolicyChange(args *model.CommandArgs, client *tailscale.Client, config *UserTailscaleConfig, proposed, source string) error {
+	current, tailnetID, err := p.fetchTailnetACL(client)
+	if err != nil {
+		return err
+	}
+
+	testErr, err := validateACL(context.Background(), client, proposed)
+	if err != nil {
+		return fmt.Errorf("failed to validate policy: %w", err)
+	}
+	if testErr != nil {
+		p.postEphemeral(args.UserId, args.ChannelId, formatValidationError(source, testErr))
+		return nil
+	}
+
+	diff, err := policyDiff(current.ACL, proposed, "current", source)
+	if err != nil {
+		return fmt.Errorf("failed to compute diff: %w", err)
+	}
+	if diff == "" {
+		p.postEphemeral(args.UserId, args.ChannelId, fmt.Sprintf("`%s` is identical to the current policy. Nothing to apply.", source))
+		return nil
+	}
+
+	change := &pendingPolicyChange{
+		ID:        model.NewId(),
+		UserID:    args.UserId,
+		Tailnet:   config.Tailnet,
+		TailnetID: tailnetID,
+		Policy:    proposed,
+		ETag:      current.ETag,
+		Source:    source,
+	}
+
+	if p.getConfiguration().PolicyApprovalsRequired > 0 {
+		if err := p.createPolicyChangeRequest(args, change, diff); err != nil {
+			return err
+		}
+
+		p.postEphemeral(args.UserId, args.ChannelId, fmt.Sprintf("`%s` passed validation and has been posted for approval.", source))
+		return nil
+	}
+
+	data, err := json.Marshal(change)
+	if err != nil {
+		return fmt.Errorf("failed to marshal policy change: %w", err)
+	}
+	if err := p.API.KVSetWithExpiry(pendingPolicyChangeKey(change.ID), data, pendingPolicyChangeTTL); err != nil {
+		return fmt.Errorf("failed to store policy change: %w", err)
+	}
+
+	post := &model.Post{
+		ChannelId: args.ChannelId,
+		UserId:    p.botID,
+		Message:   fmt.Sprintf("#### Apply policy to %s\n`%s` passed validation. Review the changes below.\n%s", config.Tailnet, source, formatDiff(diff)),
+	}
+	model.ParseSlackAttachment(post, []*model.SlackAttachment{{
+		Actions: []*model.PostAction{
+			policyChangeAction("Apply", "apply", "primary", change.ID),
+			policyChangeAction("Cancel", "cancel", "default", change.ID),
+		},
+	}})
+	p.client.Post.SendEphemeralPost(args.UserId, post)
+
+	return nil
+}
+
+func policyChangeAction(name, action, style, changeID string) *model.PostAction {
+	return &model.PostAction{
+		Type:  model.PostActionTypeButton,
+		Name:  name,
+		Style: style,
+		Integration: &model.PostActionIntegration{
+			URL: actionURL("/api/v1/acl/changes"),
+			Context: map[string]any{
+				"change_id": changeID,
+				"action":    action,
+			},
+		},
+	}
+}
+
+// findAttachedPolicy returns the most recent policy file attached to a post in the thread the
+// command was run in or, outside of threads, to one of the preceding posts in the channel.
+func (p *Plugin) findAttachedPolicy(args *model.CommandArgs) (*model.FileInfo, string, error) {
+	var postList *model.PostList
+	var err error
+	if args.RootId != "" {
+		postList, err = p.client.Post.GetPostThread(args.RootId)
+	} else {
+		postList, err = p.client.Post.GetPostsForChannel(args.ChannelId, 0, policySearchDepth)
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get posts: %w", err)
+	}
+
+	posts := postList.ToSlice()
+	sort.Slice(posts, func(i, j int) bool {
+		return posts[i].CreateAt > posts[j].CreateAt
+	})
+
+	for _, post := range posts {
+		for _, fileID := range post.FileIds {
+			fileInfo, err := p.client.File.GetInfo(fileID)
+			if err != nil {
+				return nil, "", fmt.Errorf("failed to get file info: %w", err)
+			}
+
+			if !isPolicyFile(fileInfo) {
+				continue
+			}
+			if fileInfo.Size > maxPolicyFileSize {
+				return nil, "", fmt.Errorf("policy file %s is too large", fileInfo.Name)
+			}
+
+			reader, err := p.client.File.Get(fileID)
+			if err != nil {
+				return nil, "", fmt.Errorf("failed to read policy file: %w", err)
+			}
+			data, err := io.ReadAll(io.LimitReader(reader, maxPolicyFileSize))
+			if err != nil {
+				return nil, "", fmt.Errorf("failed to read policy file: %w", err)
+			}
+
+			return fileInfo, string(data), nil
+		}
+	}
+
+	return nil, "", errors.New("no policy file found. Attach a .hujson or .json file to a post in this channel or thread first")
+}
+
+func isPolicyFile(fileInfo *model.FileInfo) bool {
+	switch strings.ToLower(fileInfo.Extension) {
+	case "hujson", "json":
+		return true
+	default:
+		return false
+	}
+}
+
+// formatValidationError renders the errors reported by the Tailscale validate endpoint.
+func formatValidationError(source string, testErr *tailscale.ACLTestError) string {
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("#### Validation failed\n`%s` was rejected by Tailscale", source))
+	if testErr.Message != "" {
+		b.WriteString(fmt.Sprintf(": %s", testErr.Message))
+	}
+	b.WriteString("\n")
+
+	for _, summary := range testErr.Data {
+		for _, e := range summary.Errors {
+			b.WriteString(fmt.Sprintf("- **%s**: %s\n", summary.User, e))
+		}
+		for _, w := range summary.Warnings {
+			b.WriteString(fmt.Sprintf("- **%s** (warning): %s\n", summary.User, w))
+		}
+	}
+
+	return b.String()
+}
+
+func (p *Plugin) handlePolicyChangeAction(userID string, request *model.PostActionIntegrationRequest) (string, error) {
+	var change pendingPolicyChange
+	found, err := p.takePendingChange(pendingPolicyChangeKey(contextString(request, "change_id")), userID, &change)
+	if err != nil {
+		return "", err
+	}
+
+	switch {
+	case !found:
+		return "This policy change has expired. Run the command again to propose it.", nil
+	case contextString(request, "action") == "apply":
+		return p.applyPolicyChange(&change), nil
+	default:
+		return fmt.Sprintf("Cancelled applying `%s`.", change.Source), nil
+	}
+}
+
+// errPolicyModified is returned when the policy was changed after a diff was computed against it.
+var errPolicyModified = errors.New("the policy was modified since the diff was computed")
+
+// applyPolicyChange pushes a confirmed policy change and returns a message describing the outcome.
+func (p *Plugin) applyPolicyChange(change *pendingPolicyChange) string {
+	if _, err := p.pushPolicyChange(change); err != nil {
+		if errors.Is(err, errPolicyModified) {
+			return "The policy was modified since the diff was computed. Run the command again to review the latest changes."
+		}
+		return fmt.Sprintf("Failed to apply `%s`: %s", change.Source, err.Error())
+	}
+
+	return fmt.Sprintf("Successfully applied `%s` to tailnet %s.", change.Source, change.Tailnet)
+}
+
+// pushPolicyChange applies a policy change to the tailnet using the credentials of the user who
+// proposed it. The change is rejected if the policy was modified after the change was computed.
+func (p *Plugin) pushPolicyChange(change *pendingPolicyChange) (*tailscale.ACLHuJSON, error) {
+	config, err := p.getUserTailscaleConfig(change.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve Tailscale configuration: %w", err)
+	}
+	if config == nil || config.Tailnet != change.Tailnet {
+		return nil, fmt.Errorf("the proposing user is no longer connected to tailnet %s", change.Tailnet)
+	}
+
+	return p.pushPolicyChangeWithClient(p.newTailscaleClient(config.Tailnet, config.APIKey), change)
+}
+
+// pushPolicyChangeWithClient applies a policy change to the tailnet using the given client. The
+// change is rejected if the policy was modified after the change was computed.
+func (p *Plugin) pushPolicyChangeWithClient(client *tailscale.Client, change *pendingPolicyChange) (*tailscale.ACLHuJSON, error) {
+	res, err := client.SetACLHuJSON(context.Background(), tailscale.ACLHuJSON{
+		ACL:  change.Policy,
+		ETag: change.ETag,
+	}, true)
+	if err != nil {
+		var testErr tailscale.ACLTestError
+		if errors.As(err, &testErr) && testErr.Status == http.StatusPreconditionFailed {
+			return nil, errPolicyModified
+		}
+		return nil, err
+	}
+
+	p.API.LogInfo("Applied tailnet policy", "tailnet", change.Tailnet, "user_id", change.UserID, "source", change.Source)
+	p.recordPolicyVersion(change.TailnetID, change.Policy, res.ETag, change.UserID, policyVersionApplied, change.Source)
+
+	return res, nil
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+const (
+	policyChangeRequestPending  = "pending"
+	policyChangeRequestApplying = "applying"
+	policyChangeRequestApplied  = "applied"
+	policyChangeRequestRejected = "rejected"
+	policyChangeRequestFailed   = "failed"
+
+	// maxKVUpdateAttempts bounds the retries of compare-and-set updates of KV entries.
+	maxKVUpdateAttempts = 5
+)
+
+// policyChangeRequest is a policy change that needs to be approved by the configured approvers
+// before it is applied. Trail records every decision made on the request.
+type policyChangeRequest struct {
+	pendingPolicyChange
+
+	ChannelID string
+	PostID    string
+	Diff      string
+	Required  int
+	Status    string
+	Trail     []policyChangeEvent
+}
+
+// policyChangeEvent is a single entry of the approval trail of a policy change request.
+type policyChangeEvent struct {
+	UserID   string
+	Action   string
+	Detail   string
+	CreateAt int64
+}
+
+func policyChangeRequestKey(id string) string {
+	return "acl_request_" + id
+}
+
+// approvals returns the number of approvals the request has received.
+func (r *policyChangeRequest) approvals() int {
+	count := 0
+	for _, event := range r.Trail {
+		if event.Action == "approved" {
+			count++
+		}
+	}
+
+	return count
+}
+
+// hasDecided reports whether the user already approved or rejected the request.
+func (r *policyChangeRequest) hasDecided(userID string) bool {
+	for _, event := range r.Trail {
+		if event.UserID == userID && (event.Action == "approved" || event.Action == "rejected") {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (r *policyChangeRequest) addEvent(userID, action, detail string) {
+	r.Trail = append(r.Trail, policyChangeEvent{
+		UserID:   userID,
+		Action:   action,
+		Detail:   detail,
+		CreateAt: model.GetMillis(),
+	})
+}
+
+// createPolicyChangeRequest posts a validated policy change into the channel for approval.
+func (p *Plugin) createPolicyChangeRequest(args *model.CommandArgs, change *pendingPolicyChange, diff string) error {
+	config := p.getConfiguration()
+	if len(config.getPolicyApprovers()) == 0 {
+		return errors.New("policy changes require approval, but no approvers are configured. Ask a System Admin to configure the policy approvers")
+	}
+
+	request := &policyChangeRequest{
+		pendingPolicyChange: *change,
+		ChannelID:           args.ChannelId,
+		Diff:                diff,
+		Required:            config.PolicyApprovalsRequired,
+		Status:              policyChangeRequestPending,
+	}
+	request.addEvent(args.UserId, "proposed", change.Source)
+
+	post := &model.Post{
+		ChannelId: args.ChannelId,
+		RootId:    args.RootId,
+		UserId:    p.botID,
+	}
+	p.renderPolicyChangeRequest(post, request)
+	if err := p.client.Post.CreatePost(post); err != nil {
+		return fmt.Errorf("failed to post policy change request: %w", err)
+	}
+
+	request.PostID = post.Id
+	data, err := json.Marshal(request)
+	if err != nil {
+		return fmt.Errorf("failed to marshal policy change request: %w", err)
+	}
+	if appErr := p.API.KVSet(policyChangeRequestKey(request.ID), data); appErr != nil {
+		return fmt.Errorf("failed to store policy change request: %w", appErr)
+	}
+
+	p.API.LogInfo("Policy change requested", "request_id", request.ID, "tailnet", request.Tailnet, "user_id", args.UserId)
+
+	return nil
+}
+
+// renderPolicyChangeRequest sets the message and buttons of a policy change request post.
+func (p *Plugin) renderPolicyChangeRequest(post *model.Post, request *policyChangeRequest) {
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("#### Policy change request for %s\n", request.Tailnet))
+	b.WriteString(fmt.Sprintf("%s proposed applying `%s`. **%d of %d** required approvals.\n",
+		p.mentionUser(request.UserID), request.Source, request.approvals(), request.Required))
+	b.WriteString(formatDiff(request.Diff))
+	b.WriteString("\n\n**Approval trail:**\n")
+	for _, event := range request.Trail {
+		b.WriteString(fmt.Sprintf("- %s: %s %s", time.UnixMilli(event.CreateAt).UTC().Format(time.RFC3339), p.mentionUser(event.UserID), event.Action))
+		if event.Detail != "" && event.Action != "proposed" {
+			b.WriteString(fmt.Sprintf(" (%s)", event.Detail))
+		}
+		b.WriteString("\n")
+	}
+	b.WriteString(fmt.Sprintf("\n**Status:** %s", request.Status))
+
+	post.Message = b.String()
+	post.DelProp("attachments")
+
+	if request.Status != policyChangeRequestPending {
+		return
+	}
+
+	model.ParseSlackAttachment(post, []*model.SlackAttachment{{
+		Actions: []*model.PostAction{
+			policyChangeRequestAction("Approve", "approve", "success", request.ID),
+			policyChangeRequestAction("Reject", "reject", "danger", request.ID),
+		},
+	}})
+}
+
+func policyChangeRequestAction(name, action, style, requestID string) *model.PostAction {
+	return &model.PostAction{
+		Type:  model.PostActionTypeButton,
+		Name:  name,
+		Style: style,
+		Integration: &model.PostActionIntegration{
+			URL: actionURL("/api/v1/acl/requests"),
+			Context: map[string]any{
+				"request_id": requestID,
+				"action":     action,
+			},
+		},
+	}
+}
+
+// mentionUser returns an @-mention for the user, falling back to the user ID if the user cannot
+// be found.
+func (p *Plugin) mentionUser(userID string) string {
+	user, err := p.client.User.Get(userID)
+	if err != nil {
+		return userID
+	}
+
+	return "@" + user.Username
+}
+
+// isPolicyApprover reports whether the user is one of the configured policy approvers.
+func (p *Plugin) isPolicyApprover(userID string) (bool, error) {
+	user, err := p.client.User.Get(userID)
+	if err != nil {
+		return false, fmt.Errorf("failed to get user: %w", err)
+	}
+
+	return slices.Contains(p.getConfiguration().getPolicyApprovers(), strings.ToLower(user.Username)), nil
+}
+
+// updatePolicyChangeRequest atomically modifies a stored policy change request. update returns an
+// error to abort the modification.
+func (p *Plugin) updatePolicyChangeRequest(id string, update func(request *policyChangeRequest) error) (*policyChangeRequest, error) {
+	key := policyChangeRequestKey(id)
+
+	for attempt := 0; attempt < maxKVUpdateAttempts; attempt++ {
+		oldData, appErr := p.API.KVGet(key)
+		if appErr != nil {
+			return nil, fmt.Errorf("failed to get policy change request: %w", appErr)
+		}
+		if oldData == nil {
+			return nil, errors.New("the policy change request no longer exists")
+		}
+
+		var request policyChangeRequest
+		if err := json.Unmarshal(oldData, &request); err != nil {
+			return nil, fmt.Errorf("failed to decode policy change request: %w", err)
+		}
+
+		if err := update(&request); err != nil {
+			return nil, err
+		}
+
+		newData, err := json.Marshal(request)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal policy change request: %w", err)
+		}
+
+		ok, appErr := p.API.KVCompareAndSet(key, oldData, newData)
+		if appErr != nil {
+			return nil, fmt.Errorf("failed to store policy change request: %w", appErr)
+		}
+		if ok {
+			return &request, nil
+		}
+	}
+
+	return nil, errors.New("the policy change request was modified concurrently, please try again")
+}
+
+func (p *Plugin) handlePolicyChangeRequestAction(userID string, actionRequest *model.PostActionIntegrationRequest) (string, error) {
+	isApprover, err := p.isPolicyApprover(userID)
+	if err != nil {
+		p.API.LogError("Failed to check policy approver", "error", err.Error())
+		return "", errors.New("failed to check policy approver")
+	}
+	if !isApprover {
+		return "", errors.New("only the configured policy approvers can approve or reject policy changes")
+	}
+
+	action := contextString(actionRequest, "action")
+	request, err := p.updatePolicyChangeRequest(contextString(actionRequest, "request_id"), func(request *policyChangeRequest) error {
+		if err := checkDecision("policy change request", request.Status, request.UserID, userID); err != nil {
+			return err
+		}
+		if request.hasDecided(userID) {
+			return errors.New("you already decided on this policy change request")
+		}
+
+		switch action {
+		case "approve":
+			request.addEvent(userID, "approved", "")
+			if request.approvals() >= request.Required {
+				request.Status = policyChangeRequestApplying
+			}
+		case "reject":
+			request.addEvent(userID, "rejected", "")
+			request.Status = policyChangeRequestRejected
+		default:
+			return fmt.Errorf("unknown action %q", action)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	p.API.LogInfo("Policy change request decision", "request_id", request.ID, "user_id", userID, "action", action)
+
+	if request.Status == policyChangeRequestApplying {
+		request, err = p.applyPolicyChangeRequest(request)
+		if err != nil {
+			p.API.LogError("Failed to update policy change request", "request_id", request.ID, "error", err.Error())
+		}
+	}
+
+	if err := p.updatePolicyChangeRequestPost(request); err != nil {
+		p.API.LogError("Failed to update policy change request post", "request_id", request.ID, "error", err.Error())
+	}
+
+	return "", nil
+}
+
+// applyPolicyChangeRequest pushes an approved policy change and records the outcome in the trail.
+func (p *Plugin) applyPolicyChangeRequest(request *policyChangeRequest) (*policyChangeRequest, error) {
+	status, detail := policyChangeRequestApplied, ""
+	if _, err := p.pushPolicyChange(&request.pendingPolicyChange); err != nil {
+		status, detail = policyChangeRequestFailed, err.Error()
+	}
+
+	updated, err := p.updatePolicyChangeRequest(request.ID, func(request *policyChangeRequest) error {
+		request.Status = status
+		request.addEvent(p.botID, status, detail)
+		return nil
+	})
+	if err != nil {
+		return request, err
+	}
+
+	return updated, nil
+}
+
+func (p *Plugin) updatePolicyChangeRequestPost(request *policyChangeRequest) error {
+	post, err := p.client.Post.GetPost(request.PostID)
+	if err != nil {
+		return fmt.Errorf("failed to get post: %w", err)
+	}
+
+	p.renderPolicyChangeRequest(post, request)
+
+	return p.client.Post.UpdatePost(post)
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+const (
+	// maxPolicyVersions is the number of policy versions kept per tailnet.
+	maxPolicyVersions = 100
+
+	policyVersionObserved = "observed"
+	policyVersionApplied  = "applied"
+)
+
+// policyVersion describes a version of a tailnet policy seen or applied by the plugin. The policy
+// itself is stored separately under policyVersionKey. The history is keyed by the tailnet ID, as
+// the tailnet name users connect with does not tell tailnets apart.
+type policyVersion struct {
+	Version  int
+	ETag     string
+	AuthorID string
+	Action   string
+	Detail   string
+	CreateAt int64
+}
+
+func policyHistoryKey(tailnetID string) string {
+	return "acl_history_" + tailnetID
+}
+
+func policyVersionKey(tailnetID string, version int) string {
+	return fmt.Sprintf("acl_version_%s_%d", tailnetID, version)
+}
+
+// recordPolicyVersion stores a policy as a new version, unless it matches the latest recorded
+// version. authorID is empty if the author of the change is unknown. Failures are logged, as
+// recording the history must not interfere with the operation that observed the policy.
+func (p *Plugin) recordPolicyVersion(tailnetID, huJSON, etag, authorID, action, detail string) {
+	var recorded *policyVersion
+	var pruned []int
+	err := p.client.KV.SetAtomicWithRetries(policyHistoryKey(tailnetID), func(oldValue []byte) (any, error) {
+		recorded, pruned = nil, nil
+
+		var versions []policyVersion
+		if oldValue != nil {
+			if err := json.Unmarshal(oldValue, &versions); err != nil {
+				return nil, err
+			}
+		}
+
+		next := 1
+		if len(versions) > 0 {
+			latest := versions[len(versions)-1]
+			if etag != "" && latest.ETag == etag {
+				return versions, nil
+			}
+			next = latest.Version + 1
+		}
+
+		versions = append(versions, policyVersion{
+			Version:  next,
+			ETag:     etag,
+			AuthorID: authorID,
+			Action:   action,
+			Detail:   detail,
+			CreateAt: model.GetMillis(),
+		})
+		recorded = &versions[len(versions)-1]
+
+		for len(versions) > maxPolicyVersions {
+			pruned = append(pruned, versions[0].Version)
+			versions = versions[1:]
+		}
+
+		return versions, nil
+	})
+	if err != nil {
+		p.API.LogWarn("Failed to record policy version", "tailnet_id", tailnetID, "error", err.Error())
+		return
+	}
+
+	if recorded == nil {
+		return
+	}
+
+	if appErr := p.API.KVSet(policyVersionKey(tailnetID, recorded.Version), []byte(huJSON)); appErr != nil {
+		p.API.LogWarn("Failed to store policy version", "tailnet_id", tailnetID, "version", recorded.Version, "error", appErr.Error())
+	}
+	for _, version := range pruned {
+		if appErr := p.API.KVDelete(policyVersionKey(tailnetID, version)); appErr != nil {
+			p.API.LogWarn("Failed to delete policy version", "tailnet_id", tailnetID, "version", version, "error", appErr.Error())
+		}
+	}
+}
+
+func (p *Plugin) getPolicyHistory(tailnetID string) ([]policyVersion, error) {
+	var versions []policyVersion
+	if err := p.client.KV.Get(policyHistoryKey(tailnetID), &versions); err != nil {
+		return nil, fmt.Errorf("failed to get policy history: %w", err)
+	}
+
+	return versions, nil
+}
+
+// getPolicyVersion returns the metadata and content of a recorded policy version.
+func (p *Plugin) getPolicyVersion(tailnetID, version string) (*policyVersion, string, error) {
+	number, err := strconv.Atoi(strings.TrimPrefix(version, "v"))
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid version %q", version)
+	}
+
+	versions, err := p.getPolicyHistory(tailnetID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	for i := range versions {
+		if versions[i].Version != number {
+			continue
+		}
+
+		data, appErr := p.API.KVGet(policyVersionKey(tailnetID, number))
+		if appErr != nil {
+			return nil, "", fmt.Errorf("failed to get policy version: %w", appErr)
+		}
+		if data == nil {
+			break
+		}
+
+		return &versions[i], string(data), nil
+	}
+
+	return nil, "", errors.Errorf("version %d of the policy does not exist. Use `/tailscale acl history` to list the available versions", number)
+}
+
+func (p *Plugin) handleACLHistory(args *model.CommandArgs) error {
+	client, config, err := p.getTailscaleClient(args)
+	if err != nil || client == nil {
+		return err
+	}
+
+	// Reading the policy proves the user still has access to the tailnet.
+	_, tailnetID, err := p.fetchTailnetACL(client)
+	if err != nil {
+		return err
+	}
+
+	versions, err := p.getPolicyHistory(tailnetID)
+	if err != nil {
+		return err
+	}
+
+	if len(versions) == 0 {
+		p.postEphemeral(args.UserId, args.ChannelId, fmt.Sprintf("No policy versions have been recorded for tailnet %s yet.", config.Tailnet))
+		return nil
+	}
+
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("#### Policy history of %s\n| Version | Time | Author | Action | ETag |\n| --- | --- | --- | --- | --- |\n", config.Tailnet))
+	for i := len(versions) - 1; i >= 0; i-- {
+		version := versions[i]
+
+		author := "-"
+		if version.AuthorID != "" {
+			author = p.mentionUser(version.AuthorID)
+		}
+		action := version.Action
+		if version.Detail != "" {
+			action += fmt.Sprintf(" (`%s`)", version.Detail)
+		}
+
+		b.WriteString(fmt.Sprintf("| %d | %s | %s | %s | `%s` |\n",
+			version.Version, time.UnixMilli(version.CreateAt).UTC().Format(time.RFC3339), author, action, shortETag(version.ETag)))
+	}
+
+	return p.postEphemeralOrFile(args.UserId, args.ChannelId, b.String(), "history.md", []byte(b.String()), "")
+}
+
+func (p *Plugin) handleACLShow(args *model.CommandArgs, version string) error {
+	client, config, err := p.getTailscaleClient(args)
+	if err != nil || client == nil {
+		return err
+	}
+
+	// Reading the policy proves the user still has access to the tailnet.
+	_, tailnetID, err := p.fetchTailnetACL(client)
+	if err != nil {
+		return err
+	}
+
+	meta, policy, err := p.getPolicyVersion(tailnetID, version)
+	if err != nil {
+		return err
+	}
+
+	policy = strings.TrimSpace(policy)
+	title := fmt.Sprintf("#### Policy version %d of %s", meta.Version, config.Tailnet)
+	message := fmt.Sprintf("%s\n```\n%s\n```", title, policy)
+	fileName := fmt.Sprintf("policy-v%d.hujson", meta.Version)
+
+	return p.postEphemeralOrFile(args.UserId, args.ChannelId, message, fileName, []byte(policy+"\n"), title)
+}
+
+func (p *Plugin) handleACLRollback(args *model.CommandArgs, version string) error {
+	client, config, err := p.getTailscaleClient(args)
+	if err != nil || client == nil {
+		return err
+	}
+
+	_, tailnetID, err := p.fetchTailnetACL(client)
+	if err != nil {
+		return err
+	}
+
+	meta, policy, err := p.getPolicyVersion(tailnetID, version)
+	if err != nil {
+		return err
+	}
+
+	return p.proposePolicyChange(args, client, config, policy, fmt.Sprintf("version %d", meta.Version))
+}
+
+// shortETag shortens an ETag for display.
+func shortETag(etag string) string {
+	etag = strings.Trim(strings.TrimPrefix(etag, "W/"), `"`)
+	if len(etag) > 12 {
+		return etag[:12]
+	}
+	if etag == "" {
+		return "-"
+	}
+
+	return etag
+}
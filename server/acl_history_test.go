@@ -0,0 +1,68 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+func TestPolicyHistory(t *testing.T) {
+	const (
+		policyA = `{"acls": [{"action": "accept", "src": ["group:a"], "dst": ["*:*"]}]}`
+		policyB = `{"acls": [{"action": "accept", "src": ["group:b"], "dst": ["*:*"]}]}`
+	)
+
+	setup := func(t *testing.T) (*Plugin, *fakeAPI, *fakeTailscale) {
+		p, api, ts := newTestPlugin(t)
+		ts.addTailnet("key-a", &fakeTailnet{ID: "1001", Policy: policyA})
+		ts.addTailnet("key-b", &fakeTailnet{ID: "2002", Policy: policyB})
+		// Both users connect to the default tailnet of their API key.
+		connectUser(t, p, "alice", "-", "key-a")
+		connectUser(t, p, "bob", "-", "key-b")
+		return p, api, ts
+	}
+
+	t.Run("versions are recorded per tailnet", func(t *testing.T) {
+		p, api, ts := setup(t)
+
+		require.NoError(t, p.handleACLHistory(&model.CommandArgs{UserId: "alice"}))
+		ts.tailnet("key-a", func(tailnet *fakeTailnet) {
+			tailnet.Policy = `{"acls": []}`
+			tailnet.ETag = `"changed"`
+		})
+		require.NoError(t, p.handleACLHistory(&model.CommandArgs{UserId: "alice"}))
+		assert.Contains(t, api.lastEphemeral(t, "alice"), "| 2 |")
+
+		require.NoError(t, p.handleACLShow(&model.CommandArgs{UserId: "alice"}, "v1"))
+		assert.Equal(t, "#### Policy version 1 of -\n```\n"+policyA+"\n```", api.lastEphemeral(t, "alice"))
+	})
+
+	t.Run("same tailnet name does not share history", func(t *testing.T) {
+		p, api, _ := setup(t)
+
+		require.NoError(t, p.handleACLHistory(&model.CommandArgs{UserId: "alice"}))
+		require.NoError(t, p.handleACLShow(&model.CommandArgs{UserId: "bob"}, "1"))
+		message := api.lastEphemeral(t, "bob")
+		assert.Contains(t, message, "group:b")
+		assert.NotContains(t, message, "group:a")
+
+		versions, err := p.getPolicyHistory("2002")
+		require.NoError(t, err)
+		assert.Len(t, versions, 1)
+	})
+
+	t.Run("revoked credentials", func(t *testing.T) {
+		p, api, ts := setup(t)
+
+		require.NoError(t, p.handleACLHistory(&model.CommandArgs{UserId: "alice"}))
+		posted := len(api.ephemeral["alice"])
+		ts.removeTailnet("key-a")
+
+		assert.Error(t, p.handleACLHistory(&model.CommandArgs{UserId: "alice"}))
+		assert.Error(t, p.handleACLShow(&model.CommandArgs{UserId: "alice"}, "1"))
+		assert.Len(t, api.ephemeral["alice"], posted)
+	})
+}
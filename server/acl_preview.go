@@ -0,0 +1,316 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+
+	"github.com/pkg/errors"
+	"tailscale.com/client/tailscale"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// handleACLAccess shows the rules that apply to a user and the destinations the user can reach.
+func (p *Plugin) handleACLAccess(args *model.CommandArgs, user string) error {
+	client, _, err := p.getTailscaleClient(args)
+	if err != nil || client == nil {
+		return err
+	}
+
+	acl, err := p.fetchACL(client)
+	if err != nil {
+		return err
+	}
+
+	preview, err := client.PreviewACLHuJSONForUser(context.Background(), *acl, user)
+	if err != nil {
+		return fmt.Errorf("failed to preview policy for %s: %w", user, err)
+	}
+
+	if len(preview.Matches) == 0 {
+		p.postEphemeral(args.UserId, args.ChannelId, fmt.Sprintf("#### Access for %s\nNo rules of the policy apply to %s.", user, user))
+		return nil
+	}
+
+	var destinations []string
+	for _, match := range preview.Matches {
+		for _, port := range match.Ports {
+			if !slices.Contains(destinations, port) {
+				destinations = append(destinations, port)
+			}
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("#### Access for %s\n", user))
+	b.WriteString(fmt.Sprintf("**Allowed destinations:** %s\n\n", formatNames(destinations)))
+	b.WriteString(renderRuleMatches(preview.Matches))
+
+	return p.postEphemeralOrFile(args.UserId, args.ChannelId, b.String(), "access.md", []byte(b.String()), "")
+}
+
+// handleACLCan checks whether a user, tag, address or device can reach a destination.
+func (p *Plugin) handleACLCan(args *model.CommandArgs, source, destination string) error {
+	client, _, err := p.getTailscaleClient(args)
+	if err != nil || client == nil {
+		return err
+	}
+
+	acl, err := p.fetchACL(client)
+	if err != nil {
+		return err
+	}
+
+	pol, err := parsePolicy(acl.ACL)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	devices, err := client.Devices(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve devices from Tailscale API: %w", err)
+	}
+
+	// Users and devices are known by the autogroups of their type and role, which are only
+	// returned by the users API.
+	var users []*tailnetUser
+	if !isTag(source) && !strings.HasPrefix(source, "group:") && !strings.HasPrefix(source, "autogroup:") {
+		users, err = listTailnetUsers(ctx, client)
+		if err != nil {
+			return fmt.Errorf("failed to retrieve users from Tailscale API: %w", err)
+		}
+	}
+
+	src, err := resolveSource(pol, devices, users, source)
+	if err != nil {
+		return err
+	}
+
+	ipPort, err := resolveDestination(pol, devices, destination)
+	if err != nil {
+		return err
+	}
+
+	preview, err := client.PreviewACLHuJSONForIPPort(ctx, *acl, ipPort)
+	if err != nil {
+		return fmt.Errorf("failed to preview policy for %s: %w", destination, err)
+	}
+
+	var allowing []tailscale.UserRuleMatch
+	for _, match := range preview.Matches {
+		if slices.ContainsFunc(match.Users, func(entry string) bool { return src.matches(pol, entry) }) {
+			allowing = append(allowing, match)
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("#### Can %s reach %s?\n", source, destination))
+	if ipPort != destination {
+		b.WriteString(fmt.Sprintf("_%s resolves to %s._\n", destination, ipPort))
+	}
+	if len(allowing) > 0 {
+		b.WriteString(fmt.Sprintf(":white_check_mark: **Yes.** %s is allowed by the following rules:\n\n", source))
+		b.WriteString(renderRuleMatches(allowing))
+	} else {
+		b.WriteString(fmt.Sprintf(":no_entry: **No.** None of the rules for %s match %s.\n", ipPort, source))
+		if len(preview.Matches) > 0 {
+			b.WriteString("\nRules matching the destination:\n\n")
+			b.WriteString(renderRuleMatches(preview.Matches))
+		}
+	}
+
+	return p.postEphemeralOrFile(args.UserId, args.ChannelId, b.String(), "access.md", []byte(b.String()), "")
+}
+
+// renderRuleMatches renders the rules returned by the policy preview endpoints as a table.
+func renderRuleMatches(matches []tailscale.UserRuleMatch) string {
+	var b strings.Builder
+	b.WriteString("| Line | Sources | Destinations |\n| --- | --- | --- |\n")
+	for _, match := range matches {
+		b.WriteString(fmt.Sprintf("| %d | %s | %s |\n", match.LineNumber, joinCell(match.Users), joinCell(match.Ports)))
+	}
+
+	return b.String()
+}
+
+// accessSource is the source of an access check: the policy names it is known by and the
+// addresses of its devices.
+type accessSource struct {
+	names []string
+	addrs []netip.Addr
+}
+
+// addDevice adds the Tailscale addresses of a device to the source.
+func (s *accessSource) addDevice(device *tailscale.Device) {
+	for _, address := range device.Addresses {
+		if addr, err := netip.ParseAddr(address); err == nil {
+			s.addrs = append(s.addrs, addr)
+		}
+	}
+}
+
+// matches reports whether a source entry of a policy rule applies to the source. An entry is a
+// policy name, an IP address, a CIDR range or a host of the policy.
+func (s *accessSource) matches(pol *policy, entry string) bool {
+	if entry == "*" || slices.ContainsFunc(s.names, func(name string) bool { return strings.EqualFold(name, entry) }) {
+		return true
+	}
+
+	if address, ok := pol.Hosts[entry]; ok {
+		entry = address
+	}
+	prefix, err := parsePrefix(entry)
+	if err != nil {
+		return false
+	}
+
+	return slices.ContainsFunc(s.addrs, prefix.Contains)
+}
+
+// resolveSource looks up a source, which is a user login, a tag, a group, an autogroup, an IP
+// address, a host of the policy or the name of a device.
+func resolveSource(pol *policy, devices []*tailscale.Device, users []*tailnetUser, source string) (*accessSource, error) {
+	switch {
+	case isTag(source):
+		src := &accessSource{names: []string{source, "autogroup:tagged"}}
+		for _, device := range devices {
+			if slices.Contains(device.Tags, source) {
+				src.addDevice(device)
+			}
+		}
+		return src, nil
+	case strings.HasPrefix(source, "group:"), strings.HasPrefix(source, "autogroup:"):
+		return &accessSource{names: []string{source}}, nil
+	case strings.Contains(source, "@"):
+		src := &accessSource{names: userIdentities(pol, users, source)}
+		for _, device := range devices {
+			if len(device.Tags) == 0 && strings.EqualFold(device.User, source) {
+				src.addDevice(device)
+			}
+		}
+		return src, nil
+	}
+
+	address := source
+	if host, ok := pol.Hosts[source]; ok {
+		address = host
+	}
+	if prefix, err := parsePrefix(address); err == nil {
+		if !prefix.IsSingleIP() {
+			return nil, errors.Errorf("%q is a range of addresses, use a single address instead", source)
+		}
+		// An address of a device is known by the names of the device, too.
+		src := &accessSource{addrs: []netip.Addr{prefix.Addr()}}
+		for _, device := range devices {
+			if slices.Contains(device.Addresses, prefix.Addr().String()) {
+				src.names = deviceIdentities(pol, users, device)
+			}
+		}
+		return src, nil
+	}
+
+	device := findDevice(devices, source)
+	if device == nil {
+		return nil, errors.Errorf("%q is neither a user, a tag, an address nor a device in your tailnet", source)
+	}
+
+	src := &accessSource{names: deviceIdentities(pol, users, device)}
+	src.addDevice(device)
+
+	return src, nil
+}
+
+// deviceIdentities returns the policy names a device is known by: its tags or, if it is not
+// tagged, the identities of its user.
+func deviceIdentities(pol *policy, users []*tailnetUser, device *tailscale.Device) []string {
+	if len(device.Tags) > 0 {
+		return append(append([]string{}, device.Tags...), "autogroup:tagged")
+	}
+
+	return userIdentities(pol, users, device.User)
+}
+
+// userIdentities returns a user login together with the groups of the policy and the autogroups
+// the user is a member of.
+func userIdentities(pol *policy, users []*tailnetUser, login string) []string {
+	identities := []string{login}
+	for _, user := range users {
+		if !strings.EqualFold(user.LoginName, login) {
+			continue
+		}
+		switch user.Type {
+		case "member":
+			identities = append(identities, "autogroup:member")
+		case "shared":
+			identities = append(identities, "autogroup:shared")
+		}
+		if user.Role != "" && user.Role != "member" {
+			identities = append(identities, "autogroup:"+user.Role)
+		}
+	}
+
+	for _, group := range sortedKeys(pol.Groups) {
+		if slices.ContainsFunc(pol.Groups[group], func(member string) bool { return strings.EqualFold(member, login) }) {
+			identities = append(identities, group)
+		}
+	}
+
+	return identities
+}
+
+// resolveDestination turns a host:port destination into ip:port, looking up the host in the hosts
+// section of the policy and the devices of the tailnet.
+func resolveDestination(pol *policy, devices []*tailscale.Device, destination string) (string, error) {
+	if isIPPort(destination) {
+		return destination, nil
+	}
+
+	host, port, err := net.SplitHostPort(destination)
+	if err != nil {
+		return "", errors.Errorf("invalid destination %q, expected host:port", destination)
+	}
+
+	if address, ok := pol.Hosts[host]; ok {
+		if prefix, err := netip.ParsePrefix(address); err == nil {
+			address = prefix.Addr().String()
+		}
+		return net.JoinHostPort(address, port), nil
+	}
+
+	if device := findDevice(devices, host); device != nil && len(device.Addresses) > 0 {
+		return net.JoinHostPort(device.Addresses[0], port), nil
+	}
+
+	return "", errors.Errorf("%q is neither a host in the policy nor a device in your tailnet", host)
+}
+
+// parsePrefix parses a CIDR range or a single IP address.
+func parsePrefix(value string) (netip.Prefix, error) {
+	if addr, err := netip.ParseAddr(value); err == nil {
+		return netip.PrefixFrom(addr, addr.BitLen()), nil
+	}
+
+	return netip.ParsePrefix(value)
+}
+
+func isIPPort(value string) bool {
+	_, err := netip.ParseAddrPort(value)
+	return err == nil
+}
+
+// findDevice looks up a device by its hostname or machine name.
+func findDevice(devices []*tailscale.Device, name string) *tailscale.Device {
+	for _, device := range devices {
+		machineName, _, _ := strings.Cut(device.Name, ".")
+		if strings.EqualFold(device.Hostname, name) || strings.EqualFold(machineName, name) || strings.EqualFold(device.Name, name) {
+			return device
+		}
+	}
+
+	return nil
+}
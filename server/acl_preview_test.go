@@ -0,0 +1,117 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"tailscale.com/client/tailscale"
+)
+
+func TestResolveSource(t *testing.T) {
+	pol := &policy{
+		Groups: map[string][]string{
+			"group:eng": {"Alice@example.com"},
+			"group:ops": {"bob@example.com"},
+		},
+		Hosts: map[string]string{
+			"office":  "10.1.0.0/16",
+			"gateway": "100.64.0.9",
+		},
+	}
+	devices := []*tailscale.Device{
+		{Name: "laptop.tail123.ts.net", Hostname: "laptop", User: "alice@example.com", Addresses: []string{"100.64.0.1"}},
+		{Name: "build.tail123.ts.net", Hostname: "build", User: "alice@example.com", Tags: []string{"tag:ci"}, Addresses: []string{"100.64.0.2"}},
+		{Name: "guest.tail123.ts.net", Hostname: "guest", User: "carol@other.com", Addresses: []string{"100.64.0.3"}},
+		{Name: "gateway.tail123.ts.net", Hostname: "gateway", User: "bob@example.com", Addresses: []string{"100.64.0.9"}},
+	}
+	users := []*tailnetUser{
+		{LoginName: "alice@example.com", Type: "member", Role: "admin"},
+		{LoginName: "bob@example.com", Type: "member", Role: "member"},
+		{LoginName: "carol@other.com", Type: "shared", Role: "member"},
+	}
+
+	for name, tc := range map[string]struct {
+		source   string
+		matching []string
+		other    []string
+	}{
+		"user": {
+			source:   "alice@example.com",
+			matching: []string{"*", "alice@example.com", "group:eng", "autogroup:member", "autogroup:admin", "100.64.0.1", "100.64.0.0/10"},
+			other:    []string{"group:ops", "autogroup:shared", "autogroup:tagged", "tag:ci", "100.64.0.2", "office"},
+		},
+		"user without admin role": {
+			source:   "bob@example.com",
+			matching: []string{"group:ops", "autogroup:member", "gateway"},
+			other:    []string{"autogroup:admin", "group:eng"},
+		},
+		"shared user": {
+			source:   "carol@other.com",
+			matching: []string{"autogroup:shared", "100.64.0.3"},
+			other:    []string{"autogroup:member"},
+		},
+		"unknown user": {
+			source:   "dave@example.com",
+			matching: []string{"*", "dave@example.com"},
+			other:    []string{"autogroup:member", "100.64.0.0/10"},
+		},
+		"tag": {
+			source:   "tag:ci",
+			matching: []string{"tag:ci", "autogroup:tagged", "100.64.0.2"},
+			other:    []string{"alice@example.com", "autogroup:member", "100.64.0.1"},
+		},
+		"group": {
+			source:   "group:eng",
+			matching: []string{"group:eng"},
+			other:    []string{"alice@example.com", "group:ops"},
+		},
+		"tagged device": {
+			source:   "build",
+			matching: []string{"tag:ci", "autogroup:tagged", "100.64.0.2", "100.64.0.0/24"},
+			other:    []string{"alice@example.com", "group:eng", "autogroup:member"},
+		},
+		"user device": {
+			source:   "laptop",
+			matching: []string{"alice@example.com", "group:eng", "autogroup:member", "100.64.0.1"},
+			other:    []string{"tag:ci", "100.64.0.2"},
+		},
+		"device address": {
+			source:   "100.64.0.1",
+			matching: []string{"100.64.0.1", "alice@example.com", "group:eng"},
+			other:    []string{"100.64.0.2", "office"},
+		},
+		"address outside the tailnet": {
+			source:   "10.1.2.3",
+			matching: []string{"office", "10.0.0.0/8"},
+			other:    []string{"autogroup:member", "100.64.0.0/10"},
+		},
+		"host": {
+			source:   "gateway",
+			matching: []string{"gateway", "100.64.0.9", "bob@example.com", "group:ops"},
+			other:    []string{"group:eng"},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			src, err := resolveSource(pol, devices, users, tc.source)
+			require.NoError(t, err)
+
+			for _, entry := range tc.matching {
+				assert.True(t, src.matches(pol, entry), "expected %s to match", entry)
+			}
+			for _, entry := range tc.other {
+				assert.False(t, src.matches(pol, entry), "expected %s not to match", entry)
+			}
+		})
+	}
+
+	t.Run("address range", func(t *testing.T) {
+		_, err := resolveSource(pol, devices, users, "office")
+		assert.Error(t, err)
+	})
+
+	t.Run("unknown device", func(t *testing.T) {
+		_, err := resolveSource(pol, devices, users, "printer")
+		assert.Error(t, err)
+	})
+}
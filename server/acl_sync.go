@@ -0,0 +1,481 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/tailscale/hujson"
+	"tailscale.com/client/tailscale"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin"
+)
+
+const (
+	// policyGroupUpdateAttempts is how often a membership change is retried if the policy is
+	// modified concurrently.
+	policyGroupUpdateAttempts = 3
+
+	// channelMembersPageSize is the number of channel members fetched per request.
+	channelMembersPageSize = 200
+)
+
+// groupSync links the members of a channel to a group of a tailnet policy. Changes to the group
+// are made with the credentials of the admin who created the link. If policy changes require
+// approval, each change is posted to the channel as a policy change request.
+type groupSync struct {
+	ChannelID string
+	Tailnet   string
+	Group     string
+	UserID    string
+}
+
+func groupSyncKey(channelID string) string {
+	return "acl_sync_" + channelID
+}
+
+func (p *Plugin) getGroupSync(channelID string) (*groupSync, error) {
+	data, appErr := p.API.KVGet(groupSyncKey(channelID))
+	if appErr != nil {
+		return nil, appErr
+	}
+
+	if data == nil {
+		return nil, nil
+	}
+
+	var sync groupSync
+	if err := json.Unmarshal(data, &sync); err != nil {
+		return nil, err
+	}
+
+	return &sync, nil
+}
+
+// handleACLSync links the channel to a policy group. The group is reconciled with the current
+// members of the channel through the regular policy change flow, so the admin reviews the initial
+// diff before it is applied.
+func (p *Plugin) handleACLSync(args *model.CommandArgs, group string) error {
+	if !p.API.HasPermissionTo(args.UserId, model.PermissionManageSystem) {
+		p.postEphemeral(args.UserId, args.ChannelId, "Only system admins can link channels to policy groups.")
+		return nil
+	}
+
+	if !strings.HasPrefix(group, "group:") {
+		p.postEphemeral(args.UserId, args.ChannelId, fmt.Sprintf("`%s` is not a group. Group names start with `group:`.", group))
+		return nil
+	}
+
+	channel, appErr := p.API.GetChannel(args.ChannelId)
+	if appErr != nil {
+		return fmt.Errorf("failed to get channel: %w", appErr)
+	}
+	if channel.Type == model.ChannelTypeOpen && p.getConfiguration().PolicyApprovalsRequired == 0 {
+		p.postEphemeral(args.UserId, args.ChannelId, "Public channels can only be linked to policy groups if policy changes require approval, since anyone joining them would be added to the group. Make the channel private or ask a System Admin to configure policy approvals.")
+		return nil
+	}
+
+	client, config, err := p.getTailscaleClient(args)
+	if err != nil || client == nil {
+		return err
+	}
+
+	acl, err := p.fetchACL(client)
+	if err != nil {
+		return err
+	}
+
+	emails, err := p.channelMemberEmails(args.ChannelId)
+	if err != nil {
+		return err
+	}
+
+	pol, err := parsePolicy(acl.ACL)
+	if err != nil {
+		return err
+	}
+
+	var add, remove []string
+	for _, email := range emails {
+		if !containsFold(pol.Groups[group], email) {
+			add = append(add, email)
+		}
+	}
+	for _, member := range pol.Groups[group] {
+		if !containsFold(emails, member) {
+			remove = append(remove, member)
+		}
+	}
+
+	proposed, err := patchGroupMembers(acl.ACL, group, add, remove)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(groupSync{
+		ChannelID: args.ChannelId,
+		Tailnet:   config.Tailnet,
+		Group:     group,
+		UserID:    args.UserId,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal group sync: %w", err)
+	}
+	if appErr := p.API.KVSet(groupSyncKey(args.ChannelId), data); appErr != nil {
+		return fmt.Errorf("failed to store group sync: %w", appErr)
+	}
+
+	p.API.LogInfo("Linked channel to policy group", "channel_id", args.ChannelId, "tailnet", config.Tailnet, "group", group, "user_id", args.UserId)
+	p.postEphemeral(args.UserId, args.ChannelId, fmt.Sprintf("Members joining or leaving this channel will now be added to or removed from `%s` in tailnet %s.", group, config.Tailnet))
+
+	if proposed == acl.ACL {
+		return nil
+	}
+
+	return p.proposePolicyChange(args, client, config, proposed, fmt.Sprintf("sync of %s", group))
+}
+
+func (p *Plugin) handleACLUnsync(args *model.CommandArgs) error {
+	if !p.API.HasPermissionTo(args.UserId, model.PermissionManageSystem) {
+		p.postEphemeral(args.UserId, args.ChannelId, "Only system admins can unlink channels from policy groups.")
+		return nil
+	}
+
+	sync, err := p.getGroupSync(args.ChannelId)
+	if err != nil {
+		return fmt.Errorf("failed to get group sync: %w", err)
+	}
+	if sync == nil {
+		p.postEphemeral(args.UserId, args.ChannelId, "This channel is not linked to a policy group.")
+		return nil
+	}
+
+	if appErr := p.API.KVDelete(groupSyncKey(args.ChannelId)); appErr != nil {
+		return fmt.Errorf("failed to remove group sync: %w", appErr)
+	}
+
+	p.API.LogInfo("Unlinked channel from policy group", "channel_id", args.ChannelId, "tailnet", sync.Tailnet, "group", sync.Group, "user_id", args.UserId)
+	p.postEphemeral(args.UserId, args.ChannelId, fmt.Sprintf("This channel is no longer linked to `%s`. The group keeps its current members.", sync.Group))
+	return nil
+}
+
+// channelMemberEmails returns the email addresses of the human members of a channel.
+func (p *Plugin) channelMemberEmails(channelID string) ([]string, error) {
+	var emails []string
+	for page := 0; ; page++ {
+		members, appErr := p.API.GetChannelMembers(channelID, page, channelMembersPageSize)
+		if appErr != nil {
+			return nil, fmt.Errorf("failed to get channel members: %w", appErr)
+		}
+
+		for _, member := range members {
+			user, appErr := p.API.GetUser(member.UserId)
+			if appErr != nil {
+				return nil, fmt.Errorf("failed to get user: %w", appErr)
+			}
+			if user.IsBot || user.DeleteAt != 0 || user.Email == "" {
+				continue
+			}
+
+			emails = append(emails, strings.ToLower(user.Email))
+		}
+
+		if len(members) < channelMembersPageSize {
+			return emails, nil
+		}
+	}
+}
+
+// UserHasJoinedChannel adds users joining a linked channel to the policy group.
+func (p *Plugin) UserHasJoinedChannel(_ *plugin.Context, channelMember *model.ChannelMember, _ *model.User) {
+	p.syncGroupMember(channelMember, true)
+}
+
+// UserHasLeftChannel removes users leaving a linked channel from the policy group.
+func (p *Plugin) UserHasLeftChannel(_ *plugin.Context, channelMember *model.ChannelMember, _ *model.User) {
+	p.syncGroupMember(channelMember, false)
+}
+
+func (p *Plugin) syncGroupMember(channelMember *model.ChannelMember, joined bool) {
+	sync, err := p.getGroupSync(channelMember.ChannelId)
+	if err != nil {
+		p.API.LogError("Failed to get group sync", "channel_id", channelMember.ChannelId, "error", err.Error())
+		return
+	}
+	if sync == nil {
+		return
+	}
+
+	user, appErr := p.API.GetUser(channelMember.UserId)
+	if appErr != nil {
+		p.API.LogError("Failed to get user", "user_id", channelMember.UserId, "error", appErr.Error())
+		return
+	}
+	if user.IsBot || user.Email == "" {
+		return
+	}
+
+	email := strings.ToLower(user.Email)
+	var add, remove []string
+	action := "joined"
+	if joined {
+		add = []string{email}
+	} else {
+		remove = []string{email}
+		action = "left"
+	}
+
+	if p.getConfiguration().PolicyApprovalsRequired > 0 {
+		source := fmt.Sprintf("sync of %s: @%s %s the channel", sync.Group, user.Username, action)
+		if err := p.requestPolicyGroupChange(sync, add, remove, source); err != nil {
+			p.API.LogWarn("Failed to request policy group sync", "tailnet", sync.Tailnet, "group", sync.Group, "user_id", user.Id, "error", err.Error())
+			p.postGroupSyncSummary(sync, fmt.Sprintf("#### Policy group sync failed\nCould not request updating `%s` in tailnet %s for @%s: %s", sync.Group, sync.Tailnet, user.Username, err.Error()))
+		}
+		return
+	}
+
+	// Without approvals, anyone joining a public channel could add themselves to the group.
+	channel, appErr := p.API.GetChannel(sync.ChannelID)
+	if appErr != nil {
+		p.API.LogError("Failed to get channel", "channel_id", sync.ChannelID, "error", appErr.Error())
+		return
+	}
+	if channel.Type == model.ChannelTypeOpen {
+		p.API.LogWarn("Skipped policy group sync of public channel", "channel_id", sync.ChannelID, "group", sync.Group, "user_id", user.Id)
+		p.postGroupSyncSummary(sync, fmt.Sprintf("#### Policy group sync skipped\n@%s %s the channel, but `%s` is not updated because this channel is public and policy changes do not require approval. Make the channel private or ask a System Admin to configure policy approvals.", user.Username, action, sync.Group))
+		return
+	}
+
+	var message string
+	diff, err := p.updatePolicyGroupAs(sync.UserID, sync.Tailnet, sync.Group, add, remove, fmt.Sprintf("sync of %s", sync.Group))
+	switch {
+	case err != nil:
+		p.API.LogWarn("Failed to sync policy group", "tailnet", sync.Tailnet, "group", sync.Group, "user_id", user.Id, "error", err.Error())
+		message = fmt.Sprintf("#### Policy group sync failed\nCould not update `%s` in tailnet %s for @%s: %s", sync.Group, sync.Tailnet, user.Username, err.Error())
+	case diff == "":
+		return
+	case joined:
+		message = fmt.Sprintf("#### Policy group updated\n@%s joined the channel, so `%s` was added to `%s` in tailnet %s.\n%s", user.Username, email, sync.Group, sync.Tailnet, formatDiff(diff))
+	default:
+		message = fmt.Sprintf("#### Policy group updated\n@%s left the channel, so `%s` was removed from `%s` in tailnet %s.\n%s", user.Username, email, sync.Group, sync.Tailnet, formatDiff(diff))
+	}
+
+	p.postGroupSyncSummary(sync, message)
+}
+
+func (p *Plugin) postGroupSyncSummary(sync *groupSync, message string) {
+	post := &model.Post{
+		ChannelId: sync.ChannelID,
+		UserId:    p.botID,
+		Message:   message,
+	}
+	if err := p.client.Post.CreatePost(post); err != nil {
+		p.API.LogWarn("Failed to post group sync summary", "channel_id", sync.ChannelID, "error", err.Error())
+	}
+}
+
+// requestPolicyGroupChange posts a change of the members of a linked policy group to the linked
+// channel as a policy change request. Nothing is posted if the group already is up to date.
+func (p *Plugin) requestPolicyGroupChange(sync *groupSync, add, remove []string, source string) error {
+	client, err := p.connectedClient(sync.UserID, sync.Tailnet)
+	if err != nil {
+		return err
+	}
+
+	acl, tailnetID, err := p.fetchTailnetACL(client)
+	if err != nil {
+		return err
+	}
+
+	proposed, err := patchGroupMembers(acl.ACL, sync.Group, add, remove)
+	if err != nil {
+		return err
+	}
+	if proposed == acl.ACL {
+		return nil
+	}
+
+	testErr, err := validateACL(context.Background(), client, proposed)
+	if err != nil {
+		return fmt.Errorf("failed to validate policy: %w", err)
+	}
+	if testErr != nil {
+		return errors.New(strings.TrimSpace(formatValidationError("the updated policy", testErr)))
+	}
+
+	diff, err := policyDiff(acl.ACL, proposed, "current", source)
+	if err != nil {
+		return fmt.Errorf("failed to compute diff: %w", err)
+	}
+
+	// The request is proposed by the admin who linked the channel, whose credentials apply it.
+	args := &model.CommandArgs{UserId: sync.UserID, ChannelId: sync.ChannelID}
+	return p.createPolicyChangeRequest(args, &pendingPolicyChange{
+		ID:        model.NewId(),
+		UserID:    sync.UserID,
+		Tailnet:   sync.Tailnet,
+		TailnetID: tailnetID,
+		Policy:    proposed,
+		ETag:      acl.ETag,
+		Source:    source,
+	}, diff)
+}
+
+// connectedClient returns a client using the credentials of a user, who must still be connected
+// to the given tailnet.
+func (p *Plugin) connectedClient(userID, tailnet string) (*tailscale.Client, error) {
+	config, err := p.getUserTailscaleConfig(userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve Tailscale configuration: %w", err)
+	}
+	if config == nil || config.Tailnet != tailnet {
+		return nil, fmt.Errorf("%s is no longer connected to tailnet %s", p.mentionUser(userID), tailnet)
+	}
+
+	return p.newTailscaleClient(config.Tailnet, config.APIKey), nil
+}
+
+// updatePolicyGroupAs adds members to and removes members from a policy group using the
+// credentials of the given user. See updatePolicyGroup.
+func (p *Plugin) updatePolicyGroupAs(userID, tailnet, group string, add, remove []string, source string) (string, error) {
+	client, err := p.connectedClient(userID, tailnet)
+	if err != nil {
+		return "", err
+	}
+
+	return p.updatePolicyGroup(client, userID, tailnet, group, add, remove, source)
+}
+
+// updatePolicyGroup adds members to and removes members from a policy group, then validates and
+// applies the policy with client. The change is recorded with authorID as its author. It returns
+// the diff of the applied change, which is empty if the group already was up to date.
+func (p *Plugin) updatePolicyGroup(client *tailscale.Client, authorID, tailnet, group string, add, remove []string, source string) (string, error) {
+	for attempt := 0; ; attempt++ {
+		acl, tailnetID, err := p.fetchTailnetACL(client)
+		if err != nil {
+			return "", err
+		}
+
+		proposed, err := patchGroupMembers(acl.ACL, group, add, remove)
+		if err != nil {
+			return "", err
+		}
+		if proposed == acl.ACL {
+			return "", nil
+		}
+
+		testErr, err := validateACL(context.Background(), client, proposed)
+		if err != nil {
+			return "", fmt.Errorf("failed to validate policy: %w", err)
+		}
+		if testErr != nil {
+			return "", errors.New(strings.TrimSpace(formatValidationError("the updated policy", testErr)))
+		}
+
+		diff, err := policyDiff(acl.ACL, proposed, "previous", "current")
+		if err != nil {
+			return "", fmt.Errorf("failed to compute diff: %w", err)
+		}
+
+		_, err = p.pushPolicyChangeWithClient(client, &pendingPolicyChange{
+			ID:        model.NewId(),
+			UserID:    authorID,
+			Tailnet:   tailnet,
+			TailnetID: tailnetID,
+			Policy:    proposed,
+			ETag:      acl.ETag,
+			Source:    source,
+		})
+		if errors.Is(err, errPolicyModified) && attempt < policyGroupUpdateAttempts-1 {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+
+		return diff, nil
+	}
+}
+
+// patchGroupMembers adds and removes members of a group in a HuJSON policy file. Comments and
+// formatting of the rest of the file are preserved. The group and the groups section are created
+// if needed.
+func patchGroupMembers(huJSON, group string, add, remove []string) (string, error) {
+	value, err := hujson.Parse([]byte(huJSON))
+	if err != nil {
+		return "", fmt.Errorf("failed to parse policy: %w", err)
+	}
+
+	standard := value.Clone()
+	standard.Standardize()
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(standard.Pack(), &raw); err != nil {
+		return "", fmt.Errorf("failed to decode policy: %w", err)
+	}
+
+	section := "groups"
+	for key := range raw {
+		if strings.EqualFold(key, "groups") {
+			section = key
+		}
+	}
+
+	var groups map[string][]string
+	if data, ok := raw[section]; ok {
+		if err := json.Unmarshal(data, &groups); err != nil {
+			return "", fmt.Errorf("failed to decode groups: %w", err)
+		}
+	}
+
+	sectionPath := "/" + escapeJSONPointer(section)
+	groupPath := sectionPath + "/" + escapeJSONPointer(group)
+
+	var ops []map[string]any
+	members, ok := groups[group]
+	switch {
+	case groups == nil:
+		ops = append(ops, map[string]any{"op": "add", "path": sectionPath, "value": map[string][]string{group: add}})
+	case !ok:
+		ops = append(ops, map[string]any{"op": "add", "path": groupPath, "value": add})
+	default:
+		for i := len(members) - 1; i >= 0; i-- {
+			if containsFold(remove, members[i]) {
+				ops = append(ops, map[string]any{"op": "remove", "path": fmt.Sprintf("%s/%d", groupPath, i)})
+			}
+		}
+		for _, member := range add {
+			if !containsFold(members, member) {
+				ops = append(ops, map[string]any{"op": "add", "path": groupPath + "/-", "value": member})
+			}
+		}
+	}
+
+	if len(ops) == 0 || (!ok && len(add) == 0) {
+		return huJSON, nil
+	}
+
+	patch, err := json.Marshal(ops)
+	if err != nil {
+		return "", err
+	}
+	if err := value.Patch(patch); err != nil {
+		return "", fmt.Errorf("failed to update %s: %w", group, err)
+	}
+
+	return value.String(), nil
+}
+
+// containsFold reports whether values contains value, ignoring case. Email addresses in policy
+// groups are compared this way, since Tailscale logins are not case sensitive.
+func containsFold(values []string, value string) bool {
+	return slices.ContainsFunc(values, func(v string) bool { return strings.EqualFold(v, value) })
+}
+
+// escapeJSONPointer escapes a name for use as a JSON pointer segment.
+func escapeJSONPointer(name string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(name)
+}
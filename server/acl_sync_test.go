@@ -0,0 +1,86 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPatchGroupMembers(t *testing.T) {
+	for name, tc := range map[string]struct {
+		policy   string
+		add      []string
+		remove   []string
+		expected string
+	}{
+		"add member": {
+			policy: `{
+	// Engineering
+	"groups": {
+		"group:eng": ["alice@example.com"],
+	},
+}`,
+			add: []string{"bob@example.com"},
+			expected: `{
+	// Engineering
+	"groups": {
+		"group:eng": ["alice@example.com","bob@example.com"],
+	},
+}`,
+		},
+		"remove member": {
+			policy:   `{"groups": {"group:eng": ["alice@example.com", "bob@example.com"]}}`,
+			remove:   []string{"alice@example.com"},
+			expected: `{"groups": {"group:eng": [ "bob@example.com"]}}`,
+		},
+		"remove member with different case": {
+			policy:   `{"groups": {"group:eng": ["Alice@Example.com", "bob@example.com"]}}`,
+			remove:   []string{"alice@example.com"},
+			expected: `{"groups": {"group:eng": [ "bob@example.com"]}}`,
+		},
+		"existing member with different case": {
+			policy:   `{"groups": {"group:eng": ["Alice@Example.com"]}}`,
+			add:      []string{"alice@example.com"},
+			expected: `{"groups": {"group:eng": ["Alice@Example.com"]}}`,
+		},
+		"add and remove": {
+			policy:   `{"groups": {"group:eng": ["alice@example.com", "bob@example.com", "carol@example.com"]}}`,
+			add:      []string{"dave@example.com"},
+			remove:   []string{"alice@example.com", "carol@example.com"},
+			expected: `{"groups": {"group:eng": [ "bob@example.com","dave@example.com"]}}`,
+		},
+		"create group": {
+			policy:   `{"groups": {"group:ops": []}}`,
+			add:      []string{"alice@example.com"},
+			expected: `{"groups": {"group:ops": [],"group:eng":["alice@example.com"]}}`,
+		},
+		"create groups section": {
+			policy:   `{"acls": []}`,
+			add:      []string{"alice@example.com"},
+			expected: `{"acls": [],"groups":{"group:eng":["alice@example.com"]}}`,
+		},
+		"remove from missing group": {
+			policy:   `{"groups": {}}`,
+			remove:   []string{"alice@example.com"},
+			expected: `{"groups": {}}`,
+		},
+		"nothing to do": {
+			policy:   `{"groups": {"group:eng": ["alice@example.com"]}}`,
+			add:      []string{"alice@example.com"},
+			remove:   []string{"bob@example.com"},
+			expected: `{"groups": {"group:eng": ["alice@example.com"]}}`,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			patched, err := patchGroupMembers(tc.policy, "group:eng", tc.add, tc.remove)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, patched)
+		})
+	}
+
+	t.Run("invalid policy", func(t *testing.T) {
+		_, err := patchGroupMembers(`{"groups": `, "group:eng", []string{"alice@example.com"}, nil)
+		assert.Error(t, err)
+	})
+}
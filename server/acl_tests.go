@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/tailscale/hujson"
+	"tailscale.com/client/tailscale"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// policyAssertion is a single expectation of a policy test: src can (or cannot) reach dst.
+type policyAssertion struct {
+	Src    string
+	Proto  string
+	Dst    string
+	Accept bool
+
+	Passed bool
+	Errors []string
+}
+
+// policyAssertions splits the tests of a policy into single assertions.
+func policyAssertions(pol *policy) []*policyAssertion {
+	var assertions []*policyAssertion
+	for _, test := range pol.Tests {
+		src := test.Src
+		if src == "" {
+			src = test.User
+		}
+
+		for _, dst := range append(append([]string{}, test.Accept...), test.Allow...) {
+			assertions = append(assertions, &policyAssertion{Src: src, Proto: test.Proto, Dst: dst, Accept: true})
+		}
+		for _, dst := range test.Deny {
+			assertions = append(assertions, &policyAssertion{Src: src, Proto: test.Proto, Dst: dst, Accept: false})
+		}
+	}
+
+	return assertions
+}
+
+// withTests returns a copy of a HuJSON policy file with its tests replaced by the given assertion.
+func withTests(huJSON string, assertion *policyAssertion) (string, error) {
+	value, err := hujson.Parse([]byte(huJSON))
+	if err != nil {
+		return "", fmt.Errorf("failed to parse policy: %w", err)
+	}
+
+	test := tailscale.ACLTest{Src: assertion.Src, Proto: assertion.Proto}
+	if assertion.Accept {
+		test.Accept = []string{assertion.Dst}
+	} else {
+		test.Deny = []string{assertion.Dst}
+	}
+
+	patch, err := json.Marshal([]map[string]any{{
+		"op":    "replace",
+		"path":  "/tests",
+		"value": []tailscale.ACLTest{test},
+	}})
+	if err != nil {
+		return "", err
+	}
+
+	if err := value.Patch(patch); err != nil {
+		return "", fmt.Errorf("failed to replace tests: %w", err)
+	}
+
+	return value.String(), nil
+}
+
+// runPolicyTests runs the tests embedded in a policy through the Tailscale validate endpoint. The
+// whole policy is validated first; only the tests of sources that reported failures are then run
+// one assertion at a time to find out which of them fail. A non-nil ACLTestError is returned if
+// the policy itself is invalid.
+func runPolicyTests(ctx context.Context, client *tailscale.Client, huJSON string) ([]*policyAssertion, *tailscale.ACLTestError, error) {
+	pol, err := parsePolicy(huJSON)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	assertions := policyAssertions(pol)
+	if len(assertions) == 0 {
+		return nil, nil, nil
+	}
+
+	testErr, err := validateACL(ctx, client, huJSON)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to validate policy: %w", err)
+	}
+	if testErr != nil && len(testErr.Data) == 0 {
+		return nil, testErr, nil
+	}
+
+	var failingSources []string
+	if testErr != nil {
+		for _, summary := range testErr.Data {
+			if len(summary.Errors) > 0 {
+				failingSources = append(failingSources, summary.User)
+			}
+		}
+	}
+
+	for _, assertion := range assertions {
+		if !slices.Contains(failingSources, assertion.Src) {
+			assertion.Passed = true
+			continue
+		}
+
+		single, err := withTests(huJSON, assertion)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		singleErr, err := validateACL(ctx, client, single)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to validate policy: %w", err)
+		}
+
+		assertion.Passed = singleErr == nil
+		if singleErr != nil {
+			for _, summary := range singleErr.Data {
+				assertion.Errors = append(assertion.Errors, summary.Errors...)
+			}
+			if len(assertion.Errors) == 0 && singleErr.Message != "" {
+				assertion.Errors = append(assertion.Errors, singleErr.Message)
+			}
+		}
+	}
+
+	return assertions, nil, nil
+}
+
+func (p *Plugin) handleACLTest(args *model.CommandArgs, attached bool) error {
+	client, config, err := p.getTailscaleClient(args)
+	if err != nil || client == nil {
+		return err
+	}
+
+	var huJSON, source string
+	if attached {
+		fileInfo, data, err := p.findAttachedPolicy(args)
+		if err != nil {
+			return err
+		}
+		huJSON, source = data, fmt.Sprintf("`%s`", fileInfo.Name)
+	} else {
+		acl, err := p.fetchACL(client)
+		if err != nil {
+			return err
+		}
+		huJSON, source = acl.ACL, "the current policy"
+	}
+
+	assertions, testErr, err := runPolicyTests(context.Background(), client, huJSON)
+	if err != nil {
+		return err
+	}
+	if testErr != nil {
+		p.postEphemeral(args.UserId, args.ChannelId, formatValidationError(source, testErr))
+		return nil
+	}
+	if len(assertions) == 0 {
+		p.postEphemeral(args.UserId, args.ChannelId, fmt.Sprintf("%s does not contain any tests.", source))
+		return nil
+	}
+
+	failed := 0
+	var rows strings.Builder
+	for _, assertion := range assertions {
+		result := ":white_check_mark: pass"
+		if !assertion.Passed {
+			result = ":x: fail"
+			failed++
+		}
+
+		expectation := "accept"
+		if !assertion.Accept {
+			expectation = "deny"
+		}
+
+		proto := assertion.Proto
+		if proto == "" {
+			proto = "-"
+		}
+
+		rows.WriteString(fmt.Sprintf("| %s | %s | %s | %s | %s | %s |\n",
+			result, assertion.Src, proto, assertion.Dst, expectation, joinCell(assertion.Errors)))
+	}
+
+	author := p.mentionUser(args.UserId)
+	summary := fmt.Sprintf("#### Policy test results for %s\n%s ran the tests of %s: **%d passed, %d failed**.",
+		config.Tailnet, author, source, len(assertions)-failed, failed)
+	message := summary + "\n\n| Result | Source | Protocol | Destination | Expected | Details |\n| --- | --- | --- | --- | --- | --- |\n" + rows.String()
+
+	// The full results of a large test suite do not fit into a post. They are sent to the user as a
+	// file and only the summary is posted.
+	if utf8.RuneCountInString(message) > model.PostMessageMaxRunesV2 {
+		if err := p.sendFileToUser(args.UserId, "policy-tests.md", []byte(message), "The full policy test results are attached."); err != nil {
+			return fmt.Errorf("failed to send test results as file: %w", err)
+		}
+		message = summary + "\n\n_The results are too long to display here. They have been sent to " + author + " as a direct message._"
+	}
+
+	post := &model.Post{
+		ChannelId: args.ChannelId,
+		RootId:    args.RootId,
+		UserId:    p.botID,
+		Message:   message,
+	}
+	if err := p.client.Post.CreatePost(post); err != nil {
+		return fmt.Errorf("failed to post test results: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+func TestHandleACLTest(t *testing.T) {
+	policyWithTests := func(count int) string {
+		tests := make([]string, count)
+		for i := range tests {
+			tests[i] = fmt.Sprintf(`{"src": "user%d@example.com", "accept": ["tag:server%d:22"]}`, i, i)
+		}
+		return `{"acls": [{"action": "accept", "src": ["*"], "dst": ["*:*"]}], "tests": [` + strings.Join(tests, ",") + `]}`
+	}
+
+	for name, tc := range map[string]struct {
+		tests    int
+		attached bool
+	}{
+		"results fit into a post":  {tests: 3},
+		"results are sent as file": {tests: 1000, attached: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			p, api, ts := newTestPlugin(t)
+			ts.addTailnet("key", &fakeTailnet{ID: "1001", Policy: policyWithTests(tc.tests)})
+			connectUser(t, p, "alice", "example.com", "key")
+
+			require.NoError(t, p.handleACLTest(&model.CommandArgs{UserId: "alice", ChannelId: "channel"}, false))
+
+			messages := api.postsIn("channel")
+			require.Len(t, messages, 1)
+			assert.LessOrEqual(t, utf8.RuneCountInString(messages[0]), model.PostMessageMaxRunesV2)
+			assert.Contains(t, messages[0], fmt.Sprintf("**%d passed, 0 failed**", tc.tests))
+			if tc.attached {
+				assert.Contains(t, messages[0], "sent to alice as a direct message")
+				assert.Contains(t, string(api.files["policy-tests.md"]), "user999@example.com")
+			} else {
+				assert.Contains(t, messages[0], "user2@example.com")
+				assert.Empty(t, api.files)
+			}
+		})
+	}
+}
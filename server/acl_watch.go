@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/pluginapi/cluster"
+)
+
+const (
+	policyWatchKeyPrefix = "acl_watch_"
+
+	// defaultPolicyWatchInterval is used if no valid polling interval is configured.
+	defaultPolicyWatchInterval = 5 * time.Minute
+)
+
+// policyWatch subscribes channels to policy changes of a tailnet. Watches are keyed by the tailnet
+// ID, so users who connected with the same tailnet name to different tailnets never share one. The
+// policy is polled with the credentials of the user who most recently subscribed a channel. Policy
+// and ETag hold the last observed version of the policy file, which new versions are compared
+// against.
+type policyWatch struct {
+	Tailnet   string
+	TailnetID string
+	UserID    string
+	Channels  []string
+	ETag      string
+	Policy    string
+}
+
+// errNotWatching is returned when a channel is not subscribed to policy changes.
+var errNotWatching = errors.New("channel is not watching the policy")
+
+func policyWatchKey(tailnetID string) string {
+	return policyWatchKeyPrefix + tailnetID
+}
+
+func (p *Plugin) handleACLWatch(args *model.CommandArgs) error {
+	client, config, err := p.getTailscaleClient(args)
+	if err != nil || client == nil {
+		return err
+	}
+
+	acl, tailnetID, err := p.fetchTailnetACL(client)
+	if err != nil {
+		return err
+	}
+
+	err = p.client.KV.SetAtomicWithRetries(policyWatchKey(tailnetID), func(oldValue []byte) (any, error) {
+		watch := policyWatch{
+			Tailnet:   config.Tailnet,
+			TailnetID: tailnetID,
+			ETag:      acl.ETag,
+			Policy:    acl.ACL,
+		}
+		if oldValue != nil {
+			if err := json.Unmarshal(oldValue, &watch); err != nil {
+				return nil, err
+			}
+		}
+
+		watch.Tailnet = config.Tailnet
+		watch.UserID = args.UserId
+		if !slices.Contains(watch.Channels, args.ChannelId) {
+			watch.Channels = append(watch.Channels, args.ChannelId)
+		}
+
+		return watch, nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to store policy watch: %w", err)
+	}
+
+	p.postEphemeral(args.UserId, args.ChannelId, fmt.Sprintf("This channel will be notified about policy changes of tailnet %s.", config.Tailnet))
+	return nil
+}
+
+func (p *Plugin) handleACLUnwatch(args *model.CommandArgs) error {
+	client, config, err := p.getTailscaleClient(args)
+	if err != nil || client == nil {
+		return err
+	}
+
+	tailnetID, err := resolveTailnetID(context.Background(), client)
+	if err != nil {
+		return err
+	}
+
+	err = p.client.KV.SetAtomicWithRetries(policyWatchKey(tailnetID), func(oldValue []byte) (any, error) {
+		if oldValue == nil {
+			return nil, errNotWatching
+		}
+
+		var watch policyWatch
+		if err := json.Unmarshal(oldValue, &watch); err != nil {
+			return nil, err
+		}
+
+		index := slices.Index(watch.Channels, args.ChannelId)
+		if index < 0 {
+			return nil, errNotWatching
+		}
+
+		watch.Channels = slices.Delete(watch.Channels, index, index+1)
+		if len(watch.Channels) == 0 {
+			return nil, nil
+		}
+
+		return watch, nil
+	})
+	if errors.Cause(err) == errNotWatching {
+		p.postEphemeral(args.UserId, args.ChannelId, fmt.Sprintf("This channel is not watching the policy of tailnet %s.", config.Tailnet))
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update policy watch: %w", err)
+	}
+
+	p.postEphemeral(args.UserId, args.ChannelId, fmt.Sprintf("This channel will no longer be notified about policy changes of tailnet %s.", config.Tailnet))
+	return nil
+}
+
+// schedulePolicyWatcher starts the background job that checks watched tailnets for policy changes.
+func (p *Plugin) schedulePolicyWatcher() (*cluster.Job, error) {
+	return cluster.Schedule(p.API, "PolicyWatcher", func(now time.Time, metadata cluster.JobMetadata) time.Duration {
+		return cluster.MakeWaitForInterval(p.getConfiguration().getPolicyWatchInterval())(now, metadata)
+	}, p.checkPolicyWatches)
+}
+
+func (p *Plugin) checkPolicyWatches() {
+	keys, err := p.listKeysWithPrefix(policyWatchKeyPrefix)
+	if err != nil {
+		p.API.LogError("Failed to list policy watches", "error", err.Error())
+		return
+	}
+
+	for _, key := range keys {
+		if err := p.checkPolicyWatch(key); err != nil {
+			p.API.LogWarn("Failed to check policy for changes", "key", key, "error", err.Error())
+		}
+	}
+}
+
+// checkPolicyWatch compares the current policy of a watched tailnet to the last observed version
+// and posts the diff to the subscribed channels if it changed.
+func (p *Plugin) checkPolicyWatch(key string) error {
+	var watch policyWatch
+	if err := p.client.KV.Get(key, &watch); err != nil {
+		return fmt.Errorf("failed to get policy watch: %w", err)
+	}
+
+	config, err := p.getUserTailscaleConfig(watch.UserID)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve Tailscale configuration: %w", err)
+	}
+	if config == nil {
+		return fmt.Errorf("user %s is no longer connected to tailnet %s", watch.UserID, watch.Tailnet)
+	}
+
+	client := p.newTailscaleClient(config.Tailnet, config.APIKey)
+	acl, tailnetID, err := p.fetchTailnetACL(client)
+	if err != nil {
+		return err
+	}
+	if tailnetID != watch.TailnetID {
+		return fmt.Errorf("user %s is no longer connected to tailnet %s", watch.UserID, watch.Tailnet)
+	}
+
+	if acl.ETag == watch.ETag {
+		return nil
+	}
+
+	diff, err := policyDiff(watch.Policy, acl.ACL, "previous", "current")
+	if err != nil {
+		return fmt.Errorf("failed to compute diff: %w", err)
+	}
+
+	var channels []string
+	err = p.client.KV.SetAtomicWithRetries(key, func(oldValue []byte) (any, error) {
+		if oldValue == nil {
+			return nil, errors.New("policy watch was removed concurrently")
+		}
+
+		var current policyWatch
+		if err := json.Unmarshal(oldValue, &current); err != nil {
+			return nil, err
+		}
+		if current.ETag != watch.ETag {
+			return nil, errors.New("policy watch was updated concurrently")
+		}
+
+		current.ETag = acl.ETag
+		current.Policy = acl.ACL
+		channels = current.Channels
+
+		return current, nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update policy watch: %w", err)
+	}
+
+	if diff == "" {
+		return nil
+	}
+
+	message := fmt.Sprintf("#### Policy of tailnet %s changed\n%s", watch.Tailnet, formatDiff(diff))
+	for _, channelID := range channels {
+		post := &model.Post{
+			ChannelId: channelID,
+			UserId:    p.botID,
+			Message:   message,
+		}
+		if err := p.client.Post.CreatePost(post); err != nil {
+			p.API.LogWarn("Failed to post policy change", "channel_id", channelID, "error", err.Error())
+		}
+	}
+
+	return nil
+}
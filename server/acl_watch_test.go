@@ -0,0 +1,70 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+func TestPolicyWatch(t *testing.T) {
+	const (
+		policyA = `{"acls": [{"action": "accept", "src": ["*"], "dst": ["*:*"]}]}`
+		policyB = `{"acls": []}`
+	)
+
+	setup := func(t *testing.T) (*Plugin, *fakeAPI, *fakeTailscale) {
+		p, api, ts := newTestPlugin(t)
+		ts.addTailnet("key-a", &fakeTailnet{ID: "1001", Policy: policyA})
+		ts.addTailnet("key-b", &fakeTailnet{ID: "2002", Policy: policyB})
+		// Both users connect to the default tailnet of their API key.
+		connectUser(t, p, "alice", "-", "key-a")
+		connectUser(t, p, "bob", "-", "key-b")
+		return p, api, ts
+	}
+
+	t.Run("watches are kept apart by tailnet ID", func(t *testing.T) {
+		p, api, ts := setup(t)
+
+		require.NoError(t, p.handleACLWatch(&model.CommandArgs{UserId: "alice", ChannelId: "channel-a"}))
+		require.NoError(t, p.handleACLWatch(&model.CommandArgs{UserId: "bob", ChannelId: "channel-b"}))
+
+		ts.tailnet("key-b", func(tailnet *fakeTailnet) {
+			tailnet.Policy = `{"acls": [{"action": "accept", "src": ["group:admins"], "dst": ["*:*"]}]}`
+			tailnet.ETag = `"changed"`
+		})
+		p.checkPolicyWatches()
+
+		assert.Empty(t, api.postsIn("channel-a"))
+		messages := api.postsIn("channel-b")
+		require.Len(t, messages, 1)
+		assert.Contains(t, messages[0], "group:admins")
+	})
+
+	t.Run("unwatch only touches the caller's tailnet", func(t *testing.T) {
+		p, api, _ := setup(t)
+
+		require.NoError(t, p.handleACLWatch(&model.CommandArgs{UserId: "alice", ChannelId: "channel-a"}))
+		require.NoError(t, p.handleACLUnwatch(&model.CommandArgs{UserId: "bob", ChannelId: "channel-a"}))
+		assert.Contains(t, api.lastEphemeral(t, "bob"), "not watching")
+
+		var watch policyWatch
+		require.NoError(t, p.client.KV.Get(policyWatchKey("1001"), &watch))
+		assert.Equal(t, []string{"channel-a"}, watch.Channels)
+	})
+
+	t.Run("subscriber moved to another tailnet", func(t *testing.T) {
+		p, api, ts := setup(t)
+
+		require.NoError(t, p.handleACLWatch(&model.CommandArgs{UserId: "alice", ChannelId: "channel-a"}))
+		connectUser(t, p, "alice", "-", "key-b")
+		ts.tailnet("key-b", func(tailnet *fakeTailnet) {
+			tailnet.ETag = `"changed"`
+		})
+
+		assert.ErrorContains(t, p.checkPolicyWatch(policyWatchKey("1001")), "no longer connected")
+		assert.Empty(t, api.postsIn("channel-a"))
+	})
+}
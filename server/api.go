@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin"
+)
+
+// initRouter sets up the HTTP routes served by the plugin under /plugins/{id}.
+func (p *Plugin) initRouter() *http.ServeMux {
+	router := http.NewServeMux()
+
+	router.HandleFunc("POST /api/v1/acl/changes", p.requireUser(p.handleAction(p.handlePolicyChangeAction)))
+	router.HandleFunc("POST /api/v1/acl/requests", p.requireUser(p.handleAction(p.handlePolicyChangeRequestAction)))
+	router.HandleFunc("POST /api/v1/access/grants", p.requireUser(p.handleAction(p.handleAccessGrantAction)))
+	router.HandleFunc("POST /api/v1/keys/revocations", p.requireUser(p.handleAction(p.handleKeyRevocationAction)))
+	router.HandleFunc("POST /api/v1/keys/requests", p.requireUser(p.handleAction(p.handleKeyRequestAction)))
+	router.HandleFunc("POST /api/v1/dns/changes", p.requireUser(p.handleAction(p.handleDNSChangeAction)))
+	router.HandleFunc("POST /api/v1/users/actions", p.requireUser(p.handleAction(p.handleTailnetUserAction)))
+
+	// Webhooks are sent by Tailscale and authenticated by their signature instead of a user session.
+	router.HandleFunc("POST /webhooks/{tailnet_id}", p.handleWebhook)
+
+	return router
+}
+
+// ServeHTTP handles HTTP requests made to the plugin.
+func (p *Plugin) ServeHTTP(_ *plugin.Context, w http.ResponseWriter, r *http.Request) {
+	p.router.ServeHTTP(w, r)
+}
+
+// requireUser rejects requests that were not made by an authenticated Mattermost user.
+func (p *Plugin) requireUser(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Mattermost-User-ID") == "" {
+			http.Error(w, "Not authorized", http.StatusUnauthorized)
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// actionURL returns the URL used by interactive message buttons to reach the given route.
+func actionURL(route string) string {
+	return fmt.Sprintf("/plugins/%s%s", manifest.Id, route)
+}
+
+// decodeActionRequest decodes the request sent when a user clicks an interactive message button.
+func decodeActionRequest(r *http.Request) (*model.PostActionIntegrationRequest, error) {
+	var request model.PostActionIntegrationRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		return nil, fmt.Errorf("failed to decode action request: %w", err)
+	}
+
+	return &request, nil
+}
+
+// actionHandler handles a click on an interactive message button by the given user. It returns
+// the message replacing the ephemeral post of the button, or an empty message if the post was
+// updated otherwise. A returned error is shown to the user who clicked the button.
+type actionHandler func(userID string, request *model.PostActionIntegrationRequest) (string, error)
+
+// handleAction decodes the request of an interactive message button, runs handler and updates the
+// post of the button with the outcome.
+func (p *Plugin) handleAction(handler actionHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Header.Get("Mattermost-User-ID")
+
+		request, err := decodeActionRequest(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		message, err := handler(userID, request)
+		if err != nil {
+			p.writeActionResponse(w, &model.PostActionIntegrationResponse{EphemeralText: err.Error()})
+			return
+		}
+
+		if message != "" {
+			p.client.Post.UpdateEphemeralPost(userID, &model.Post{
+				Id:        request.PostId,
+				ChannelId: request.ChannelId,
+				UserId:    p.botID,
+				Message:   message,
+			})
+		}
+		p.writeActionResponse(w, &model.PostActionIntegrationResponse{})
+	}
+}
+
+// pendingChange is a change stored in the KV store until the user who proposed it confirms or
+// cancels it.
+type pendingChange interface {
+	proposedBy() string
+}
+
+// takePendingChange loads the pending change stored under key into change and deletes it, so that
+// it is confirmed at most once. It returns false if the change has expired or was taken already.
+func (p *Plugin) takePendingChange(key, userID string, change pendingChange) (bool, error) {
+	data, appErr := p.API.KVGet(key)
+	if appErr != nil {
+		p.API.LogError("Failed to get pending change", "key", key, "error", appErr.Error())
+		return false, errors.New("failed to get the pending change")
+	}
+	if data == nil {
+		return false, nil
+	}
+
+	if err := json.Unmarshal(data, change); err != nil {
+		p.API.LogError("Failed to decode pending change", "key", key, "error", err.Error())
+		return false, errors.New("failed to get the pending change")
+	}
+	if change.proposedBy() != userID {
+		return false, errors.New("only the user who proposed this change can confirm or cancel it")
+	}
+
+	deleted, appErr := p.API.KVCompareAndDelete(key, data)
+	if appErr != nil {
+		p.API.LogError("Failed to delete pending change", "key", key, "error", appErr.Error())
+		return false, errors.New("failed to get the pending change")
+	}
+
+	return deleted, nil
+}
+
+// checkDecision rejects a decision on a request that is no longer pending or that the deciding
+// user made themselves.
+func checkDecision(kind, status, requesterID, userID string) error {
+	if status != "pending" {
+		return fmt.Errorf("this %s is already %s", kind, status)
+	}
+	if requesterID == userID {
+		return fmt.Errorf("you cannot decide on your own %s", kind)
+	}
+
+	return nil
+}
+
+// contextString returns a string value from the context of an action request.
+func contextString(request *model.PostActionIntegrationRequest, key string) string {
+	value, _ := request.Context[key].(string)
+	return value
+}
+
+func (p *Plugin) writeActionResponse(w http.ResponseWriter, response *model.PostActionIntegrationResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		p.API.LogWarn("Failed to write action response", "error", err.Error())
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitCommandLine(t *testing.T) {
+	for name, tc := range map[string]struct {
+		command  string
+		expected []string
+	}{
+		"empty":                {command: "", expected: nil},
+		"fields":               {command: "/tailscale access active", expected: []string{"/tailscale", "access", "active"}},
+		"repeated whitespace":  {command: "  a \t b\n c  ", expected: []string{"a", "b", "c"}},
+		"quoted argument":      {command: `--reason "fix the database"`, expected: []string{"--reason", "fix the database"}},
+		"typographic quotes":   {command: "--reason “fix the database”", expected: []string{"--reason", "fix the database"}},
+		"empty quotes":         {command: `a "" b`, expected: []string{"a", "", "b"}},
+		"quotes within a word": {command: `tag:"prod db"`, expected: []string{"tag:prod db"}},
+		"unterminated quotes":  {command: `--reason "fix it`, expected: []string{"--reason", "fix it"}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, splitCommandLine(tc.command))
+		})
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	for name, tc := range map[string]struct {
+		value    string
+		expected time.Duration
+		err      bool
+	}{
+		"minutes":       {value: "30m", expected: 30 * time.Minute},
+		"hours":         {value: "2h", expected: 2 * time.Hour},
+		"combined":      {value: "1h30m", expected: 90 * time.Minute},
+		"days":          {value: "7d", expected: 7 * 24 * time.Hour},
+		"zero":          {value: "0s", err: true},
+		"negative":      {value: "-1h", err: true},
+		"no days":       {value: "0d", err: true},
+		"bad days":      {value: "xd", err: true},
+		"max days":      {value: "3650d", expected: maxDurationDays * 24 * time.Hour},
+		"too many days": {value: "3651d", err: true},
+		"overflow":      {value: "106752d", err: true},
+		"no unit":       {value: "5", err: true},
+		"empty":         {value: "", err: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			d, err := parseDuration(tc.value)
+			if tc.err {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, d)
+		})
+	}
+}
+
+func TestFormatDuration(t *testing.T) {
+	for expected, d := range map[string]time.Duration{
+		"30s":   30 * time.Second,
+		"30m":   30 * time.Minute,
+		"2h":    2 * time.Hour,
+		"1h30m": 90 * time.Minute,
+		"1d":    24 * time.Hour,
+		"7d":    7 * 24 * time.Hour,
+		"25h":   25 * time.Hour,
+	} {
+		t.Run(expected, func(t *testing.T) {
+			assert.Equal(t, expected, formatDuration(d))
+
+			parsed, err := parseDuration(expected)
+			require.NoError(t, err)
+			assert.Equal(t, d, parsed)
+		})
+	}
+}
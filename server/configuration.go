@@ -3,8 +3,13 @@ package main
 import (
 	"encoding/json"
 	"reflect"
+	"regexp"
+	"strings"
+	"time"
 
 	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost/server/public/model"
 )
 
 // configuration captures the plugin's external configuration as exposed in the Mattermost server
@@ -21,6 +26,34 @@ import (
 type configuration struct {
 	Serve   bool   `json:"serve"`
 	AuthKey string `json:"auth_key"` // Tailscale auth key for the serve command
+
+	ServeHostname string `json:"serve_hostname"` // Name of the Tailscale node serving Mattermost
+
+	ServeSSO              bool `json:"serve_sso"`                // Log users reaching Mattermost over Serve in with their Tailscale identity
+	ServeSSOAutoProvision bool `json:"serve_sso_auto_provision"` // Create Mattermost users for Tailscale logins without a matching user
+
+	ServeAllow string `json:"serve_allow"` // Rules of peers allowed to reach Mattermost over Serve
+	ServeDeny  string `json:"serve_deny"`  // Rules of peers denied access to Mattermost over Serve
+
+	PolicyApprovers         string `json:"policy_approvers"`          // Comma-separated usernames allowed to approve policy changes
+	PolicyApprovalsRequired int    `json:"policy_approvals_required"` // Approvals needed before a policy change is applied
+	PolicyWatchInterval     int    `json:"policy_watch_interval"`     // Minutes between checks of watched policies
+
+	AccessGrantCheckInterval int `json:"access_grant_check_interval"` // Minutes between checks for expired access grants
+
+	DefaultTailnet string `json:"default_tailnet"` // Tailnet used by /tailscale connect if only an API key is given
+
+	AdminTailnet string `json:"admin_tailnet"` // Tailnet the plugin manages on behalf of users without own credentials
+	AdminAPIKey  string `json:"admin_api_key"` // Admin API key used for auth key requests and offboarding
+
+	KeyRequestChannel string `json:"key_request_channel"` // Channel auth key requests are posted to, as team-name/channel-name or channel ID
+
+	OffboardingAction  string `json:"offboarding_action"`  // What happens to the tailnet user of a deactivated Mattermost user
+	OffboardingChannel string `json:"offboarding_channel"` // Channel offboarding reports are posted to, as team-name/channel-name or channel ID
+
+	AuditChannel string `json:"audit_channel"` // Channel changes of tailnet settings are posted to, as team-name/channel-name or channel ID
+
+	UserAdmins string `json:"user_admins"` // Comma-separated usernames allowed to manage tailnet users
 }
 
 func (c *configuration) ToMap() (map[string]interface{}, error) {
@@ -37,6 +70,147 @@ func (c *configuration) ToMap() (map[string]interface{}, error) {
 	return out, nil
 }
 
+// serveOptions returns the options the Tailscale serve node is started with.
+func (c *configuration) serveOptions() serveOptions {
+	hostname := strings.TrimSpace(c.ServeHostname)
+	if hostname == "" {
+		hostname = defaultServeHostname
+	}
+
+	return serveOptions{
+		AuthKey:  c.AuthKey,
+		Hostname: hostname,
+	}
+}
+
+// serveSettingsChanged reports whether a setting that Tailscale serve or its access checks depend
+// on differs from the old configuration.
+func (c *configuration) serveSettingsChanged(old *configuration) bool {
+	return c.Serve != old.Serve ||
+		c.serveOptions() != old.serveOptions() ||
+		c.ServeSSO != old.ServeSSO ||
+		c.ServeSSOAutoProvision != old.ServeSSOAutoProvision ||
+		c.ServeAllow != old.ServeAllow ||
+		c.ServeDeny != old.ServeDeny
+}
+
+// getPolicyApprovers returns the normalized usernames of the configured policy approvers.
+func (c *configuration) getPolicyApprovers() []string {
+	return splitUsernames(c.PolicyApprovers)
+}
+
+// getUserAdmins returns the normalized usernames of the configured tailnet user admins.
+func (c *configuration) getUserAdmins() []string {
+	return splitUsernames(c.UserAdmins)
+}
+
+// getPolicyWatchInterval returns the interval at which watched policies are checked for changes.
+func (c *configuration) getPolicyWatchInterval() time.Duration {
+	if c.PolicyWatchInterval <= 0 {
+		return defaultPolicyWatchInterval
+	}
+
+	return time.Duration(c.PolicyWatchInterval) * time.Minute
+}
+
+// getAccessGrantCheckInterval returns the interval at which expired access grants are revoked.
+func (c *configuration) getAccessGrantCheckInterval() time.Duration {
+	if c.AccessGrantCheckInterval <= 0 {
+		return defaultAccessGrantCheckInterval
+	}
+
+	return time.Duration(c.AccessGrantCheckInterval) * time.Minute
+}
+
+// serveHostnameRegexp matches valid DNS labels, which Tailscale node names must be.
+var serveHostnameRegexp = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?$`)
+
+// IsValid checks the configuration for invalid values and combinations of settings.
+func (c *configuration) IsValid() error {
+	return c.validate(false)
+}
+
+// isValidToSave is IsValid for a configuration about to be saved from the System Console, which
+// sends secret settings it did not change as model.FakeSetting.
+func (c *configuration) isValidToSave() error {
+	return c.validate(true)
+}
+
+// validate checks the configuration. If masked is true, model.FakeSetting counts as a set secret.
+func (c *configuration) validate(masked bool) error {
+	if c.Serve && !isSecretSet(c.AuthKey, masked) {
+		return errors.New("Tailscale serve cannot be enabled without a serve auth key")
+	}
+	if hostname := strings.TrimSpace(c.ServeHostname); hostname != "" && !serveHostnameRegexp.MatchString(hostname) {
+		return errors.Errorf("serve hostname %q is not a valid DNS label: use up to 63 letters, digits and hyphens, without a leading or trailing hyphen", hostname)
+	}
+	if c.ServeSSOAutoProvision && !c.ServeSSO {
+		return errors.New("creating users on Tailscale sign-in requires Sign In With Tailscale to be enabled")
+	}
+	for _, rule := range append(splitServeRules(c.ServeAllow), splitServeRules(c.ServeDeny)...) {
+		if err := validateServeRule(rule); err != nil {
+			return err
+		}
+	}
+
+	if c.PolicyWatchInterval < 0 {
+		return errors.New("the policy watch interval must not be negative")
+	}
+	if c.AccessGrantCheckInterval < 0 {
+		return errors.New("the access grant check interval must not be negative")
+	}
+
+	if c.PolicyApprovalsRequired < 0 {
+		return errors.New("the number of required policy approvals must not be negative")
+	}
+	if approvers := len(c.getPolicyApprovers()); c.PolicyApprovalsRequired > approvers {
+		return errors.Errorf("policy changes require %d approvals, but only %d policy approvers are configured", c.PolicyApprovalsRequired, approvers)
+	}
+
+	hasAdminCredential := strings.TrimSpace(c.AdminTailnet) != "" && isSecretSet(c.AdminAPIKey, masked)
+	if !hasAdminCredential && (strings.TrimSpace(c.AdminTailnet) != "" || isSecretSet(c.AdminAPIKey, masked)) {
+		return errors.New("the admin tailnet and admin API key must be configured together")
+	}
+	if strings.TrimSpace(c.KeyRequestChannel) != "" && !hasAdminCredential {
+		return errors.New("auth key requests require the admin tailnet and admin API key")
+	}
+
+	switch c.OffboardingAction {
+	case "", offboardingDisabled:
+	case offboardingSuspend, offboardingDeleteDevices:
+		if !hasAdminCredential {
+			return errors.New("offboarding requires the admin tailnet and admin API key")
+		}
+	default:
+		return errors.Errorf("unknown offboarding action %q", c.OffboardingAction)
+	}
+
+	return nil
+}
+
+// isSecretSet reports whether a secret setting has a value. model.FakeSetting only counts as set
+// if masked is true, as it is a placeholder for the real value and never a usable secret.
+func isSecretSet(value string, masked bool) bool {
+	if value == model.FakeSetting {
+		return masked
+	}
+
+	return strings.TrimSpace(value) != ""
+}
+
+// splitUsernames parses a comma-separated list of usernames, ignoring leading @ characters.
+func splitUsernames(list string) []string {
+	var usernames []string
+	for _, username := range strings.Split(list, ",") {
+		username = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(username), "@"))
+		if username != "" {
+			usernames = append(usernames, username)
+		}
+	}
+
+	return usernames
+}
+
 type UserTailscaleConfig struct {
 	APIKey  string
 	Tailnet string
@@ -99,13 +273,57 @@ func (p *Plugin) OnConfigurationChange() error {
 		return errors.Wrap(err, "failed to load plugin configuration")
 	}
 
+	// Invalid configurations are normally rejected by ConfigurationWillBeSaved. Keep the previous
+	// configuration if one got saved anyway, e.g. by editing the configuration file.
+	if err := configuration.IsValid(); err != nil {
+		p.API.LogError("Ignoring invalid plugin configuration, the previous configuration stays in use", "error", err.Error())
+		return nil
+	}
+
+	previous := p.getConfiguration()
 	p.setConfiguration(configuration)
 
+	// Before activation, OnActivate starts serve itself.
+	if p.serve != nil && configuration.serveSettingsChanged(previous) {
+		go p.reconcileServe(true)
+	}
+
 	return nil
 }
 
+// ConfigurationWillBeSaved rejects invalid plugin configurations before they are saved, so the
+// System Console shows the error.
+func (p *Plugin) ConfigurationWillBeSaved(newCfg *model.Config) (*model.Config, error) {
+	settings, ok := newCfg.PluginSettings.Plugins[manifest.Id]
+	if !ok {
+		return nil, nil
+	}
+
+	data, err := json.Marshal(settings)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal plugin configuration")
+	}
+
+	var configuration configuration
+	if err := json.Unmarshal(data, &configuration); err != nil {
+		return nil, errors.Wrap(err, "failed to decode plugin configuration")
+	}
+
+	if err := configuration.isValidToSave(); err != nil {
+		return nil, errors.Wrap(err, "invalid Tailscale plugin configuration")
+	}
+
+	return nil, nil
+}
+
+// SaveConfiguration applies and saves the configuration. As it is applied before
+// OnConfigurationChange runs, serve is reconciled here if its settings changed.
 func (p *Plugin) SaveConfiguration(configuration *configuration) error {
+	previous := p.getConfiguration()
 	p.setConfiguration(configuration.Clone())
+	if p.serve != nil && configuration.serveSettingsChanged(previous) {
+		go p.reconcileServe(true)
+	}
 
 	configMap, err := configuration.ToMap()
 	if err != nil {
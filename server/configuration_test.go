@@ -0,0 +1,160 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+)
+
+func TestConfigurationIsValid(t *testing.T) {
+	for name, tc := range map[string]struct {
+		config configuration
+		toSave bool
+		err    string
+	}{
+		"empty": {},
+		"serve": {
+			config: configuration{Serve: true, AuthKey: "tskey-auth-test", ServeHostname: "mattermost-1"},
+		},
+		"serve with a masked auth key": {
+			config: configuration{Serve: true, AuthKey: model.FakeSetting},
+			toSave: true,
+		},
+		"serve with a masked auth key at runtime": {
+			config: configuration{Serve: true, AuthKey: model.FakeSetting},
+			err:    "Tailscale serve cannot be enabled without a serve auth key",
+		},
+		"serve without an auth key": {
+			config: configuration{Serve: true, AuthKey: " "},
+			err:    "Tailscale serve cannot be enabled without a serve auth key",
+		},
+		"invalid hostname": {
+			config: configuration{ServeHostname: "-mattermost"},
+			err:    `serve hostname "-mattermost" is not a valid DNS label: use up to 63 letters, digits and hyphens, without a leading or trailing hyphen`,
+		},
+		"auto provisioning without sign-in": {
+			config: configuration{ServeSSOAutoProvision: true},
+			err:    "creating users on Tailscale sign-in requires Sign In With Tailscale to be enabled",
+		},
+		"serve rules": {
+			config: configuration{ServeAllow: "*@example.com, tag:office", ServeDeny: "node:kiosk\nbob@example.com"},
+		},
+		"invalid serve rule": {
+			config: configuration{ServeDeny: "laptop"},
+			err:    `serve rule "laptop" is neither a Tailscale login, a tag:name nor a node:name`,
+		},
+		"negative interval": {
+			config: configuration{PolicyWatchInterval: -1},
+			err:    "the policy watch interval must not be negative",
+		},
+		"approvals": {
+			config: configuration{PolicyApprovers: "alice, @bob", PolicyApprovalsRequired: 2},
+		},
+		"too many approvals": {
+			config: configuration{PolicyApprovers: "alice", PolicyApprovalsRequired: 2},
+			err:    "policy changes require 2 approvals, but only 1 policy approvers are configured",
+		},
+		"admin credential": {
+			config: configuration{AdminTailnet: "example.com", AdminAPIKey: "tskey-api-test", KeyRequestChannel: "team/keys", OffboardingAction: offboardingSuspend},
+		},
+		"masked admin API key": {
+			config: configuration{AdminTailnet: "example.com", AdminAPIKey: model.FakeSetting, OffboardingAction: offboardingDeleteDevices},
+			toSave: true,
+		},
+		"masked admin API key at runtime": {
+			config: configuration{AdminTailnet: "example.com", AdminAPIKey: model.FakeSetting},
+			err:    "the admin tailnet and admin API key must be configured together",
+		},
+		"admin API key without a tailnet": {
+			config: configuration{AdminAPIKey: model.FakeSetting},
+			toSave: true,
+			err:    "the admin tailnet and admin API key must be configured together",
+		},
+		"key requests without the admin credential": {
+			config: configuration{KeyRequestChannel: "team/keys"},
+			err:    "auth key requests require the admin tailnet and admin API key",
+		},
+		"offboarding without the admin credential": {
+			config: configuration{OffboardingAction: offboardingSuspend},
+			err:    "offboarding requires the admin tailnet and admin API key",
+		},
+		"unknown offboarding action": {
+			config: configuration{OffboardingAction: "archive"},
+			err:    `unknown offboarding action "archive"`,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			err := tc.config.IsValid()
+			if tc.toSave {
+				err = tc.config.isValidToSave()
+			}
+			if tc.err != "" {
+				assert.EqualError(t, err, tc.err)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestServeSettingsChanged(t *testing.T) {
+	old := &configuration{Serve: true, AuthKey: "tskey-auth-test", ServeAllow: "*@example.com", PolicyWatchInterval: 5}
+
+	for name, tc := range map[string]struct {
+		change   func(c *configuration)
+		expected bool
+	}{
+		"unchanged":              {change: func(*configuration) {}},
+		"unrelated setting":      {change: func(c *configuration) { c.PolicyWatchInterval = 10 }},
+		"default hostname":       {change: func(c *configuration) { c.ServeHostname = defaultServeHostname }},
+		"serve disabled":         {change: func(c *configuration) { c.Serve = false }, expected: true},
+		"auth key":               {change: func(c *configuration) { c.AuthKey = "tskey-auth-other" }, expected: true},
+		"hostname":               {change: func(c *configuration) { c.ServeHostname = "chat" }, expected: true},
+		"sign in with tailscale": {change: func(c *configuration) { c.ServeSSO = true }, expected: true},
+		"allow rules":            {change: func(c *configuration) { c.ServeAllow = "" }, expected: true},
+		"deny rules":             {change: func(c *configuration) { c.ServeDeny = "tag:kiosk" }, expected: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			c := old.Clone()
+			tc.change(c)
+			assert.Equal(t, tc.expected, c.serveSettingsChanged(old))
+		})
+	}
+}
+
+func TestOnConfigurationChange(t *testing.T) {
+	load := func(loaded configuration) *plugintest.API {
+		api := &plugintest.API{}
+		api.On("LoadPluginConfiguration", mock.Anything).Return(func(dest any) error {
+			*dest.(*configuration) = loaded
+			return nil
+		})
+		api.On("LogError", mock.Anything, mock.Anything, mock.Anything).Maybe()
+		return api
+	}
+
+	t.Run("valid configuration", func(t *testing.T) {
+		p := &Plugin{}
+		p.SetAPI(load(configuration{UserAdmins: "alice"}))
+
+		require.NoError(t, p.OnConfigurationChange())
+		assert.Equal(t, "alice", p.getConfiguration().UserAdmins)
+	})
+
+	t.Run("invalid configuration keeps the previous one", func(t *testing.T) {
+		api := load(configuration{Serve: true, AuthKey: model.FakeSetting})
+		defer api.AssertExpectations(t)
+		p := &Plugin{}
+		p.SetAPI(api)
+		p.setConfiguration(&configuration{Serve: true, AuthKey: "tskey-auth-test"})
+
+		require.NoError(t, p.OnConfigurationChange())
+		assert.Equal(t, "tskey-auth-test", p.getConfiguration().AuthKey)
+		api.AssertCalled(t, "LogError", mock.Anything, mock.Anything, mock.Anything)
+	})
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/pkg/errors"
+	"tailscale.com/client/tailscale"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+const (
+	// pendingDNSChangeTTL is how long a destructive DNS change can be confirmed, in seconds.
+	pendingDNSChangeTTL = 10 * 60
+
+	dnsNameservers = "nameservers"
+	dnsSearchPaths = "searchpaths"
+	dnsSplit       = "split"
+)
+
+// pendingDNSChange is a DNS change waiting for the user's confirmation. Values replace the current
+// nameservers or search paths, or the nameservers of Domain for split DNS. Nil values remove the
+// split DNS route of Domain.
+type pendingDNSChange struct {
+	ID      string
+	UserID  string
+	Tailnet string
+	Kind    string
+	Domain  string
+	Values  []string
+}
+
+func pendingDNSChangeKey(id string) string {
+	return "dns_change_" + id
+}
+
+func (c *pendingDNSChange) proposedBy() string {
+	return c.UserID
+}
+
+// describe returns a short description of the change for messages and logs.
+func (c *pendingDNSChange) describe() string {
+	switch c.Kind {
+	case dnsSplit:
+		if c.Values == nil {
+			return fmt.Sprintf("removed the split DNS route for `%s`", c.Domain)
+		}
+		return fmt.Sprintf("set the nameservers for `%s` to %s", c.Domain, formatNames(c.Values))
+	case dnsSearchPaths:
+		return fmt.Sprintf("set the search paths to %s", formatDNSNames(c.Values))
+	default:
+		return fmt.Sprintf("set the nameservers to %s", formatDNSNames(c.Values))
+	}
+}
+
+func (p *Plugin) handleDNSShow(args *model.CommandArgs) error {
+	client, config, err := p.getTailscaleClient(args)
+	if err != nil || client == nil {
+		return err
+	}
+
+	ctx := context.Background()
+	nameservers, err := client.NameServers(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve nameservers from Tailscale API: %w", err)
+	}
+	preferences, err := client.DNSPreferences(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve DNS preferences from Tailscale API: %w", err)
+	}
+	searchPaths, err := client.SearchPaths(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve search paths from Tailscale API: %w", err)
+	}
+	routes, err := splitDNS(ctx, client)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve split DNS from Tailscale API: %w", err)
+	}
+
+	magicDNS := "disabled"
+	if preferences.MagicDNS {
+		magicDNS = "enabled"
+	}
+
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("#### DNS of %s\n", config.Tailnet))
+	b.WriteString(fmt.Sprintf("**MagicDNS:** %s\n", magicDNS))
+	b.WriteString(fmt.Sprintf("**Nameservers:** %s\n", formatDNSNames(nameservers)))
+	b.WriteString(fmt.Sprintf("**Search paths:** %s\n", formatDNSNames(searchPaths)))
+
+	if len(routes) == 0 {
+		b.WriteString("**Split DNS:** none\n")
+	} else {
+		b.WriteString("\n| Domain | Nameservers |\n| --- | --- |\n")
+		for _, domain := range sortedKeys(routes) {
+			b.WriteString(fmt.Sprintf("| %s | %s |\n", domain, joinCell(routes[domain])))
+		}
+	}
+
+	return p.postEphemeralOrFile(args.UserId, args.ChannelId, b.String(), "dns.md", []byte(b.String()), "")
+}
+
+func formatDNSNames(names []string) string {
+	if len(names) == 0 {
+		return "none"
+	}
+
+	return formatNames(names)
+}
+
+// handleDNSList changes the nameservers or search paths. Adding entries is applied right away,
+// while replacing or removing entries asks for confirmation first.
+func (p *Plugin) handleDNSList(args *model.CommandArgs, kind, operation string, values []string) error {
+	client, config, err := p.getTailscaleClient(args)
+	if err != nil || client == nil {
+		return err
+	}
+
+	var current []string
+	if kind == dnsNameservers {
+		current, err = client.NameServers(context.Background())
+	} else {
+		current, err = client.SearchPaths(context.Background())
+	}
+	if err != nil {
+		return fmt.Errorf("failed to retrieve %s from Tailscale API: %w", kind, err)
+	}
+
+	var updated []string
+	switch operation {
+	case "set":
+		updated = values
+	case "add":
+		updated = append([]string{}, current...)
+		for _, value := range values {
+			if !slices.Contains(updated, value) {
+				updated = append(updated, value)
+			}
+		}
+	case "remove":
+		for _, value := range current {
+			if !slices.Contains(values, value) {
+				updated = append(updated, value)
+			}
+		}
+		if len(updated) == len(current) {
+			p.postEphemeral(args.UserId, args.ChannelId, fmt.Sprintf("None of %s are configured.", formatNames(values)))
+			return nil
+		}
+	default:
+		return errors.Errorf("unknown operation %q", operation)
+	}
+
+	if updated == nil {
+		updated = []string{}
+	}
+
+	change := &pendingDNSChange{
+		ID:      model.NewId(),
+		UserID:  args.UserId,
+		Tailnet: config.Tailnet,
+		Kind:    kind,
+		Values:  updated,
+	}
+
+	destructive := false
+	for _, value := range current {
+		if !slices.Contains(updated, value) {
+			destructive = true
+		}
+	}
+	if !destructive {
+		return p.applyDNSChangeNow(args, client, change)
+	}
+
+	warning := ""
+	if kind == dnsNameservers && len(updated) == 0 {
+		warning = "\n\nRemoving all nameservers disables MagicDNS."
+	}
+	message := fmt.Sprintf("#### Change %s of %s?\n**Current:** %s\n**New:** %s%s",
+		kind, config.Tailnet, formatDNSNames(current), formatDNSNames(updated), warning)
+
+	return p.confirmDNSChange(args, change, message)
+}
+
+// handleDNSSplit sets the nameservers of a split DNS domain. An empty list of nameservers removes
+// the route. Changing or removing an existing route asks for confirmation first.
+func (p *Plugin) handleDNSSplit(args *model.CommandArgs, domain string, nameservers []string) error {
+	client, config, err := p.getTailscaleClient(args)
+	if err != nil || client == nil {
+		return err
+	}
+
+	routes, err := splitDNS(context.Background(), client)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve split DNS from Tailscale API: %w", err)
+	}
+
+	current, exists := routes[domain]
+	if !exists && len(nameservers) == 0 {
+		p.postEphemeral(args.UserId, args.ChannelId, fmt.Sprintf("There is no split DNS route for `%s`.", domain))
+		return nil
+	}
+
+	change := &pendingDNSChange{
+		ID:      model.NewId(),
+		UserID:  args.UserId,
+		Tailnet: config.Tailnet,
+		Kind:    dnsSplit,
+		Domain:  domain,
+		Values:  nameservers,
+	}
+
+	if !exists {
+		return p.applyDNSChangeNow(args, client, change)
+	}
+
+	message := fmt.Sprintf("#### Change split DNS for `%s` of %s?\n**Current:** %s\n**New:** %s",
+		domain, config.Tailnet, formatDNSNames(current), formatDNSNames(nameservers))
+
+	return p.confirmDNSChange(args, change, message)
+}
+
+func (p *Plugin) applyDNSChangeNow(args *model.CommandArgs, client *tailscale.Client, change *pendingDNSChange) error {
+	if err := p.applyDNSChange(client, change); err != nil {
+		return err
+	}
+
+	p.postEphemeral(args.UserId, args.ChannelId, fmt.Sprintf("Successfully %s in tailnet %s.", change.describe(), change.Tailnet))
+	return nil
+}
+
+// confirmDNSChange stores a destructive DNS change and asks the user to confirm it.
+func (p *Plugin) confirmDNSChange(args *model.CommandArgs, change *pendingDNSChange, message string) error {
+	data, err := json.Marshal(change)
+	if err != nil {
+		return fmt.Errorf("failed to marshal DNS change: %w", err)
+	}
+	if appErr := p.API.KVSetWithExpiry(pendingDNSChangeKey(change.ID), data, pendingDNSChangeTTL); appErr != nil {
+		return fmt.Errorf("failed to store DNS change: %w", appErr)
+	}
+
+	post := &model.Post{
+		ChannelId: args.ChannelId,
+		UserId:    p.botID,
+		Message:   message,
+	}
+	model.ParseSlackAttachment(post, []*model.SlackAttachment{{
+		Actions: []*model.PostAction{
+			dnsChangeAction("Apply", "apply", "danger", change.ID),
+			dnsChangeAction("Cancel", "cancel", "default", change.ID),
+		},
+	}})
+	p.client.Post.SendEphemeralPost(args.UserId, post)
+
+	return nil
+}
+
+func dnsChangeAction(name, action, style, changeID string) *model.PostAction {
+	return &model.PostAction{
+		Type:  model.PostActionTypeButton,
+		Name:  name,
+		Style: style,
+		Integration: &model.PostActionIntegration{
+			URL: actionURL("/api/v1/dns/changes"),
+			Context: map[string]any{
+				"change_id": changeID,
+				"action":    action,
+			},
+		},
+	}
+}
+
+// applyDNSChange pushes a DNS change to the tailnet.
+func (p *Plugin) applyDNSChange(client *tailscale.Client, change *pendingDNSChange) error {
+	ctx := context.Background()
+
+	var err error
+	switch change.Kind {
+	case dnsNameservers:
+		_, err = client.SetNameServers(ctx, change.Values)
+	case dnsSearchPaths:
+		_, err = client.SetSearchPaths(ctx, change.Values)
+	case dnsSplit:
+		_, err = updateSplitDNS(ctx, client, map[string][]string{change.Domain: change.Values})
+	default:
+		err = errors.Errorf("unknown DNS change %q", change.Kind)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update DNS: %w", err)
+	}
+
+	p.API.LogInfo("Updated tailnet DNS", "tailnet", change.Tailnet, "user_id", change.UserID, "kind", change.Kind, "domain", change.Domain, "values", strings.Join(change.Values, ","))
+
+	return nil
+}
+
+func (p *Plugin) handleDNSChangeAction(userID string, request *model.PostActionIntegrationRequest) (string, error) {
+	var change pendingDNSChange
+	found, err := p.takePendingChange(pendingDNSChangeKey(contextString(request, "change_id")), userID, &change)
+	if err != nil {
+		return "", err
+	}
+
+	switch {
+	case !found:
+		return "This DNS change has expired. Run the command again to make it.", nil
+	case contextString(request, "action") == "apply":
+		return p.confirmedDNSChange(&change), nil
+	default:
+		return "Cancelled the DNS change.", nil
+	}
+}
+
+// confirmedDNSChange applies a confirmed DNS change with the credentials of the user who made it
+// and returns a message describing the outcome.
+func (p *Plugin) confirmedDNSChange(change *pendingDNSChange) string {
+	config, err := p.getUserTailscaleConfig(change.UserID)
+	if err != nil {
+		p.API.LogError("Failed to retrieve Tailscale configuration", "user_id", change.UserID, "error", err.Error())
+		return "Failed to retrieve your Tailscale configuration."
+	}
+	if config == nil || config.Tailnet != change.Tailnet {
+		return fmt.Sprintf("You are no longer connected to tailnet %s.", change.Tailnet)
+	}
+
+	client := p.newTailscaleClient(config.Tailnet, config.APIKey)
+	if err := p.applyDNSChange(client, change); err != nil {
+		return err.Error()
+	}
+
+	return fmt.Sprintf("Successfully %s in tailnet %s.", change.describe(), change.Tailnet)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+func TestDNSChanges(t *testing.T) {
+	setup := func(t *testing.T) (*Plugin, *fakeAPI, *fakeTailscale) {
+		p, api, ts := newTestPlugin(t)
+		ts.addTailnet("key", &fakeTailnet{
+			ID:          "1001",
+			Nameservers: []string{"1.1.1.1"},
+			SearchPaths: []string{"corp.example.com"},
+			SplitDNS:    map[string][]string{"internal.example.com": {"10.0.0.53"}},
+		})
+		connectUser(t, p, "alice", "example.com", "key")
+		connectUser(t, p, "bob", "example.com", "key")
+		return p, api, ts
+	}
+
+	dns := func(ts *fakeTailscale) (nameservers, searchPaths []string, split map[string][]string) {
+		ts.tailnet("key", func(tailnet *fakeTailnet) {
+			nameservers, searchPaths, split = tailnet.Nameservers, tailnet.SearchPaths, tailnet.SplitDNS
+		})
+		return nameservers, searchPaths, split
+	}
+
+	args := &model.CommandArgs{UserId: "alice", ChannelId: "channel"}
+
+	t.Run("adding is applied right away", func(t *testing.T) {
+		p, api, ts := setup(t)
+
+		require.NoError(t, p.handleDNSList(args, dnsNameservers, "add", []string{"8.8.8.8", "1.1.1.1"}))
+		assert.Equal(t, "Successfully set the nameservers to `1.1.1.1`, `8.8.8.8` in tailnet example.com.", api.lastEphemeral(t, "alice"))
+		nameservers, _, _ := dns(ts)
+		assert.Equal(t, []string{"1.1.1.1", "8.8.8.8"}, nameservers)
+
+		require.NoError(t, p.handleDNSSplit(args, "new.example.com", []string{"10.0.0.54"}))
+		_, _, split := dns(ts)
+		assert.Equal(t, []string{"10.0.0.54"}, split["new.example.com"])
+	})
+
+	t.Run("removing asks for confirmation", func(t *testing.T) {
+		p, api, ts := setup(t)
+
+		require.NoError(t, p.handleDNSList(args, dnsSearchPaths, "remove", []string{"corp.example.com"}))
+		post := api.lastEphemeralPost(t, "alice")
+		assert.Contains(t, post.Message, "#### Change searchpaths of example.com?")
+		_, searchPaths, _ := dns(ts)
+		assert.Equal(t, []string{"corp.example.com"}, searchPaths)
+
+		_, err := p.handleDNSChangeAction("bob", actionRequest(t, post, "Apply"))
+		assert.EqualError(t, err, "only the user who proposed this change can confirm or cancel it")
+
+		message, err := p.handleDNSChangeAction("alice", actionRequest(t, post, "Apply"))
+		require.NoError(t, err)
+		assert.Equal(t, "Successfully set the search paths to none in tailnet example.com.", message)
+		_, searchPaths, _ = dns(ts)
+		assert.Empty(t, searchPaths)
+
+		message, err = p.handleDNSChangeAction("alice", actionRequest(t, post, "Apply"))
+		require.NoError(t, err)
+		assert.Equal(t, "This DNS change has expired. Run the command again to make it.", message)
+	})
+
+	t.Run("cancel", func(t *testing.T) {
+		p, api, ts := setup(t)
+
+		require.NoError(t, p.handleDNSSplit(args, "internal.example.com", nil))
+		message, err := p.handleDNSChangeAction("alice", actionRequest(t, api.lastEphemeralPost(t, "alice"), "Cancel"))
+		require.NoError(t, err)
+		assert.Equal(t, "Cancelled the DNS change.", message)
+		_, _, split := dns(ts)
+		assert.Equal(t, []string{"10.0.0.53"}, split["internal.example.com"])
+	})
+
+	t.Run("removing a split DNS route", func(t *testing.T) {
+		p, api, ts := setup(t)
+
+		require.NoError(t, p.handleDNSSplit(args, "internal.example.com", nil))
+		message, err := p.handleDNSChangeAction("alice", actionRequest(t, api.lastEphemeralPost(t, "alice"), "Apply"))
+		require.NoError(t, err)
+		assert.Equal(t, "Successfully removed the split DNS route for `internal.example.com` in tailnet example.com.", message)
+		_, _, split := dns(ts)
+		assert.NotContains(t, split, "internal.example.com")
+	})
+
+	t.Run("reconnected to another tailnet", func(t *testing.T) {
+		p, api, ts := setup(t)
+
+		require.NoError(t, p.handleDNSList(args, dnsNameservers, "set", []string{"9.9.9.9"}))
+		connectUser(t, p, "alice", "other.example.com", "key")
+
+		message, err := p.handleDNSChangeAction("alice", actionRequest(t, api.lastEphemeralPost(t, "alice"), "Apply"))
+		require.NoError(t, err)
+		assert.Equal(t, "You are no longer connected to tailnet example.com.", message)
+		nameservers, _, _ := dns(ts)
+		assert.Equal(t, []string{"1.1.1.1"}, nameservers)
+	})
+}
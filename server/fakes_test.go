@@ -0,0 +1,575 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"tailscale.com/client/tailscale"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/mattermost/mattermost/server/public/pluginapi"
+)
+
+// fakeAPI is a plugin API backed by memory. It implements the KV store, logging, posts and users;
+// any other call fails the test through the embedded mock.
+type fakeAPI struct {
+	*plugintest.API
+
+	mu        sync.Mutex
+	kv        map[string][]byte
+	users     map[string]*model.User
+	admins    map[string]bool
+	config    *model.Config
+	posts     []*model.Post
+	ephemeral map[string][]*model.Post
+	files     map[string][]byte
+}
+
+func newFakeAPI() *fakeAPI {
+	config := &model.Config{}
+	config.SetDefaults()
+
+	return &fakeAPI{
+		API:       &plugintest.API{},
+		kv:        map[string][]byte{},
+		users:     map[string]*model.User{},
+		admins:    map[string]bool{},
+		config:    config,
+		ephemeral: map[string][]*model.Post{},
+		files:     map[string][]byte{},
+	}
+}
+
+func (a *fakeAPI) KVGet(key string) ([]byte, *model.AppError) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.kv[key], nil
+}
+
+func (a *fakeAPI) KVSet(key string, value []byte) *model.AppError {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.kv[key] = value
+	return nil
+}
+
+func (a *fakeAPI) KVSetWithExpiry(key string, value []byte, _ int64) *model.AppError {
+	return a.KVSet(key, value)
+}
+
+func (a *fakeAPI) KVSetWithOptions(key string, value []byte, options model.PluginKVSetOptions) (bool, *model.AppError) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if options.Atomic && !bytes.Equal(a.kv[key], options.OldValue) {
+		return false, nil
+	}
+	if value == nil {
+		delete(a.kv, key)
+	} else {
+		a.kv[key] = value
+	}
+
+	return true, nil
+}
+
+func (a *fakeAPI) KVCompareAndSet(key string, oldValue, newValue []byte) (bool, *model.AppError) {
+	return a.KVSetWithOptions(key, newValue, model.PluginKVSetOptions{Atomic: true, OldValue: oldValue})
+}
+
+func (a *fakeAPI) KVCompareAndDelete(key string, oldValue []byte) (bool, *model.AppError) {
+	return a.KVSetWithOptions(key, nil, model.PluginKVSetOptions{Atomic: true, OldValue: oldValue})
+}
+
+func (a *fakeAPI) KVDelete(key string) *model.AppError {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	delete(a.kv, key)
+	return nil
+}
+
+func (a *fakeAPI) KVList(page, perPage int) ([]string, *model.AppError) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	keys := make([]string, 0, len(a.kv))
+	for key := range a.kv {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	start := min(page*perPage, len(keys))
+	return keys[start:min(start+perPage, len(keys))], nil
+}
+
+func (a *fakeAPI) LogDebug(string, ...any) {}
+func (a *fakeAPI) LogInfo(string, ...any)  {}
+func (a *fakeAPI) LogWarn(string, ...any)  {}
+func (a *fakeAPI) LogError(string, ...any) {}
+
+func (a *fakeAPI) GetConfig() *model.Config {
+	return a.config
+}
+
+func (a *fakeAPI) HasPermissionTo(userID string, _ *model.Permission) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.admins[userID]
+}
+
+func (a *fakeAPI) GetUser(userID string) (*model.User, *model.AppError) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if user, ok := a.users[userID]; ok {
+		return user, nil
+	}
+	return nil, model.NewAppError("GetUser", "app.user.missing_account.const", nil, "", http.StatusNotFound)
+}
+
+func (a *fakeAPI) GetUserByUsername(username string) (*model.User, *model.AppError) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, user := range a.users {
+		if user.Username == username {
+			return user, nil
+		}
+	}
+	return nil, model.NewAppError("GetUserByUsername", "app.user.missing_account.const", nil, "", http.StatusNotFound)
+}
+
+func (a *fakeAPI) GetUserByEmail(email string) (*model.User, *model.AppError) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, user := range a.users {
+		if user.Email == email {
+			return user, nil
+		}
+	}
+	return nil, model.NewAppError("GetUserByEmail", "app.user.missing_account.const", nil, "", http.StatusNotFound)
+}
+
+func (a *fakeAPI) GetDirectChannel(userID1, userID2 string) (*model.Channel, *model.AppError) {
+	return &model.Channel{Id: "dm_" + userID1 + "_" + userID2, Type: model.ChannelTypeDirect}, nil
+}
+
+func (a *fakeAPI) GetChannel(channelID string) (*model.Channel, *model.AppError) {
+	return &model.Channel{Id: channelID, Type: model.ChannelTypeOpen}, nil
+}
+
+func (a *fakeAPI) CreatePost(post *model.Post) (*model.Post, *model.AppError) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	post = post.Clone()
+	post.Id = model.NewId()
+	a.posts = append(a.posts, post)
+	return post, nil
+}
+
+func (a *fakeAPI) GetPost(postID string) (*model.Post, *model.AppError) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, post := range a.posts {
+		if post.Id == postID {
+			return post.Clone(), nil
+		}
+	}
+	return nil, model.NewAppError("GetPost", "app.post.get.app_error", nil, "", http.StatusNotFound)
+}
+
+func (a *fakeAPI) UpdatePost(post *model.Post) (*model.Post, *model.AppError) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for i := range a.posts {
+		if a.posts[i].Id == post.Id {
+			a.posts[i] = post.Clone()
+			return post.Clone(), nil
+		}
+	}
+	return nil, model.NewAppError("UpdatePost", "app.post.get.app_error", nil, "", http.StatusNotFound)
+}
+
+func (a *fakeAPI) SendEphemeralPost(userID string, post *model.Post) *model.Post {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	post = post.Clone()
+	post.Id = model.NewId()
+	a.ephemeral[userID] = append(a.ephemeral[userID], post)
+	return post
+}
+
+func (a *fakeAPI) UpdateEphemeralPost(userID string, post *model.Post) *model.Post {
+	return a.SendEphemeralPost(userID, post)
+}
+
+func (a *fakeAPI) UploadFile(data []byte, channelID, filename string) (*model.FileInfo, *model.AppError) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.files[filename] = data
+	return &model.FileInfo{Id: model.NewId(), ChannelId: channelID, Name: filename}, nil
+}
+
+// lastEphemeral returns the message of the last ephemeral post sent to the user.
+func (a *fakeAPI) lastEphemeral(t *testing.T, userID string) string {
+	t.Helper()
+	return a.lastEphemeralPost(t, userID).Message
+}
+
+// lastEphemeralPost returns the last ephemeral post sent to the user.
+func (a *fakeAPI) lastEphemeralPost(t *testing.T, userID string) *model.Post {
+	t.Helper()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	posts := a.ephemeral[userID]
+	require.NotEmpty(t, posts, "no ephemeral post sent to %s", userID)
+	return posts[len(posts)-1]
+}
+
+// actionRequest returns the request a click on the named button of the post sends.
+func actionRequest(t *testing.T, post *model.Post, name string) *model.PostActionIntegrationRequest {
+	t.Helper()
+
+	for _, attachment := range post.Attachments() {
+		for _, action := range attachment.Actions {
+			if action.Name == name {
+				return &model.PostActionIntegrationRequest{PostId: post.Id, ChannelId: post.ChannelId, Context: action.Integration.Context}
+			}
+		}
+	}
+	require.Failf(t, "button not found", "the post has no %s button", name)
+	return nil
+}
+
+// postsIn returns the messages posted to the channel.
+func (a *fakeAPI) postsIn(channelID string) []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var messages []string
+	for _, post := range a.posts {
+		if post.ChannelId == channelID {
+			messages = append(messages, post.Message)
+		}
+	}
+	return messages
+}
+
+// fakeTailnet is a tailnet served by fakeTailscale.
+type fakeTailnet struct {
+	ID      string
+	Policy  string
+	ETag    string
+	Users   []*tailnetUser
+	Invites []*userInvite
+	Keys    map[string]*tailscale.Key
+
+	Settings map[string]any
+	Webhooks map[string]*webhookEndpoint
+
+	Nameservers []string
+	SearchPaths []string
+	SplitDNS    map[string][]string
+}
+
+// fakeTailscale serves the parts of the Tailscale API the plugin uses. Requests are routed to a
+// tailnet by their API key, regardless of the tailnet name in the URL, like the real API does for
+// the "-" tailnet.
+type fakeTailscale struct {
+	mu       sync.Mutex
+	tailnets map[string]*fakeTailnet
+	mux      *http.ServeMux
+}
+
+func newFakeTailscale(t *testing.T) (*fakeTailscale, string) {
+	ts := &fakeTailscale{
+		tailnets: map[string]*fakeTailnet{},
+		mux:      http.NewServeMux(),
+	}
+
+	ts.handle("GET /api/v2/tailnet/{tailnet}/users", func(tailnet *fakeTailnet, _ *http.Request) (int, any) {
+		for _, user := range tailnet.Users {
+			user.TailnetID = tailnetID(tailnet.ID)
+		}
+		return http.StatusOK, map[string]any{"users": tailnet.Users}
+	})
+	ts.handle("GET /api/v2/tailnet/{tailnet}/acl", func(tailnet *fakeTailnet, _ *http.Request) (int, any) {
+		return http.StatusOK, map[string]any{"acl": []byte(tailnet.Policy), "warnings": []string{}}
+	})
+	ts.handle("POST /api/v2/tailnet/{tailnet}/acl/validate", func(*fakeTailnet, *http.Request) (int, any) {
+		return http.StatusOK, map[string]any{}
+	})
+	ts.handle("POST /api/v2/tailnet/{tailnet}/acl", func(tailnet *fakeTailnet, r *http.Request) (int, any) {
+		if etag := r.Header.Get("If-Match"); etag != "" && etag != tailnet.ETag {
+			return http.StatusPreconditionFailed, map[string]string{"message": "precondition failed, invalid old hash"}
+		}
+		body, _ := io.ReadAll(r.Body)
+		tailnet.Policy = string(body)
+		tailnet.ETag = fmt.Sprintf("%q", model.NewId())
+		return http.StatusOK, body
+	})
+
+	ts.handle("GET /api/v2/tailnet/{tailnet}/keys/{id}", func(tailnet *fakeTailnet, r *http.Request) (int, any) {
+		if key, ok := tailnet.Keys[r.PathValue("id")]; ok {
+			return http.StatusOK, key
+		}
+		return http.StatusNotFound, map[string]string{"message": "not found"}
+	})
+	ts.handle("DELETE /api/v2/tailnet/{tailnet}/keys/{id}", func(tailnet *fakeTailnet, r *http.Request) (int, any) {
+		if _, ok := tailnet.Keys[r.PathValue("id")]; !ok {
+			return http.StatusNotFound, map[string]string{"message": "not found"}
+		}
+		delete(tailnet.Keys, r.PathValue("id"))
+		return http.StatusOK, map[string]any{}
+	})
+
+	ts.handle("GET /api/v2/tailnet/{tailnet}/dns/nameservers", func(tailnet *fakeTailnet, _ *http.Request) (int, any) {
+		return http.StatusOK, map[string]any{"dns": tailnet.Nameservers}
+	})
+	ts.handle("POST /api/v2/tailnet/{tailnet}/dns/nameservers", func(tailnet *fakeTailnet, r *http.Request) (int, any) {
+		var request tailscale.DNSNameServers
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			return http.StatusBadRequest, map[string]string{"message": err.Error()}
+		}
+		tailnet.Nameservers = request.DNS
+		return http.StatusOK, map[string]any{"dns": tailnet.Nameservers, "magicDNS": len(tailnet.Nameservers) > 0}
+	})
+	ts.handle("GET /api/v2/tailnet/{tailnet}/dns/preferences", func(tailnet *fakeTailnet, _ *http.Request) (int, any) {
+		return http.StatusOK, map[string]any{"magicDNS": len(tailnet.Nameservers) > 0}
+	})
+	ts.handle("GET /api/v2/tailnet/{tailnet}/dns/searchpaths", func(tailnet *fakeTailnet, _ *http.Request) (int, any) {
+		return http.StatusOK, map[string]any{"searchPaths": tailnet.SearchPaths}
+	})
+	ts.handle("POST /api/v2/tailnet/{tailnet}/dns/searchpaths", func(tailnet *fakeTailnet, r *http.Request) (int, any) {
+		var request tailscale.DNSSearchPaths
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			return http.StatusBadRequest, map[string]string{"message": err.Error()}
+		}
+		tailnet.SearchPaths = request.SearchPaths
+		return http.StatusOK, map[string]any{"searchPaths": tailnet.SearchPaths}
+	})
+	ts.handle("GET /api/v2/tailnet/{tailnet}/dns/split-dns", func(tailnet *fakeTailnet, _ *http.Request) (int, any) {
+		return http.StatusOK, tailnet.SplitDNS
+	})
+	ts.handle("PATCH /api/v2/tailnet/{tailnet}/dns/split-dns", func(tailnet *fakeTailnet, r *http.Request) (int, any) {
+		var routes map[string][]string
+		if err := json.NewDecoder(r.Body).Decode(&routes); err != nil {
+			return http.StatusBadRequest, map[string]string{"message": err.Error()}
+		}
+		if tailnet.SplitDNS == nil {
+			tailnet.SplitDNS = map[string][]string{}
+		}
+		for domain, nameservers := range routes {
+			if nameservers == nil {
+				delete(tailnet.SplitDNS, domain)
+			} else {
+				tailnet.SplitDNS[domain] = nameservers
+			}
+		}
+		return http.StatusOK, tailnet.SplitDNS
+	})
+
+	ts.handle("GET /api/v2/tailnet/{tailnet}/user-invites", func(tailnet *fakeTailnet, _ *http.Request) (int, any) {
+		return http.StatusOK, tailnet.Invites
+	})
+	ts.handle("POST /api/v2/tailnet/{tailnet}/user-invites", func(tailnet *fakeTailnet, r *http.Request) (int, any) {
+		var requests []*userInvite
+		if err := json.NewDecoder(r.Body).Decode(&requests); err != nil {
+			return http.StatusBadRequest, map[string]string{"message": err.Error()}
+		}
+		var invites []*userInvite
+		for _, request := range requests {
+			id := model.NewId()
+			invite := &userInvite{
+				ID:        id,
+				Role:      request.Role,
+				TailnetID: tailnetID(tailnet.ID),
+				Email:     request.Email,
+				InviteURL: "https://login.tailscale.com/uinv/" + id,
+			}
+			tailnet.Invites = append(tailnet.Invites, invite)
+			invites = append(invites, invite)
+		}
+		return http.StatusOK, invites
+	})
+
+	ts.handle("GET /api/v2/tailnet/{tailnet}/settings", func(tailnet *fakeTailnet, _ *http.Request) (int, any) {
+		return http.StatusOK, tailnet.Settings
+	})
+	ts.handle("PATCH /api/v2/tailnet/{tailnet}/settings", func(tailnet *fakeTailnet, r *http.Request) (int, any) {
+		var changes map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&changes); err != nil {
+			return http.StatusBadRequest, map[string]string{"message": err.Error()}
+		}
+		if tailnet.Settings == nil {
+			tailnet.Settings = map[string]any{}
+		}
+		for field, value := range changes {
+			tailnet.Settings[field] = value
+		}
+		return http.StatusOK, tailnet.Settings
+	})
+
+	ts.handle("POST /api/v2/tailnet/{tailnet}/webhooks", func(tailnet *fakeTailnet, r *http.Request) (int, any) {
+		var webhook webhookEndpoint
+		if err := json.NewDecoder(r.Body).Decode(&webhook); err != nil {
+			return http.StatusBadRequest, map[string]string{"message": err.Error()}
+		}
+		webhook.EndpointID, webhook.Secret = model.NewId(), model.NewId()
+		if tailnet.Webhooks == nil {
+			tailnet.Webhooks = map[string]*webhookEndpoint{}
+		}
+		tailnet.Webhooks[webhook.EndpointID] = &webhook
+		return http.StatusOK, webhook
+	})
+	ts.handle("PATCH /api/v2/webhooks/{id}", func(tailnet *fakeTailnet, r *http.Request) (int, any) {
+		webhook, ok := tailnet.Webhooks[r.PathValue("id")]
+		if !ok {
+			return http.StatusNotFound, map[string]string{"message": "not found"}
+		}
+		if err := json.NewDecoder(r.Body).Decode(webhook); err != nil {
+			return http.StatusBadRequest, map[string]string{"message": err.Error()}
+		}
+		return http.StatusOK, webhook
+	})
+	ts.handle("DELETE /api/v2/webhooks/{id}", func(tailnet *fakeTailnet, r *http.Request) (int, any) {
+		if _, ok := tailnet.Webhooks[r.PathValue("id")]; !ok {
+			return http.StatusNotFound, map[string]string{"message": "not found"}
+		}
+		delete(tailnet.Webhooks, r.PathValue("id"))
+		return http.StatusOK, map[string]any{}
+	})
+
+	ts.handle("POST /api/v2/users/{id}/{action}", func(tailnet *fakeTailnet, r *http.Request) (int, any) {
+		i := slices.IndexFunc(tailnet.Users, func(user *tailnetUser) bool { return user.ID == r.PathValue("id") })
+		if i < 0 {
+			return http.StatusNotFound, map[string]string{"message": "user not found"}
+		}
+
+		user := tailnet.Users[i]
+		switch r.PathValue("action") {
+		case "approve", "restore":
+			user.Status = "active"
+		case "suspend":
+			user.Status = "suspended"
+		case "role":
+			var request struct {
+				Role string `json:"role"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+				return http.StatusBadRequest, map[string]string{"message": err.Error()}
+			}
+			user.Role = request.Role
+		default:
+			return http.StatusNotFound, map[string]string{"message": "unknown action"}
+		}
+		return http.StatusOK, map[string]any{}
+	})
+
+	server := httptest.NewServer(ts.mux)
+	t.Cleanup(server.Close)
+
+	return ts, server.URL
+}
+
+// addTailnet registers a tailnet that is reachable with the API key.
+func (ts *fakeTailscale) addTailnet(apiKey string, tailnet *fakeTailnet) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	if tailnet.ETag == "" {
+		tailnet.ETag = fmt.Sprintf("%q", model.NewId())
+	}
+	if len(tailnet.Users) == 0 {
+		tailnet.Users = []*tailnetUser{{ID: "owner", LoginName: "owner@example.com", Role: "owner", Status: "active"}}
+	}
+	ts.tailnets[apiKey] = tailnet
+}
+
+// removeTailnet revokes the API key.
+func (ts *fakeTailscale) removeTailnet(apiKey string) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	delete(ts.tailnets, apiKey)
+}
+
+// tailnet runs f on the tailnet while holding the lock, to inspect or change it.
+func (ts *fakeTailscale) tailnet(apiKey string, f func(tailnet *fakeTailnet)) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	f(ts.tailnets[apiKey])
+}
+
+// handle registers an API endpoint. The handler runs with the lock held on the tailnet of the
+// request's API key and returns the status and the response, which is encoded as JSON unless it
+// is a byte slice.
+func (ts *fakeTailscale) handle(pattern string, handler func(tailnet *fakeTailnet, r *http.Request) (int, any)) {
+	ts.mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+		ts.mu.Lock()
+		defer ts.mu.Unlock()
+
+		apiKey, _, _ := r.BasicAuth()
+		tailnet, ok := ts.tailnets[apiKey]
+		if !ok {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"message":"API token invalid"}`))
+			return
+		}
+
+		status, response := handler(tailnet, r)
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("ETag", tailnet.ETag)
+		w.WriteHeader(status)
+		if raw, ok := response.([]byte); ok {
+			_, _ = w.Write(raw)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(response)
+	})
+}
+
+// newTestPlugin returns a plugin that runs against a fakeAPI and a fakeTailscale.
+func newTestPlugin(t *testing.T) (*Plugin, *fakeAPI, *fakeTailscale) {
+	tailscale.I_Acknowledge_This_API_Is_Unstable = true
+
+	api := newFakeAPI()
+	ts, baseURL := newFakeTailscale(t)
+
+	p := &Plugin{botID: "bot", tailscaleAPIBase: baseURL}
+	p.SetAPI(api)
+	p.client = pluginapi.NewClient(api, nil)
+	p.setConfiguration(&configuration{})
+
+	return p, api, ts
+}
+
+// connectUser stores a Tailscale connection for the user, like /tailscale connect does.
+func connectUser(t *testing.T, p *Plugin, userID, tailnet, apiKey string) {
+	t.Helper()
+
+	data, err := json.Marshal(UserTailscaleConfig{APIKey: apiKey, Tailnet: tailnet})
+	require.NoError(t, err)
+	require.Nil(t, p.API.KVSet("tailscale_"+userID, data))
+}
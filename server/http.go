@@ -1,34 +1,358 @@
 package main
 
 import (
+	"context"
 	"fmt"
-	"log"
+	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
 	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/pkg/errors"
 	"tailscale.com/tsnet"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/pluginapi/cluster"
 )
 
-func (p *Plugin) startTSSever() error {
-	config := p.getConfiguration()
+type serveState string
+
+const (
+	serveStopped  serveState = "stopped"
+	serveStarting serveState = "starting"
+	serveRunning  serveState = "running"
+	serveStopping serveState = "stopping"
+	serveFailed   serveState = "failed"
+)
+
+const (
+	// defaultServeHostname is the name of the Tailscale node if none is configured.
+	defaultServeHostname = "mattermost"
+
+	// serveStartTimeout limits how long starting waits for the Tailscale node to come up.
+	serveStartTimeout = time.Minute
+
+	// serveShutdownTimeout limits how long stopping waits for open requests to finish.
+	serveShutdownTimeout = 10 * time.Second
+
+	// serveOwnerTimeout limits how long starting waits for another server of the cluster to
+	// release Tailscale serve.
+	serveOwnerTimeout = time.Second
+)
+
+var (
+	errServeRunning    = errors.New("Tailscale serve is already running")
+	errServeNotRunning = errors.New("Tailscale serve is not running")
+	errServeElsewhere  = errors.New("Tailscale serve is managed by another server of the cluster")
+)
+
+// serveOptions are the settings the Tailscale node is started with. Changing them requires a
+// restart; the access rules and sign-in settings are read on every request instead.
+type serveOptions struct {
+	AuthKey  string
+	Hostname string
+}
+
+// serveManager owns the tsnet node and the HTTP server proxying tailnet traffic to Mattermost.
+// Failures are recorded in its state instead of ending the plugin process.
+type serveManager struct {
+	plugin *Plugin
+
+	// owner is held by the server of the cluster running the Tailscale node, so the other
+	// servers neither join the tailnet with the same auth key nor notify the admins again.
+	owner *cluster.Mutex
+
+	// opLock serializes starting and stopping.
+	opLock sync.Mutex
+
+	// reconcileLock serializes reconciling the state with the configuration.
+	reconcileLock sync.Mutex
+
+	// lock guards the fields below.
+	lock       sync.RWMutex
+	state      serveState
+	err        error
+	options    serveOptions
+	tsServer   *tsnet.Server
+	httpServer *http.Server
+	done       chan struct{}
+	owned      bool
+}
+
+func newServeManager(p *Plugin) (*serveManager, error) {
+	owner, err := cluster.NewMutex(p.API, "serve")
+	if err != nil {
+		return nil, err
+	}
+
+	return &serveManager{
+		plugin: p,
+		owner:  owner,
+		state:  serveStopped,
+	}, nil
+}
+
+// Status returns the current state and, if it failed, the error that caused it.
+func (m *serveManager) Status() (serveState, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	return m.state, m.err
+}
+
+func (m *serveManager) setState(state serveState, err error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.state, m.err = state, err
+}
+
+// Options returns the options of the running node, or of the last attempt to start it.
+func (m *serveManager) Options() serveOptions {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	return m.options
+}
+
+// acquire makes this server the one of the cluster running the Tailscale node. It fails with
+// errServeElsewhere if another server already does.
+func (m *serveManager) acquire() error {
+	m.lock.RLock()
+	owned := m.owned
+	m.lock.RUnlock()
+	if owned {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), serveOwnerTimeout)
+	defer cancel()
+	if err := m.owner.LockWithContext(ctx); err != nil {
+		return errServeElsewhere
+	}
+
+	m.lock.Lock()
+	m.owned = true
+	m.lock.Unlock()
+
+	return nil
+}
+
+// release lets another server of the cluster run the Tailscale node.
+func (m *serveManager) release() {
+	m.lock.Lock()
+	owned := m.owned
+	m.owned = false
+	m.lock.Unlock()
+
+	if owned {
+		m.owner.Unlock()
+	}
+}
+
+// Start brings up the Tailscale node and starts proxying to Mattermost. Leftovers of a failed run
+// are torn down first.
+func (m *serveManager) Start(options serveOptions) error {
+	m.opLock.Lock()
+	defer m.opLock.Unlock()
+
+	return m.start(options)
+}
+
+// Restart stops the Tailscale node and starts it again with the given options, waiting for open
+// requests until ctx is done. No other server of the cluster can take over in between.
+func (m *serveManager) Restart(ctx context.Context, options serveOptions) error {
+	m.opLock.Lock()
+	defer m.opLock.Unlock()
+
+	if err := m.stop(ctx); err != nil && !errors.Is(err, errServeNotRunning) {
+		m.plugin.API.LogWarn("Failed to stop Tailscale serve before restarting", "error", err.Error())
+	}
+
+	return m.start(options)
+}
+
+func (m *serveManager) start(options serveOptions) error {
+	m.lock.RLock()
+	state := m.state
+	m.lock.RUnlock()
+	if state == serveRunning {
+		return errServeRunning
+	}
+
+	if err := m.acquire(); err != nil {
+		return err
+	}
+
+	m.lock.Lock()
+	tsServer, httpServer, done := m.tsServer, m.httpServer, m.done
+	m.tsServer, m.httpServer, m.done = nil, nil, nil
+	m.state, m.err, m.options = serveStarting, nil, options
+	m.lock.Unlock()
+
+	if tsServer != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), serveShutdownTimeout)
+		err := m.teardown(ctx, tsServer, httpServer, done)
+		cancel()
+		if err != nil {
+			m.plugin.API.LogWarn("Failed to tear down failed Tailscale serve", "error", err.Error())
+		}
+	}
+
+	tsServer, httpServer, ln, err := m.plugin.newServeServers(options)
+	if err != nil {
+		m.setState(serveFailed, err)
+		// Any server of the cluster may retry.
+		m.release()
+		return err
+	}
+
+	done = make(chan struct{})
+	m.lock.Lock()
+	m.tsServer, m.httpServer, m.done = tsServer, httpServer, done
+	m.state, m.err = serveRunning, nil
+	m.lock.Unlock()
+
+	go m.serve(httpServer, ln, done)
+
+	return nil
+}
+
+// serve runs the HTTP server until it is shut down. Any other error marks the manager as failed.
+func (m *serveManager) serve(httpServer *http.Server, ln net.Listener, done chan struct{}) {
+	defer close(done)
 
+	err := httpServer.Serve(ln)
+	if errors.Is(err, http.ErrServerClosed) {
+		return
+	}
+
+	m.plugin.API.LogError("Tailscale serve stopped unexpectedly", "error", err.Error())
+
+	m.lock.Lock()
+	current := m.httpServer == httpServer
+	if current {
+		m.state, m.err = serveFailed, fmt.Errorf("HTTP server stopped: %w", err)
+	}
+	m.lock.Unlock()
+
+	if current {
+		m.plugin.notifySystemAdmins(fmt.Sprintf("Tailscale serve stopped unexpectedly: %s\nRun `/tailscale serve start` to restart it.", err.Error()))
+	}
+}
+
+// Stop shuts down the HTTP server, waiting for open requests until ctx is done, and closes the
+// Tailscale node. Stopping after a failed start only clears the failure.
+func (m *serveManager) Stop(ctx context.Context) error {
+	m.opLock.Lock()
+	defer m.opLock.Unlock()
+
+	err := m.stop(ctx)
+	m.release()
+
+	return err
+}
+
+func (m *serveManager) stop(ctx context.Context) error {
+	m.lock.Lock()
+	tsServer, httpServer, done := m.tsServer, m.httpServer, m.done
+	if tsServer == nil {
+		state := m.state
+		m.state, m.err = serveStopped, nil
+		m.lock.Unlock()
+		if state == serveFailed {
+			return nil
+		}
+		return errServeNotRunning
+	}
+	m.state = serveStopping
+	m.lock.Unlock()
+
+	err := m.teardown(ctx, tsServer, httpServer, done)
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.tsServer, m.httpServer, m.done = nil, nil, nil
+	if err != nil {
+		m.state, m.err = serveFailed, err
+		return err
+	}
+	m.state, m.err = serveStopped, nil
+
+	return nil
+}
+
+func (m *serveManager) teardown(ctx context.Context, tsServer *tsnet.Server, httpServer *http.Server, done chan struct{}) error {
+	var result error
+	if err := httpServer.Shutdown(ctx); err != nil {
+		result = fmt.Errorf("failed to shut down HTTP server: %w", err)
+		_ = httpServer.Close()
+	}
+
+	if err := tsServer.Close(); err != nil && result == nil {
+		result = fmt.Errorf("failed to close Tailscale node: %w", err)
+	}
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		if result == nil {
+			result = errors.New("timed out waiting for the HTTP server to stop")
+		}
+	}
+
+	return result
+}
+
+// DNSName returns the MagicDNS name of the running Tailscale node.
+func (m *serveManager) DNSName(ctx context.Context) (string, error) {
+	m.lock.RLock()
+	tsServer := m.tsServer
+	m.lock.RUnlock()
+	if tsServer == nil {
+		return "", errServeNotRunning
+	}
+
+	lc, err := tsServer.LocalClient()
+	if err != nil {
+		return "", fmt.Errorf("Failed get local ts client: %w", err)
+	}
+	status, err := lc.Status(ctx)
+	if err != nil {
+		return "", fmt.Errorf("Failed to get status: %w", err)
+	}
+
+	return status.Self.DNSName, nil
+}
+
+// newServeServers brings up the Tailscale node and creates the HTTP server proxying its traffic
+// to Mattermost.
+func (p *Plugin) newServeServers(options serveOptions) (*tsnet.Server, *http.Server, net.Listener, error) {
 	fileDir := *p.client.Configuration.GetConfig().FileSettings.Directory
 	stateDir := filepath.Join(fileDir, "plugin-data", manifest.Id)
 
-	// Start serve
 	tsServer := &tsnet.Server{
 		Dir:      stateDir,
-		Hostname: "mattermost",
-		AuthKey:  config.AuthKey,
+		Hostname: options.Hostname,
+		AuthKey:  options.AuthKey,
 	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), serveStartTimeout)
+	defer cancel()
+	if _, err := tsServer.Up(ctx); err != nil {
+		_ = tsServer.Close()
+		return nil, nil, nil, fmt.Errorf("Failed to bring up Tailscale node: %w", err)
+	}
+
 	ln, err := tsServer.ListenTLS("tcp", ":443")
 	if err != nil {
-		return fmt.Errorf("Failed to listen: %w", err)
+		_ = tsServer.Close()
+		return nil, nil, nil, fmt.Errorf("Failed to listen: %w", err)
 	}
-	p.tsServer = tsServer
 
 	// Create a reverse proxy
 	la := *p.client.Configuration.GetConfig().ServiceSettings.ListenAddress
@@ -37,17 +361,124 @@ func (p *Plugin) startTSSever() error {
 	}
 	target, err := url.Parse("http://" + la)
 	if err != nil {
-		return fmt.Errorf("Failed to parse ListenAddress: %w", err)
+		_ = tsServer.Close()
+		return nil, nil, nil, fmt.Errorf("Failed to parse ListenAddress: %w", err)
 	}
 
 	proxy := httputil.NewSingleHostReverseProxy(target)
 
-	// Serve HTTP traffic over Tailscale
-	go func() {
-		if err := http.Serve(ln, proxy); err != nil {
-			log.Fatalf("Failed to start HTTP server: %v", err)
+	lc, err := tsServer.LocalClient()
+	if err != nil {
+		_ = tsServer.Close()
+		return nil, nil, nil, fmt.Errorf("Failed get local ts client: %w", err)
+	}
+
+	httpServer := &http.Server{
+		Handler:           p.serveAccess(lc, p.tailscaleSSO(lc, target, proxy)),
+		ReadHeaderTimeout: 30 * time.Second,
+	}
+
+	return tsServer, httpServer, ln, nil
+}
+
+// reconcileServe starts, stops or restarts Tailscale serve to match the configuration. The outcome
+// is logged and sent to the system admins if notify is set or it failed. Only the server of the
+// cluster running serve reconciles it; the other servers leave it alone.
+func (p *Plugin) reconcileServe(notify bool) {
+	p.serve.reconcileLock.Lock()
+	defer p.serve.reconcileLock.Unlock()
+
+	config := p.getConfiguration()
+	options := config.serveOptions()
+	state, _ := p.serve.Status()
+
+	var message string
+	switch {
+	case !config.Serve:
+		if state == serveStopped {
+			return
 		}
-	}()
 
-	return nil
+		ctx, cancel := context.WithTimeout(context.Background(), serveShutdownTimeout)
+		defer cancel()
+		err := p.serve.Stop(ctx)
+		switch {
+		case errors.Is(err, errServeNotRunning):
+			return
+		case err != nil:
+			p.API.LogError("Failed to stop Tailscale serve after a configuration change", "error", err.Error())
+			message = fmt.Sprintf("Tailscale serve was disabled in the plugin settings, but failed to stop: %s", err.Error())
+			notify = true
+		default:
+			p.API.LogInfo("Stopped Tailscale serve after a configuration change")
+			message = "Tailscale serve was disabled in the plugin settings and has been stopped."
+		}
+	case p.serve.Options() == options && state != serveStopped:
+		// Already running, starting or failed with these options. A failed node is only
+		// retried with /tailscale serve start or once the options change.
+		return
+	default:
+		restart := state != serveStopped
+		var err error
+		if restart {
+			ctx, cancel := context.WithTimeout(context.Background(), serveShutdownTimeout)
+			err = p.serve.Restart(ctx, options)
+			cancel()
+		} else {
+			err = p.serve.Start(options)
+		}
+
+		switch {
+		case errors.Is(err, errServeElsewhere):
+			p.API.LogDebug("Tailscale serve is managed by another server of the cluster")
+			return
+		case err != nil:
+			p.API.LogError("Failed to start Tailscale serve after a configuration change", "error", err.Error())
+			message = fmt.Sprintf("Tailscale serve failed to start: %s\nRun `/tailscale serve start` to retry.", err.Error())
+			notify = true
+		default:
+			action := "started"
+			if restart {
+				action = "restarted"
+			}
+			p.API.LogInfo("Tailscale serve "+action+" after a configuration change", "hostname", options.Hostname)
+			message = fmt.Sprintf("Tailscale serve was %s after a configuration change.", action)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			if dnsName, err := p.serve.DNSName(ctx); err == nil {
+				message += fmt.Sprintf(" Mattermost is available at https://%s", strings.TrimSuffix(dnsName, "."))
+			}
+		}
+	}
+
+	if notify {
+		p.notifySystemAdmins(message)
+	}
+}
+
+// notifySystemAdmins sends a direct message to every active system admin.
+func (p *Plugin) notifySystemAdmins(message string) {
+	for page := 0; ; page++ {
+		admins, err := p.client.User.List(&model.UserGetOptions{
+			Role:    model.SystemAdminRoleId,
+			Active:  true,
+			Page:    page,
+			PerPage: 100,
+		})
+		if err != nil {
+			p.API.LogError("Failed to list system admins", "error", err.Error())
+			return
+		}
+
+		for _, admin := range admins {
+			if err := p.sendDirectMessage(admin.Id, message); err != nil {
+				p.API.LogWarn("Failed to notify system admin", "user_id", admin.Id, "error", err.Error())
+			}
+		}
+
+		if len(admins) < 100 {
+			return
+		}
+	}
 }
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+)
+
+func TestServeManagerStop(t *testing.T) {
+	t.Run("stopped", func(t *testing.T) {
+		m, err := newServeManager(&Plugin{})
+		require.NoError(t, err)
+
+		assert.ErrorIs(t, m.Stop(context.Background()), errServeNotRunning)
+		state, serveErr := m.Status()
+		assert.Equal(t, serveStopped, state)
+		assert.NoError(t, serveErr)
+	})
+
+	t.Run("failed to start", func(t *testing.T) {
+		m, err := newServeManager(&Plugin{})
+		require.NoError(t, err)
+		m.setState(serveFailed, errors.New("failed to bring up Tailscale node"))
+
+		assert.NoError(t, m.Stop(context.Background()))
+		state, serveErr := m.Status()
+		assert.Equal(t, serveStopped, state)
+		assert.NoError(t, serveErr)
+
+		assert.ErrorIs(t, m.Stop(context.Background()), errServeNotRunning)
+	})
+}
+
+func TestServeManagerStartElsewhere(t *testing.T) {
+	api := &plugintest.API{}
+	api.On("KVSetWithOptions", "mutex_serve", mock.Anything, mock.Anything).Return(false, nil)
+
+	p := &Plugin{}
+	p.SetAPI(api)
+	m, err := newServeManager(p)
+	require.NoError(t, err)
+
+	assert.ErrorIs(t, m.Start(serveOptions{AuthKey: "tskey-auth-test", Hostname: "mattermost"}), errServeElsewhere)
+	state, serveErr := m.Status()
+	assert.Equal(t, serveStopped, state)
+	assert.NoError(t, serveErr)
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+const tailnetInviteKeyPrefix = "tailnet_invite_"
+
+// tailnetInvite records an invite sent from Mattermost, so its status can be tracked after
+// Tailscale stops listing it once it is accepted. Invites are listed by TailnetID, as the tailnet
+// name is often "-" for every connected tailnet.
+type tailnetInvite struct {
+	ID        string
+	Tailnet   string
+	TailnetID string
+	UserID    string
+	InviterID string
+	Email     string
+	Role      string
+	CreateAt  int64
+}
+
+// parseInvite parses the arguments of /tailscale invite.
+func parseInvite(fields []string) (string, string, error) {
+	var username string
+	role := "member"
+	for i := 0; i < len(fields); i++ {
+		switch {
+		case fields[i] == "--role":
+			if i+1 == len(fields) {
+				return "", "", errors.New("missing value for --role")
+			}
+			role = fields[i+1]
+			i++
+		case strings.HasPrefix(fields[i], "--"):
+			return "", "", errors.Errorf("unknown option %q", fields[i])
+		case username != "":
+			return "", "", errors.New("only one user can be invited at a time")
+		default:
+			username = strings.TrimPrefix(fields[i], "@")
+		}
+	}
+
+	if username == "" {
+		return "", "", errors.New("the user to invite is missing")
+	}
+	if !slices.Contains(tailnetUserRoles, role) {
+		return "", "", errors.Errorf("unknown role %q. Available roles: %s", role, strings.Join(tailnetUserRoles, ", "))
+	}
+
+	return username, role, nil
+}
+
+// handleInvite creates a tailnet invite for a Mattermost user and sends them the invite link by
+// direct message.
+func (p *Plugin) handleInvite(args *model.CommandArgs, fields []string) error {
+	username, role, err := parseInvite(fields)
+	if err != nil {
+		p.postEphemeral(args.UserId, args.ChannelId, fmt.Sprintf("%s\nUsage: /tailscale invite @user [--role member]", err.Error()))
+		return nil
+	}
+
+	if ok, err := p.requireUserAdmin(args); err != nil || !ok {
+		return err
+	}
+
+	client, config, err := p.getTailscaleClient(args)
+	if err != nil || client == nil {
+		return err
+	}
+
+	user, err := p.client.User.GetByUsername(username)
+	if err != nil {
+		p.postEphemeral(args.UserId, args.ChannelId, fmt.Sprintf("User @%s was not found.", username))
+		return nil
+	}
+	if user.IsBot || user.DeleteAt != 0 || user.Email == "" {
+		p.postEphemeral(args.UserId, args.ChannelId, fmt.Sprintf("@%s cannot be invited to the tailnet.", user.Username))
+		return nil
+	}
+
+	ctx := context.Background()
+	users, err := listTailnetUsers(ctx, client)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve users from Tailscale API: %w", err)
+	}
+	if existing := findTailnetUser(users, user.Email); existing != nil {
+		p.postEphemeral(args.UserId, args.ChannelId, fmt.Sprintf("@%s is already a user of tailnet %s as %s.", user.Username, config.Tailnet, existing.LoginName))
+		return nil
+	}
+
+	invite, err := createUserInvite(ctx, client, user.Email, role)
+	if err != nil {
+		return fmt.Errorf("failed to create invite: %w", err)
+	}
+
+	tailnetID := string(invite.TailnetID)
+	if tailnetID == "" {
+		if tailnetID, err = resolveTailnetID(ctx, client); err != nil {
+			return err
+		}
+	}
+
+	record := &tailnetInvite{
+		ID:        invite.ID,
+		Tailnet:   config.Tailnet,
+		TailnetID: tailnetID,
+		UserID:    user.Id,
+		InviterID: args.UserId,
+		Email:     user.Email,
+		Role:      role,
+		CreateAt:  model.GetMillis(),
+	}
+	if _, err := p.client.KV.Set(tailnetInviteKeyPrefix+invite.ID, record); err != nil {
+		return fmt.Errorf("failed to store invite: %w", err)
+	}
+
+	p.API.LogInfo("Created tailnet invite", "invite_id", invite.ID, "tailnet", config.Tailnet, "invitee_id", user.Id, "role", role, "user_id", args.UserId)
+
+	message := fmt.Sprintf("#### You have been invited to tailnet %s\n%s invited you to join as %s. Accept the invite with the email address %s:\n%s",
+		config.Tailnet, p.mentionUser(args.UserId), role, user.Email, invite.InviteURL)
+	if err := p.sendDirectMessage(user.Id, message); err != nil {
+		p.API.LogWarn("Failed to send invite", "invite_id", invite.ID, "error", err.Error())
+		p.postEphemeral(args.UserId, args.ChannelId, fmt.Sprintf("Invited @%s to tailnet %s as %s, but the invite link could not be sent to them. Share this link with them instead: %s",
+			user.Username, config.Tailnet, role, invite.InviteURL))
+		return nil
+	}
+
+	p.postEphemeral(args.UserId, args.ChannelId, fmt.Sprintf("Invited @%s to tailnet %s as %s. The invite link has been sent to them as a direct message.", user.Username, config.Tailnet, role))
+	return nil
+}
+
+// listTailnetInvites returns the recorded invites of the tailnet with the given ID.
+func (p *Plugin) listTailnetInvites(tailnetID string) ([]*tailnetInvite, error) {
+	keys, err := p.listKeysWithPrefix(tailnetInviteKeyPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	var invites []*tailnetInvite
+	for _, key := range keys {
+		var invite tailnetInvite
+		if err := p.client.KV.Get(key, &invite); err != nil {
+			return nil, fmt.Errorf("failed to get invite: %w", err)
+		}
+		if invite.ID != "" && invite.TailnetID == tailnetID {
+			invites = append(invites, &invite)
+		}
+	}
+
+	return invites, nil
+}
+
+// handleInvites lists the invites sent from Mattermost. Invites Tailscale still lists are pending,
+// invites whose email belongs to a tailnet user were accepted and all others expired or were
+// cancelled in the admin console.
+func (p *Plugin) handleInvites(args *model.CommandArgs) error {
+	if ok, err := p.requireUserAdmin(args); err != nil || !ok {
+		return err
+	}
+
+	client, config, err := p.getTailscaleClient(args)
+	if err != nil || client == nil {
+		return err
+	}
+
+	ctx := context.Background()
+	tailnetID, err := resolveTailnetID(ctx, client)
+	if err != nil {
+		return err
+	}
+
+	invites, err := p.listTailnetInvites(tailnetID)
+	if err != nil {
+		return err
+	}
+	if len(invites) == 0 {
+		p.postEphemeral(args.UserId, args.ChannelId, fmt.Sprintf("No invites have been sent for tailnet %s.", config.Tailnet))
+		return nil
+	}
+
+	pending, err := listUserInvites(ctx, client)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve invites from Tailscale API: %w", err)
+	}
+	users, err := listTailnetUsers(ctx, client)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve users from Tailscale API: %w", err)
+	}
+
+	sort.Slice(invites, func(i, j int) bool {
+		return invites[i].CreateAt > invites[j].CreateAt
+	})
+
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("#### Invites of %s\n| User | Email | Role | Invited by | Sent | Status |\n| --- | --- | --- | --- | --- | --- |\n", config.Tailnet))
+	for _, invite := range invites {
+		status := "expired or cancelled"
+		switch {
+		case slices.ContainsFunc(pending, func(pending *userInvite) bool { return pending.ID == invite.ID }):
+			status = "pending"
+		case findTailnetUser(users, invite.Email) != nil:
+			status = "accepted"
+		}
+
+		b.WriteString(fmt.Sprintf("| %s | %s | %s | %s | %s | %s |\n",
+			p.mentionUser(invite.UserID), invite.Email, invite.Role, p.mentionUser(invite.InviterID),
+			time.UnixMilli(invite.CreateAt).UTC().Format(time.RFC3339), status))
+	}
+
+	return p.postEphemeralOrFile(args.UserId, args.ChannelId, b.String(), "invites.md", []byte(b.String()), "")
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+func TestTailnetInvites(t *testing.T) {
+	setup := func(t *testing.T) (*Plugin, *fakeAPI, *fakeTailscale) {
+		p, api, ts := newTestPlugin(t)
+		p.setConfiguration(&configuration{UserAdmins: "@carol, @dave"})
+		api.users["carol"] = &model.User{Id: "carol", Username: "carol"}
+		api.users["dave"] = &model.User{Id: "dave", Username: "dave"}
+		api.users["erin"] = &model.User{Id: "erin", Username: "erin"}
+		api.users["frank"] = &model.User{Id: "frank", Username: "frank", Email: "frank@example.com"}
+		ts.addTailnet("key-a", &fakeTailnet{ID: "1001"})
+		ts.addTailnet("key-b", &fakeTailnet{ID: "2002"})
+		// Both admins connect to the default tailnet of their API key.
+		connectUser(t, p, "carol", "-", "key-a")
+		connectUser(t, p, "dave", "-", "key-b")
+		connectUser(t, p, "erin", "-", "key-a")
+		return p, api, ts
+	}
+
+	t.Run("non-admins cannot invite or list invites", func(t *testing.T) {
+		p, api, ts := setup(t)
+		args := &model.CommandArgs{UserId: "erin", ChannelId: "channel"}
+
+		require.NoError(t, p.handleInvite(args, []string{"@frank"}))
+		assert.Equal(t, "Only the configured tailnet user admins can manage tailnet users.", api.lastEphemeral(t, "erin"))
+		ts.tailnet("key-a", func(tailnet *fakeTailnet) {
+			assert.Empty(t, tailnet.Invites)
+		})
+
+		require.NoError(t, p.handleInvites(args))
+		assert.Equal(t, "Only the configured tailnet user admins can manage tailnet users.", api.lastEphemeral(t, "erin"))
+	})
+
+	t.Run("admin invites a user", func(t *testing.T) {
+		p, api, ts := setup(t)
+
+		require.NoError(t, p.handleInvite(&model.CommandArgs{UserId: "carol", ChannelId: "channel"}, []string{"@frank", "--role", "admin"}))
+		assert.Equal(t, "Invited @frank to tailnet - as admin. The invite link has been sent to them as a direct message.", api.lastEphemeral(t, "carol"))
+
+		var invites []*userInvite
+		ts.tailnet("key-a", func(tailnet *fakeTailnet) {
+			invites = tailnet.Invites
+		})
+		require.Len(t, invites, 1)
+		assert.Equal(t, "frank@example.com", invites[0].Email)
+		assert.Equal(t, "admin", invites[0].Role)
+
+		require.NoError(t, p.handleInvites(&model.CommandArgs{UserId: "carol", ChannelId: "channel"}))
+		message := api.lastEphemeral(t, "carol")
+		assert.Contains(t, message, "| frank@example.com | admin |")
+		assert.Contains(t, message, "| pending |")
+	})
+
+	t.Run("invites are listed per tailnet", func(t *testing.T) {
+		p, api, _ := setup(t)
+
+		require.NoError(t, p.handleInvite(&model.CommandArgs{UserId: "carol", ChannelId: "channel"}, []string{"@frank"}))
+		require.NoError(t, p.handleInvites(&model.CommandArgs{UserId: "dave", ChannelId: "channel"}))
+		assert.Equal(t, "No invites have been sent for tailnet -.", api.lastEphemeral(t, "dave"))
+
+		invites, err := p.listTailnetInvites("1001")
+		require.NoError(t, err)
+		require.Len(t, invites, 1)
+		assert.Equal(t, "frank", invites[0].UserID)
+	})
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+const (
+	keyRequestPending   = "pending"
+	keyRequestApproving = "approving"
+	keyRequestIssued    = "issued"
+	keyRequestDenied    = "denied"
+	keyRequestFailed    = "failed"
+)
+
+// keyRequest is a request for an auth key by a user who cannot create keys themselves. Requests
+// are posted to the configured approvers channel and removed from the KV store once decided.
+type keyRequest struct {
+	ID        string
+	UserID    string
+	Options   authKeyOptions
+	Reason    string
+	Status    string
+	Detail    string
+	DeciderID string
+	KeyID     string
+	PostID    string
+	CreateAt  int64
+}
+
+// errKeyRequestNotFound is returned when updating a request that no longer exists.
+var errKeyRequestNotFound = errors.New("the auth key request no longer exists")
+
+func keyRequestKey(id string) string {
+	return "key_request_" + id
+}
+
+// getKeyRequestChannel resolves the configured approvers channel for auth key requests.
+func (p *Plugin) getKeyRequestChannel() (*model.Channel, error) {
+	setting := strings.TrimSpace(p.getConfiguration().KeyRequestChannel)
+	if setting == "" {
+		return nil, errors.New("auth key requests are not enabled. Ask a System Admin to configure the auth key request channel")
+	}
+
+	channel, err := p.resolveChannel(setting)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get auth key request channel: %w", err)
+	}
+
+	return channel, nil
+}
+
+// parseKeyRequest parses the arguments of /tailscale keys request, which are the options of
+// /tailscale keys create plus a required reason.
+func parseKeyRequest(fields []string) (*authKeyOptions, string, error) {
+	var reason string
+	var rest []string
+	for i := 0; i < len(fields); i++ {
+		if fields[i] != "--reason" {
+			rest = append(rest, fields[i])
+			continue
+		}
+		if i+1 == len(fields) {
+			return nil, "", errors.New("missing value for --reason")
+		}
+		reason = fields[i+1]
+		i++
+	}
+
+	options, err := parseAuthKeyOptions(rest)
+	if err != nil {
+		return nil, "", err
+	}
+	if strings.TrimSpace(reason) == "" {
+		return nil, "", errors.New("the reason is missing, use --reason to set it")
+	}
+
+	return options, reason, nil
+}
+
+func (p *Plugin) handleKeysRequest(args *model.CommandArgs, fields []string) error {
+	options, reason, err := parseKeyRequest(fields)
+	if err != nil {
+		p.postEphemeral(args.UserId, args.ChannelId, fmt.Sprintf("%s\nUsage: /tailscale keys request --reason <reason> [--tags tag:a,tag:b] [--reusable] [--ephemeral] [--preauthorized] [--expiry 1d]", err.Error()))
+		return nil
+	}
+
+	channel, err := p.getKeyRequestChannel()
+	if err != nil {
+		return err
+	}
+	if _, _, err := p.adminClient(); err != nil {
+		return err
+	}
+
+	if options.Description == "" {
+		user, err := p.client.User.Get(args.UserId)
+		if err != nil {
+			return fmt.Errorf("failed to get user: %w", err)
+		}
+		options.Description = defaultKeyDescription(user.Username)
+	}
+
+	request := &keyRequest{
+		ID:       model.NewId(),
+		UserID:   args.UserId,
+		Options:  *options,
+		Reason:   reason,
+		Status:   keyRequestPending,
+		CreateAt: model.GetMillis(),
+	}
+
+	post := &model.Post{
+		ChannelId: channel.Id,
+		UserId:    p.botID,
+	}
+	p.renderKeyRequest(post, request)
+	if err := p.client.Post.CreatePost(post); err != nil {
+		return fmt.Errorf("failed to post auth key request: %w", err)
+	}
+
+	request.PostID = post.Id
+	data, err := json.Marshal(request)
+	if err != nil {
+		return fmt.Errorf("failed to marshal auth key request: %w", err)
+	}
+	if appErr := p.API.KVSet(keyRequestKey(request.ID), data); appErr != nil {
+		return fmt.Errorf("failed to store auth key request: %w", appErr)
+	}
+
+	p.API.LogInfo("Auth key requested", "request_id", request.ID, "user_id", args.UserId, "capabilities", formatKeyCapabilities(options.capabilities()))
+	p.postEphemeral(args.UserId, args.ChannelId, "Your auth key request has been sent to the approvers. The key will be sent to you as a direct message once it is approved.")
+
+	return nil
+}
+
+// renderKeyRequest sets the message and buttons of an auth key request post.
+func (p *Plugin) renderKeyRequest(post *model.Post, request *keyRequest) {
+	expiry := "tailnet default"
+	if request.Options.Expiry > 0 {
+		expiry = formatDuration(request.Options.Expiry)
+	}
+
+	var b strings.Builder
+	b.WriteString("#### Auth key request\n")
+	b.WriteString(fmt.Sprintf("%s requests an auth key.\n", p.mentionUser(request.UserID)))
+	b.WriteString(fmt.Sprintf("**Capabilities:** %s\n", formatKeyCapabilities(request.Options.capabilities())))
+	b.WriteString(fmt.Sprintf("**Expiry:** %s\n", expiry))
+	b.WriteString(fmt.Sprintf("**Reason:** %s\n", request.Reason))
+
+	switch request.Status {
+	case keyRequestIssued:
+		b.WriteString(fmt.Sprintf("**Status:** approved by %s, key `%s` sent to %s", p.mentionUser(request.DeciderID), request.KeyID, p.mentionUser(request.UserID)))
+	case keyRequestDenied:
+		b.WriteString(fmt.Sprintf("**Status:** denied by %s", p.mentionUser(request.DeciderID)))
+	case keyRequestFailed:
+		b.WriteString(fmt.Sprintf("**Status:** approved by %s, but failed: %s", p.mentionUser(request.DeciderID), request.Detail))
+	default:
+		b.WriteString(fmt.Sprintf("**Status:** %s", request.Status))
+	}
+
+	post.Message = b.String()
+	post.DelProp("attachments")
+
+	if request.Status != keyRequestPending {
+		return
+	}
+
+	model.ParseSlackAttachment(post, []*model.SlackAttachment{{
+		Actions: []*model.PostAction{
+			keyRequestAction("Approve", "approve", "success", request.ID),
+			keyRequestAction("Deny", "deny", "danger", request.ID),
+		},
+	}})
+}
+
+func keyRequestAction(name, action, style, requestID string) *model.PostAction {
+	return &model.PostAction{
+		Type:  model.PostActionTypeButton,
+		Name:  name,
+		Style: style,
+		Integration: &model.PostActionIntegration{
+			URL: actionURL("/api/v1/keys/requests"),
+			Context: map[string]any{
+				"request_id": requestID,
+				"action":     action,
+			},
+		},
+	}
+}
+
+// updateKeyRequest atomically modifies a stored auth key request. update returns an error to
+// abort the modification.
+func (p *Plugin) updateKeyRequest(id string, update func(request *keyRequest) error) (*keyRequest, error) {
+	var updated keyRequest
+	err := p.client.KV.SetAtomicWithRetries(keyRequestKey(id), func(oldValue []byte) (any, error) {
+		if oldValue == nil {
+			return nil, errKeyRequestNotFound
+		}
+
+		updated = keyRequest{}
+		if err := json.Unmarshal(oldValue, &updated); err != nil {
+			return nil, err
+		}
+
+		if err := update(&updated); err != nil {
+			return nil, err
+		}
+
+		return updated, nil
+	})
+	if err != nil {
+		return nil, errors.Cause(err)
+	}
+
+	return &updated, nil
+}
+
+// handleKeyRequestAction approves or denies an auth key request. Any member of the approvers
+// channel other than the requester can decide on a request.
+func (p *Plugin) handleKeyRequestAction(userID string, actionRequest *model.PostActionIntegrationRequest) (string, error) {
+	channel, err := p.getKeyRequestChannel()
+	if err != nil {
+		return "", err
+	}
+	if channel.Id != actionRequest.ChannelId {
+		return "", errors.New("this auth key request was not posted to the approvers channel")
+	}
+	if _, err := p.client.Channel.GetMember(channel.Id, userID); err != nil {
+		return "", errors.New("only members of the approvers channel can approve or deny auth key requests")
+	}
+
+	action := contextString(actionRequest, "action")
+	request, err := p.updateKeyRequest(contextString(actionRequest, "request_id"), func(request *keyRequest) error {
+		if err := checkDecision("auth key request", request.Status, request.UserID, userID); err != nil {
+			return err
+		}
+
+		request.DeciderID = userID
+		switch action {
+		case "approve":
+			request.Status = keyRequestApproving
+		case "deny":
+			request.Status = keyRequestDenied
+		default:
+			return fmt.Errorf("unknown action %q", action)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	p.API.LogInfo("Auth key request decision", "request_id", request.ID, "user_id", userID, "action", action)
+
+	if request.Status == keyRequestApproving {
+		request = p.issueRequestedKey(request)
+	} else if err := p.sendDirectMessage(request.UserID, fmt.Sprintf("Your auth key request was denied by %s.", p.mentionUser(userID))); err != nil {
+		p.API.LogWarn("Failed to notify requester", "request_id", request.ID, "error", err.Error())
+	}
+
+	post, err := p.client.Post.GetPost(request.PostID)
+	if err != nil {
+		p.API.LogError("Failed to get auth key request post", "request_id", request.ID, "error", err.Error())
+	} else {
+		p.renderKeyRequest(post, request)
+		if err := p.client.Post.UpdatePost(post); err != nil {
+			p.API.LogError("Failed to update auth key request post", "request_id", request.ID, "error", err.Error())
+		}
+	}
+
+	if appErr := p.API.KVDelete(keyRequestKey(request.ID)); appErr != nil {
+		p.API.LogWarn("Failed to delete auth key request", "request_id", request.ID, "error", appErr.Error())
+	}
+
+	return "", nil
+}
+
+// issueRequestedKey creates the requested key with the configured admin credential and sends it to
+// the requester.
+func (p *Plugin) issueRequestedKey(request *keyRequest) *keyRequest {
+	client, tailnet, err := p.adminClient()
+	if err == nil {
+		request.KeyID, err = p.createAndSendAuthKey(client, tailnet, request.UserID, &request.Options)
+	}
+
+	if err != nil {
+		request.Status, request.Detail = keyRequestFailed, err.Error()
+		p.API.LogWarn("Failed to issue requested auth key", "request_id", request.ID, "error", err.Error())
+		if err := p.sendDirectMessage(request.UserID, fmt.Sprintf("Your auth key request was approved, but the key could not be created: %s", request.Detail)); err != nil {
+			p.API.LogWarn("Failed to notify requester", "request_id", request.ID, "error", err.Error())
+		}
+		return request
+	}
+
+	request.Status = keyRequestIssued
+	p.API.LogInfo("Issued requested auth key", "request_id", request.ID, "key_id", request.KeyID, "user_id", request.UserID, "approver_id", request.DeciderID)
+
+	return request
+}
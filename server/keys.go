@@ -0,0 +1,346 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"tailscale.com/client/tailscale"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+const (
+	// maxAuthKeyExpiry is the longest expiry Tailscale accepts for auth keys.
+	maxAuthKeyExpiry = 90 * 24 * time.Hour
+
+	// maxKeyDescriptionLength is the longest key description Tailscale accepts.
+	maxKeyDescriptionLength = 50
+
+	// pendingKeyRevocationTTL is how long revoking a key can be confirmed, in seconds.
+	pendingKeyRevocationTTL = 10 * 60
+)
+
+// pendingKeyRevocation is a key revocation waiting for the user's confirmation.
+type pendingKeyRevocation struct {
+	ID      string
+	UserID  string
+	Tailnet string
+	KeyID   string
+}
+
+func pendingKeyRevocationKey(id string) string {
+	return "key_revocation_" + id
+}
+
+func (r *pendingKeyRevocation) proposedBy() string {
+	return r.UserID
+}
+
+// authKeyOptions are the options of an auth key to create.
+type authKeyOptions struct {
+	Reusable      bool
+	Ephemeral     bool
+	Preauthorized bool
+	Tags          []string
+	Expiry        time.Duration
+	Description   string
+}
+
+func (o *authKeyOptions) capabilities() tailscale.KeyCapabilities {
+	return tailscale.KeyCapabilities{
+		Devices: tailscale.KeyDeviceCapabilities{
+			Create: tailscale.KeyDeviceCreateCapabilities{
+				Reusable:      o.Reusable,
+				Ephemeral:     o.Ephemeral,
+				Preauthorized: o.Preauthorized,
+				Tags:          o.Tags,
+			},
+		},
+	}
+}
+
+// parseAuthKeyOptions parses the flags of /tailscale keys create.
+func parseAuthKeyOptions(fields []string) (*authKeyOptions, error) {
+	options := &authKeyOptions{}
+	for i := 0; i < len(fields); i++ {
+		switch fields[i] {
+		case "--reusable":
+			options.Reusable = true
+		case "--ephemeral":
+			options.Ephemeral = true
+		case "--preauthorized":
+			options.Preauthorized = true
+		case "--tags", "--expiry", "--description":
+			if i+1 == len(fields) {
+				return nil, errors.Errorf("missing value for %s", fields[i])
+			}
+			value := fields[i+1]
+			i++
+
+			switch fields[i-1] {
+			case "--tags":
+				for _, tag := range strings.Split(value, ",") {
+					tag = strings.TrimSpace(tag)
+					if !isTag(tag) {
+						return nil, errors.Errorf("invalid tag %q, tags start with tag:", tag)
+					}
+					options.Tags = append(options.Tags, tag)
+				}
+			case "--expiry":
+				expiry, err := parseDuration(value)
+				if err != nil {
+					return nil, err
+				}
+				if expiry > maxAuthKeyExpiry || expiry < time.Second {
+					return nil, errors.Errorf("the expiry must be between 1s and %s", formatDuration(maxAuthKeyExpiry))
+				}
+				options.Expiry = expiry
+			case "--description":
+				options.Description = value
+			}
+		default:
+			return nil, errors.Errorf("unknown option %q", fields[i])
+		}
+	}
+
+	if len(options.Description) > maxKeyDescriptionLength {
+		return nil, errors.Errorf("the description must not be longer than %d characters", maxKeyDescriptionLength)
+	}
+
+	return options, nil
+}
+
+// formatKeyCapabilities describes the capabilities of an auth key.
+func formatKeyCapabilities(caps tailscale.KeyCapabilities) string {
+	create := caps.Devices.Create
+
+	var flags []string
+	if create.Reusable {
+		flags = append(flags, "reusable")
+	}
+	if create.Ephemeral {
+		flags = append(flags, "ephemeral")
+	}
+	if create.Preauthorized {
+		flags = append(flags, "preauthorized")
+	}
+	flags = append(flags, create.Tags...)
+
+	return joinCell(flags)
+}
+
+func (p *Plugin) handleKeysCreate(args *model.CommandArgs, fields []string) error {
+	options, err := parseAuthKeyOptions(fields)
+	if err != nil {
+		p.postEphemeral(args.UserId, args.ChannelId, fmt.Sprintf("%s\nUsage: /tailscale keys create [--reusable] [--ephemeral] [--preauthorized] [--tags tag:a,tag:b] [--expiry 1d] [--description <text>]", err.Error()))
+		return nil
+	}
+
+	client, config, err := p.getTailscaleClient(args)
+	if err != nil || client == nil {
+		return err
+	}
+
+	user, err := p.client.User.Get(args.UserId)
+	if err != nil {
+		return fmt.Errorf("failed to get user: %w", err)
+	}
+	if options.Description == "" {
+		options.Description = defaultKeyDescription(user.Username)
+	}
+
+	keyID, err := p.createAndSendAuthKey(client, config.Tailnet, args.UserId, options)
+	if err != nil {
+		return err
+	}
+
+	p.postEphemeral(args.UserId, args.ChannelId, fmt.Sprintf("Created auth key `%s`. The key has been sent to you as a direct message.", keyID))
+	return nil
+}
+
+// defaultKeyDescription describes keys created from Mattermost, so they can be recognized in the
+// list of keys.
+func defaultKeyDescription(username string) string {
+	description := "Mattermost " + username
+	if len(description) > maxKeyDescriptionLength {
+		description = description[:maxKeyDescriptionLength]
+	}
+
+	return description
+}
+
+// createAndSendAuthKey creates an auth key and delivers it to the recipient by direct message. The
+// key is never posted anywhere else; if it cannot be delivered, it is deleted again. It returns
+// the ID of the key.
+func (p *Plugin) createAndSendAuthKey(client *tailscale.Client, tailnet, recipientID string, options *authKeyOptions) (string, error) {
+	secret, key, err := createAuthKey(context.Background(), client, options.capabilities(), options.Expiry, options.Description)
+	if err != nil {
+		return "", fmt.Errorf("failed to create auth key: %w", err)
+	}
+
+	p.API.LogInfo("Created auth key", "key_id", key.ID, "tailnet", tailnet, "user_id", recipientID,
+		"capabilities", formatKeyCapabilities(key.Capabilities), "expires", key.Expires.UTC().Format(time.RFC3339))
+
+	message := fmt.Sprintf("#### Auth key for %s\n```\n%s\n```\n**Key ID:** `%s`\n**Capabilities:** %s\n**Expires:** %s\n\nThis key is shown only once. Store it securely.",
+		tailnet, secret, key.ID, formatKeyCapabilities(key.Capabilities), key.Expires.UTC().Format(time.RFC3339))
+
+	if err := p.sendDirectMessage(recipientID, message); err != nil {
+		if deleteErr := client.DeleteKey(context.Background(), key.ID); deleteErr != nil {
+			p.API.LogError("Failed to delete undelivered auth key", "key_id", key.ID, "tailnet", tailnet, "error", deleteErr.Error())
+		} else {
+			p.API.LogInfo("Deleted undelivered auth key", "key_id", key.ID, "tailnet", tailnet)
+		}
+		return "", fmt.Errorf("failed to deliver auth key: %w", err)
+	}
+
+	return key.ID, nil
+}
+
+func (p *Plugin) handleKeysList(args *model.CommandArgs) error {
+	client, config, err := p.getTailscaleClient(args)
+	if err != nil || client == nil {
+		return err
+	}
+
+	keys, err := listTailnetKeys(context.Background(), client)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve keys from Tailscale API: %w", err)
+	}
+
+	if len(keys) == 0 {
+		p.postEphemeral(args.UserId, args.ChannelId, fmt.Sprintf("No keys found for tailnet %s.", config.Tailnet))
+		return nil
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].Created.After(keys[j].Created)
+	})
+
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("#### Keys of %s\n| ID | Type | Description | Capabilities | Created | Expires |\n| --- | --- | --- | --- | --- | --- |\n", config.Tailnet))
+	for _, key := range keys {
+		keyType := key.KeyType
+		if keyType == "" {
+			keyType = "-"
+		}
+		if key.Invalid {
+			keyType += " (invalid)"
+		}
+
+		expires := "-"
+		if !key.Expires.IsZero() {
+			expires = key.Expires.UTC().Format(time.RFC3339)
+		}
+
+		b.WriteString(fmt.Sprintf("| `%s` | %s | %s | %s | %s | %s |\n",
+			key.ID, keyType, joinCell([]string{key.Description}), formatKeyCapabilities(key.Capabilities),
+			key.Created.UTC().Format(time.RFC3339), expires))
+	}
+
+	return p.postEphemeralOrFile(args.UserId, args.ChannelId, b.String(), "keys.md", []byte(b.String()), "")
+}
+
+// handleKeysRevoke asks the user to confirm revoking a key.
+func (p *Plugin) handleKeysRevoke(args *model.CommandArgs, keyID string) error {
+	client, config, err := p.getTailscaleClient(args)
+	if err != nil || client == nil {
+		return err
+	}
+
+	key, err := client.Key(context.Background(), keyID)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve key %s: %w", keyID, err)
+	}
+
+	revocation := &pendingKeyRevocation{
+		ID:      model.NewId(),
+		UserID:  args.UserId,
+		Tailnet: config.Tailnet,
+		KeyID:   key.ID,
+	}
+	data, err := json.Marshal(revocation)
+	if err != nil {
+		return fmt.Errorf("failed to marshal key revocation: %w", err)
+	}
+	if appErr := p.API.KVSetWithExpiry(pendingKeyRevocationKey(revocation.ID), data, pendingKeyRevocationTTL); appErr != nil {
+		return fmt.Errorf("failed to store key revocation: %w", appErr)
+	}
+
+	message := fmt.Sprintf("#### Revoke key `%s` of %s?\n**Capabilities:** %s\n**Created:** %s\n\nDevices already authenticated with the key stay connected. Revoking cannot be undone.",
+		key.ID, config.Tailnet, formatKeyCapabilities(key.Capabilities), key.Created.UTC().Format(time.RFC3339))
+
+	post := &model.Post{
+		ChannelId: args.ChannelId,
+		UserId:    p.botID,
+		Message:   message,
+	}
+	model.ParseSlackAttachment(post, []*model.SlackAttachment{{
+		Actions: []*model.PostAction{
+			keyRevocationAction("Revoke", "revoke", "danger", revocation.ID),
+			keyRevocationAction("Cancel", "cancel", "default", revocation.ID),
+		},
+	}})
+	p.client.Post.SendEphemeralPost(args.UserId, post)
+
+	return nil
+}
+
+func keyRevocationAction(name, action, style, revocationID string) *model.PostAction {
+	return &model.PostAction{
+		Type:  model.PostActionTypeButton,
+		Name:  name,
+		Style: style,
+		Integration: &model.PostActionIntegration{
+			URL: actionURL("/api/v1/keys/revocations"),
+			Context: map[string]any{
+				"revocation_id": revocationID,
+				"action":        action,
+			},
+		},
+	}
+}
+
+// handleKeyRevocationAction revokes a key once the user who asked to revoke it confirmed it.
+func (p *Plugin) handleKeyRevocationAction(userID string, request *model.PostActionIntegrationRequest) (string, error) {
+	var revocation pendingKeyRevocation
+	found, err := p.takePendingChange(pendingKeyRevocationKey(contextString(request, "revocation_id")), userID, &revocation)
+	if err != nil {
+		return "", err
+	}
+
+	switch {
+	case !found:
+		return "This confirmation has expired. Run the command again to revoke the key.", nil
+	case contextString(request, "action") == "revoke":
+		return p.revokeKey(&revocation), nil
+	default:
+		return fmt.Sprintf("Cancelled revoking key `%s`.", revocation.KeyID), nil
+	}
+}
+
+// revokeKey deletes a key with the credentials of the user who confirmed revoking it and returns
+// a message describing the outcome.
+func (p *Plugin) revokeKey(revocation *pendingKeyRevocation) string {
+	config, err := p.getUserTailscaleConfig(revocation.UserID)
+	if err != nil {
+		p.API.LogError("Failed to retrieve Tailscale configuration", "user_id", revocation.UserID, "error", err.Error())
+		return "Failed to retrieve your Tailscale configuration."
+	}
+	if config == nil || config.Tailnet != revocation.Tailnet {
+		return fmt.Sprintf("You are no longer connected to tailnet %s.", revocation.Tailnet)
+	}
+
+	client := p.newTailscaleClient(config.Tailnet, config.APIKey)
+	if err := client.DeleteKey(context.Background(), revocation.KeyID); err != nil {
+		return fmt.Sprintf("Failed to revoke key `%s`: %s", revocation.KeyID, err.Error())
+	}
+
+	p.API.LogInfo("Revoked key", "key_id", revocation.KeyID, "tailnet", config.Tailnet, "user_id", revocation.UserID)
+
+	return fmt.Sprintf("Revoked key `%s` of tailnet %s.", revocation.KeyID, config.Tailnet)
+}
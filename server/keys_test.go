@@ -0,0 +1,138 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"tailscale.com/client/tailscale"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+func TestParseAuthKeyOptions(t *testing.T) {
+	for name, tc := range map[string]struct {
+		args     []string
+		expected *authKeyOptions
+		err      string
+	}{
+		"no options": {
+			expected: &authKeyOptions{},
+		},
+		"flags": {
+			args:     []string{"--reusable", "--ephemeral", "--preauthorized"},
+			expected: &authKeyOptions{Reusable: true, Ephemeral: true, Preauthorized: true},
+		},
+		"values": {
+			args:     []string{"--tags", "tag:ci, tag:prod", "--expiry", "7d", "--description", "build runners"},
+			expected: &authKeyOptions{Tags: []string{"tag:ci", "tag:prod"}, Expiry: 7 * 24 * time.Hour, Description: "build runners"},
+		},
+		"longest expiry": {
+			args:     []string{"--expiry", "90d"},
+			expected: &authKeyOptions{Expiry: maxAuthKeyExpiry},
+		},
+		"invalid tag": {
+			args: []string{"--tags", "tag:ci,prod"},
+			err:  `invalid tag "prod", tags start with tag:`,
+		},
+		"expiry too long": {
+			args: []string{"--expiry", "91d"},
+			err:  "the expiry must be between 1s and 90d",
+		},
+		"overflowing expiry": {
+			args: []string{"--expiry", "106752d"},
+			err:  `invalid duration "106752d"`,
+		},
+		"invalid expiry": {
+			args: []string{"--expiry", "soon"},
+			err:  `invalid duration "soon"`,
+		},
+		"description too long": {
+			args: []string{"--description", strings.Repeat("a", maxKeyDescriptionLength+1)},
+			err:  "the description must not be longer than 50 characters",
+		},
+		"missing value": {
+			args: []string{"--reusable", "--tags"},
+			err:  "missing value for --tags",
+		},
+		"unknown option": {
+			args: []string{"--forever"},
+			err:  `unknown option "--forever"`,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			options, err := parseAuthKeyOptions(tc.args)
+			if tc.err != "" {
+				assert.EqualError(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, options)
+		})
+	}
+}
+
+func TestKeyRevocation(t *testing.T) {
+	setup := func(t *testing.T) (*Plugin, *fakeAPI, *fakeTailscale, *model.PostActionIntegrationRequest) {
+		p, api, ts := newTestPlugin(t)
+		ts.addTailnet("key", &fakeTailnet{ID: "1001", Keys: map[string]*tailscale.Key{
+			"k123": {ID: "k123", Created: time.Now()},
+		}})
+		connectUser(t, p, "alice", "example.com", "key")
+		connectUser(t, p, "bob", "example.com", "key")
+
+		require.NoError(t, p.handleKeysRevoke(&model.CommandArgs{UserId: "alice", ChannelId: "channel"}, "k123"))
+		return p, api, ts, actionRequest(t, api.lastEphemeralPost(t, "alice"), "Revoke")
+	}
+
+	hasKey := func(ts *fakeTailscale) bool {
+		found := false
+		ts.tailnet("key", func(tailnet *fakeTailnet) {
+			_, found = tailnet.Keys["k123"]
+		})
+		return found
+	}
+
+	t.Run("revoke", func(t *testing.T) {
+		p, _, ts, request := setup(t)
+
+		message, err := p.handleKeyRevocationAction("alice", request)
+		require.NoError(t, err)
+		assert.Equal(t, "Revoked key `k123` of tailnet example.com.", message)
+		assert.False(t, hasKey(ts))
+
+		message, err = p.handleKeyRevocationAction("alice", request)
+		require.NoError(t, err)
+		assert.Contains(t, message, "has expired")
+	})
+
+	t.Run("cancel", func(t *testing.T) {
+		p, api, ts, _ := setup(t)
+
+		message, err := p.handleKeyRevocationAction("alice", actionRequest(t, api.lastEphemeralPost(t, "alice"), "Cancel"))
+		require.NoError(t, err)
+		assert.Equal(t, "Cancelled revoking key `k123`.", message)
+		assert.True(t, hasKey(ts))
+	})
+
+	t.Run("other user", func(t *testing.T) {
+		p, _, ts, request := setup(t)
+
+		_, err := p.handleKeyRevocationAction("bob", request)
+		assert.EqualError(t, err, "only the user who proposed this change can confirm or cancel it")
+		assert.True(t, hasKey(ts))
+	})
+
+	t.Run("forged request", func(t *testing.T) {
+		p, _, ts, _ := setup(t)
+
+		message, err := p.handleKeyRevocationAction("bob", &model.PostActionIntegrationRequest{
+			Context: map[string]any{"revocation_id": "k123", "key_id": "k123", "action": "revoke"},
+		})
+		require.NoError(t, err)
+		assert.Contains(t, message, "has expired")
+		assert.True(t, hasKey(ts))
+	})
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// kvListPageSize is the number of keys fetched per page when listing the KV store.
+const kvListPageSize = 200
+
+// listKeysWithPrefix returns all keys in the KV store that start with prefix.
+func (p *Plugin) listKeysWithPrefix(prefix string) ([]string, error) {
+	var result []string
+	for page := 0; ; page++ {
+		keys, appErr := p.API.KVList(page, kvListPageSize)
+		if appErr != nil {
+			return nil, fmt.Errorf("failed to list keys: %w", appErr)
+		}
+
+		for _, key := range keys {
+			if strings.HasPrefix(key, prefix) {
+				result = append(result, key)
+			}
+		}
+
+		if len(keys) < kvListPageSize {
+			return result, nil
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin"
+)
+
+const (
+	offboardingDisabled      = "disabled"
+	offboardingSuspend       = "suspend"
+	offboardingDeleteDevices = "delete_devices"
+)
+
+// UserHasBeenDeactivated offboards the tailnet user of a deactivated Mattermost user.
+func (p *Plugin) UserHasBeenDeactivated(_ *plugin.Context, user *model.User) {
+	action := p.getConfiguration().OffboardingAction
+	if action == "" || action == offboardingDisabled {
+		return
+	}
+
+	report := p.offboardUser(user, action)
+	p.API.LogInfo("Offboarded deactivated user", "user_id", user.Id, "action", action, "report", strings.Join(report, "; "))
+
+	setting := strings.TrimSpace(p.getConfiguration().OffboardingChannel)
+	if setting == "" {
+		return
+	}
+
+	channel, err := p.resolveChannel(setting)
+	if err != nil {
+		p.API.LogError("Failed to get offboarding channel", "error", err.Error())
+		return
+	}
+
+	post := &model.Post{
+		ChannelId: channel.Id,
+		UserId:    p.botID,
+		Message:   fmt.Sprintf("#### Offboarding of @%s\n- %s", user.Username, strings.Join(report, "\n- ")),
+	}
+	if err := p.client.Post.CreatePost(post); err != nil {
+		p.API.LogError("Failed to post offboarding report", "user_id", user.Id, "error", err.Error())
+	}
+}
+
+// offboardUser suspends the tailnet user matching the email of the deactivated user or deletes
+// their devices, and removes their stored Tailscale credentials. It returns a line per step
+// describing its outcome.
+func (p *Plugin) offboardUser(user *model.User, action string) []string {
+	var report []string
+
+	if err := p.offboardTailnetUser(user, action, &report); err != nil {
+		p.API.LogError("Failed to offboard tailnet user", "user_id", user.Id, "action", action, "error", err.Error())
+		report = append(report, fmt.Sprintf("Failed to offboard the tailnet user: %s", err.Error()))
+	}
+
+	config, err := p.getUserTailscaleConfig(user.Id)
+	switch {
+	case err != nil:
+		p.API.LogError("Failed to retrieve Tailscale configuration", "user_id", user.Id, "error", err.Error())
+		report = append(report, "Failed to check the stored Tailscale credentials.")
+	case config == nil:
+		report = append(report, "No Tailscale credentials were stored.")
+	default:
+		if appErr := p.API.KVDelete("tailscale_" + user.Id); appErr != nil {
+			p.API.LogError("Failed to delete Tailscale configuration", "user_id", user.Id, "error", appErr.Error())
+			report = append(report, fmt.Sprintf("Failed to remove the stored credentials for tailnet %s.", config.Tailnet))
+		} else {
+			report = append(report, fmt.Sprintf("Removed the stored credentials for tailnet %s.", config.Tailnet))
+		}
+	}
+
+	return report
+}
+
+// offboardTailnetUser finds the tailnet user by the email of the deactivated user. Users can set
+// an arbitrary email in Mattermost, so unverified emails are not trusted to identify anyone.
+func (p *Plugin) offboardTailnetUser(user *model.User, action string, report *[]string) error {
+	client, tailnet, err := p.adminClient()
+	if err != nil {
+		return err
+	}
+
+	if !user.EmailVerified {
+		*report = append(*report, fmt.Sprintf("Skipped tailnet %s, as the email %s is not verified. Check the tailnet for an account of @%s by hand.", tailnet, user.Email, user.Username))
+		return nil
+	}
+
+	ctx := context.Background()
+	switch action {
+	case offboardingSuspend:
+		users, err := listTailnetUsers(ctx, client)
+		if err != nil {
+			return fmt.Errorf("failed to retrieve users from Tailscale API: %w", err)
+		}
+
+		tailnetUser := findTailnetUser(users, user.Email)
+		if tailnetUser == nil {
+			*report = append(*report, fmt.Sprintf("No user with the email %s was found in tailnet %s.", user.Email, tailnet))
+			return nil
+		}
+		if tailnetUser.Status == "suspended" {
+			*report = append(*report, fmt.Sprintf("%s was already suspended in tailnet %s.", tailnetUser.LoginName, tailnet))
+			return nil
+		}
+
+		if err := updateTailnetUser(ctx, client, tailnetUser.ID, "suspend", nil); err != nil {
+			return fmt.Errorf("failed to suspend %s: %w", tailnetUser.LoginName, err)
+		}
+		*report = append(*report, fmt.Sprintf("Suspended %s in tailnet %s.", tailnetUser.LoginName, tailnet))
+	case offboardingDeleteDevices:
+		devices, err := client.Devices(ctx, nil)
+		if err != nil {
+			return fmt.Errorf("failed to retrieve devices from Tailscale API: %w", err)
+		}
+
+		var deleted, failed []string
+		for _, device := range devices {
+			if !strings.EqualFold(device.User, user.Email) {
+				continue
+			}
+			if err := client.DeleteDevice(ctx, device.DeviceID); err != nil {
+				p.API.LogWarn("Failed to delete device", "device_id", device.DeviceID, "tailnet", tailnet, "error", err.Error())
+				failed = append(failed, device.Hostname)
+				continue
+			}
+			deleted = append(deleted, device.Hostname)
+		}
+
+		switch {
+		case len(deleted) == 0 && len(failed) == 0:
+			*report = append(*report, fmt.Sprintf("No devices of %s were found in tailnet %s.", user.Email, tailnet))
+		case len(deleted) > 0:
+			*report = append(*report, fmt.Sprintf("Deleted %d device(s) of %s in tailnet %s: %s", len(deleted), user.Email, tailnet, strings.Join(deleted, ", ")))
+		}
+		if len(failed) > 0 {
+			*report = append(*report, fmt.Sprintf("Failed to delete %d device(s) in tailnet %s: %s", len(failed), tailnet, strings.Join(failed, ", ")))
+		}
+	default:
+		return fmt.Errorf("unknown offboarding action %q", action)
+	}
+
+	return nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+func TestOffboardUser(t *testing.T) {
+	for name, tc := range map[string]struct {
+		user     *model.User
+		action   string
+		expected []string
+		status   string
+	}{
+		"verified email": {
+			user:   &model.User{Id: "alice", Username: "alice", Email: "Alice@example.com", EmailVerified: true},
+			action: offboardingSuspend,
+			expected: []string{
+				"Suspended alice@example.com in tailnet example.com.",
+				"Removed the stored credentials for tailnet example.com.",
+			},
+			status: "suspended",
+		},
+		"unverified email": {
+			user:   &model.User{Id: "alice", Username: "alice", Email: "alice@example.com"},
+			action: offboardingSuspend,
+			expected: []string{
+				"Skipped tailnet example.com, as the email alice@example.com is not verified. Check the tailnet for an account of @alice by hand.",
+				"Removed the stored credentials for tailnet example.com.",
+			},
+			status: "active",
+		},
+		"unverified email with device deletion": {
+			user:   &model.User{Id: "alice", Username: "alice", Email: "alice@example.com"},
+			action: offboardingDeleteDevices,
+			expected: []string{
+				"Skipped tailnet example.com, as the email alice@example.com is not verified. Check the tailnet for an account of @alice by hand.",
+				"Removed the stored credentials for tailnet example.com.",
+			},
+			status: "active",
+		},
+		"no tailnet user": {
+			user:   &model.User{Id: "alice", Username: "alice", Email: "mallory@example.com", EmailVerified: true},
+			action: offboardingSuspend,
+			expected: []string{
+				"No user with the email mallory@example.com was found in tailnet example.com.",
+				"Removed the stored credentials for tailnet example.com.",
+			},
+			status: "active",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			p, _, ts := newTestPlugin(t)
+			p.setConfiguration(&configuration{AdminTailnet: "example.com", AdminAPIKey: "admin-key", OffboardingAction: tc.action})
+			ts.addTailnet("admin-key", &fakeTailnet{ID: "1001", Users: []*tailnetUser{
+				{ID: "u1", LoginName: "alice@example.com", Role: "member", Status: "active"},
+			}})
+			connectUser(t, p, "alice", "example.com", "user-key")
+
+			assert.Equal(t, tc.expected, p.offboardUser(tc.user, tc.action))
+			ts.tailnet("admin-key", func(tailnet *fakeTailnet) {
+				assert.Equal(t, tc.status, tailnet.Users[0].Status)
+			})
+		})
+	}
+}
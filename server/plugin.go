@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"net/http"
 	"net/url"
 	"strings"
 	"sync"
@@ -11,11 +12,11 @@ import (
 
 	"github.com/pkg/errors"
 	"tailscale.com/client/tailscale"
-	"tailscale.com/tsnet"
 
 	"github.com/mattermost/mattermost/server/public/model"
 	"github.com/mattermost/mattermost/server/public/plugin"
 	"github.com/mattermost/mattermost/server/public/pluginapi"
+	"github.com/mattermost/mattermost/server/public/pluginapi/cluster"
 	"github.com/mattermost/mattermost/server/public/pluginapi/experimental/command"
 )
 
@@ -36,11 +37,31 @@ type Plugin struct {
 	// client is the pluginapi client
 	client *pluginapi.Client
 
-	tsServer *tsnet.Server
+	// router serves the plugin's HTTP endpoints
+	router *http.ServeMux
+
+	// policyWatchJob periodically checks watched tailnets for policy changes
+	policyWatchJob *cluster.Job
+
+	// accessGrantJob periodically revokes expired access grants
+	accessGrantJob *cluster.Job
+
+	// serve runs the Tailscale reverse proxy
+	serve *serveManager
+
+	// tailscaleAPIBase overrides the Tailscale API server, for tests
+	tailscaleAPIBase string
 }
 
 func (p *Plugin) OnActivate() error {
 	p.client = pluginapi.NewClient(p.API, p.Driver)
+	p.router = p.initRouter()
+	serve, err := newServeManager(p)
+	if err != nil {
+		return errors.Wrap(err, "failed to create serve manager")
+	}
+	p.serve = serve
+
 	bot := &model.Bot{
 		Username:    "tailscale",
 		DisplayName: "Tailscale",
@@ -72,8 +93,43 @@ func (p *Plugin) OnActivate() error {
 
 	tailscale.I_Acknowledge_This_API_Is_Unstable = true
 
+	job, err := p.schedulePolicyWatcher()
+	if err != nil {
+		return errors.Wrap(err, "failed to schedule policy watcher")
+	}
+	p.policyWatchJob = job
+
+	job, err = p.scheduleAccessGrantExpiry()
+	if err != nil {
+		return errors.Wrap(err, "failed to schedule access grant expiry")
+	}
+	p.accessGrantJob = job
+
 	if p.getConfiguration().Serve {
-		p.startTSSever()
+		// Bringing up the Tailscale node can take a while, so activation does not wait for it.
+		go p.reconcileServe(false)
+	}
+
+	return nil
+}
+
+func (p *Plugin) OnDeactivate() error {
+	if p.policyWatchJob != nil {
+		if err := p.policyWatchJob.Close(); err != nil {
+			p.API.LogError("Failed to close policy watcher job", "error", err.Error())
+		}
+	}
+	if p.accessGrantJob != nil {
+		if err := p.accessGrantJob.Close(); err != nil {
+			p.API.LogError("Failed to close access grant expiry job", "error", err.Error())
+		}
+	}
+	if p.serve != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), serveShutdownTimeout)
+		defer cancel()
+		if err := p.serve.Stop(ctx); err != nil && !errors.Is(err, errServeNotRunning) {
+			p.API.LogError("Failed to stop Tailscale serve", "error", err.Error())
+		}
 	}
 
 	return nil
@@ -91,9 +147,77 @@ func getAutocompleteData() *model.AutocompleteData {
 	list := model.NewAutocompleteData("list", "", "List all devices in your Tailnet")
 	tailscale.AddCommand(list)
 
-	acl := model.NewAutocompleteData("acl", "", "Show the ACL configuration for your Tailnet")
+	acl := model.NewAutocompleteData("acl", "[section]", "Show the ACL configuration for your Tailnet")
+	acl.AddCommand(model.NewAutocompleteData("groups", "", "Show the groups defined in the policy"))
+	acl.AddCommand(model.NewAutocompleteData("tagowners", "", "Show the tag owners defined in the policy"))
+	acl.AddCommand(model.NewAutocompleteData("hosts", "", "Show the hosts defined in the policy"))
+	acl.AddCommand(model.NewAutocompleteData("ssh", "", "Show the SSH rules defined in the policy"))
+	acl.AddCommand(model.NewAutocompleteData("autoapprovers", "", "Show the auto approvers defined in the policy"))
+	acl.AddCommand(model.NewAutocompleteData("tests", "", "Show the tests defined in the policy"))
+	acl.AddCommand(model.NewAutocompleteData("apply", "", "Validate and apply the policy file attached to the preceding post or thread"))
+	acl.AddCommand(model.NewAutocompleteData("watch", "", "Post policy changes of your Tailnet to this channel"))
+	acl.AddCommand(model.NewAutocompleteData("unwatch", "", "Stop posting policy changes of your Tailnet to this channel"))
+	acl.AddCommand(model.NewAutocompleteData("history", "", "List the recorded versions of the policy"))
+	acl.AddCommand(model.NewAutocompleteData("show", "<version>", "Show a recorded version of the policy"))
+	acl.AddCommand(model.NewAutocompleteData("rollback", "<version>", "Restore a recorded version of the policy"))
+	acl.AddCommand(model.NewAutocompleteData("lint", "", "Check the policy for risky or unused rules"))
+	acl.AddCommand(model.NewAutocompleteData("can", "<user|tag|device|ip> reach <host:port>", "Check whether a user, tag, device or address can reach a destination"))
+	acl.AddCommand(model.NewAutocompleteData("access", "<user>", "Show the rules that apply to a user and the destinations the user can reach"))
+	acl.AddCommand(model.NewAutocompleteData("sync", "<group:name>", "Link this channel to a policy group, adding and removing members as they join and leave the channel"))
+	acl.AddCommand(model.NewAutocompleteData("unsync", "", "Unlink this channel from its policy group"))
+	acl.AddCommand(model.NewAutocompleteData("test", "[attached]", "Run the tests of the current policy, or of the policy file attached to the preceding post or thread"))
 	tailscale.AddCommand(acl)
 
+	access := model.NewAutocompleteData("access", "[command]", "Request temporary access to a tag")
+	access.AddCommand(model.NewAutocompleteData("request", "<tag> --for <duration> --reason <reason>", "Request temporary access to a tag, e.g. tag:prod --for 2h --reason \"Incident 42\""))
+	access.AddCommand(model.NewAutocompleteData("active", "", "List the active access grants of your Tailnet"))
+	tailscale.AddCommand(access)
+
+	keys := model.NewAutocompleteData("keys", "[command]", "Manage the auth keys of your Tailnet")
+	keys.AddCommand(model.NewAutocompleteData("create", "[--reusable] [--ephemeral] [--preauthorized] [--tags tag:a,tag:b] [--expiry 1d] [--description <text>]", "Create an auth key and receive it as a direct message"))
+	keys.AddCommand(model.NewAutocompleteData("list", "", "List the auth keys and API keys of your Tailnet"))
+	keys.AddCommand(model.NewAutocompleteData("revoke", "<id>", "Revoke a key"))
+	keys.AddCommand(model.NewAutocompleteData("request", "--reason <reason> [--tags tag:a,tag:b] [--reusable] [--ephemeral] [--preauthorized] [--expiry 1d]", "Request an auth key from the approvers"))
+	tailscale.AddCommand(keys)
+
+	dns := model.NewAutocompleteData("dns", "[command]", "Manage the DNS configuration of your Tailnet")
+	dns.AddCommand(model.NewAutocompleteData("show", "", "Show MagicDNS, nameservers, search paths and split DNS"))
+	dnsNameservers := model.NewAutocompleteData("nameservers", "[command]", "Change the nameservers")
+	dnsNameservers.AddCommand(model.NewAutocompleteData("set", "[nameserver...]", "Replace the nameservers"))
+	dnsNameservers.AddCommand(model.NewAutocompleteData("add", "<nameserver...>", "Add nameservers"))
+	dnsNameservers.AddCommand(model.NewAutocompleteData("remove", "<nameserver...>", "Remove nameservers"))
+	dns.AddCommand(dnsNameservers)
+	dnsSearchPaths := model.NewAutocompleteData("searchpaths", "[command]", "Change the search paths")
+	dnsSearchPaths.AddCommand(model.NewAutocompleteData("set", "[domain...]", "Replace the search paths"))
+	dnsSearchPaths.AddCommand(model.NewAutocompleteData("add", "<domain...>", "Add search paths"))
+	dnsSearchPaths.AddCommand(model.NewAutocompleteData("remove", "<domain...>", "Remove search paths"))
+	dns.AddCommand(dnsSearchPaths)
+	dns.AddCommand(model.NewAutocompleteData("split", "<domain> <nameserver...|clear>", "Set or remove the nameservers of a split DNS domain"))
+	tailscale.AddCommand(dns)
+
+	users := model.NewAutocompleteData("users", "[command]", "List and manage the users of your Tailnet")
+	users.AddCommand(model.NewAutocompleteData("approve", "<user>", "Approve a user"))
+	users.AddCommand(model.NewAutocompleteData("suspend", "<user>", "Suspend a user"))
+	users.AddCommand(model.NewAutocompleteData("restore", "<user>", "Restore a suspended user"))
+	users.AddCommand(model.NewAutocompleteData("role", "<user> <role>", "Change the role of a user"))
+	tailscale.AddCommand(users)
+
+	invite := model.NewAutocompleteData("invite", "@user [--role member]", "Invite a user to your Tailnet and send them the invite link")
+	tailscale.AddCommand(invite)
+
+	invites := model.NewAutocompleteData("invites", "", "List the invites sent to your Tailnet and their status")
+	tailscale.AddCommand(invites)
+
+	settings := model.NewAutocompleteData("settings", "[command]", "View and change the settings of your Tailnet")
+	settings.AddCommand(model.NewAutocompleteData("show", "", "Show device approval, user approval, key expiry, posture identity collection and network flow logging"))
+	settings.AddCommand(model.NewAutocompleteData("set", "<name> <value>", "Change a setting (System Admins only)"))
+	tailscale.AddCommand(settings)
+
+	webhook := model.NewAutocompleteData("webhook", "[command]", "Post events of your Tailnet to this channel")
+	webhook.AddCommand(model.NewAutocompleteData("subscribe", "<event...|all>", "Post the given events to this channel, e.g. nodeCreated nodeNeedsApproval"))
+	webhook.AddCommand(model.NewAutocompleteData("unsubscribe", "[event...]", "Stop posting the given events, or all events, to this channel"))
+	tailscale.AddCommand(webhook)
+
 	tailnet := model.NewAutocompleteData("tailnet", "", "Show your current Tailnet name")
 	tailscale.AddCommand(tailnet)
 
@@ -127,15 +251,204 @@ func (p *Plugin) executeCommand(_ *plugin.Context, args *model.CommandArgs) {
 
 	switch cmd {
 	case "connect":
-		if len(split) != 4 {
+		defaultTailnet := strings.TrimSpace(p.getConfiguration().DefaultTailnet)
+		switch {
+		case len(split) == 4:
+			err = p.handleConnect(args, split[2], split[3])
+		case len(split) == 3 && defaultTailnet != "":
+			err = p.handleConnect(args, defaultTailnet, split[2])
+		default:
 			p.postEphemeral(args.UserId, args.ChannelId, "Usage: /tailscale connect <tailnet> <api-key>")
 			return
 		}
-		err = p.handleConnect(args, split[2], split[3])
 	case "list":
 		err = p.handleList(args)
 	case "acl":
-		err = p.handleACL(args)
+		if len(split) < 3 {
+			err = p.handleACL(args)
+			break
+		}
+		switch split[2] {
+		case "groups", "tagowners", "hosts", "ssh", "autoapprovers", "tests":
+			err = p.handleACLSection(args, split[2])
+		case "apply":
+			err = p.handleACLApply(args)
+		case "watch":
+			err = p.handleACLWatch(args)
+		case "unwatch":
+			err = p.handleACLUnwatch(args)
+		case "history":
+			err = p.handleACLHistory(args)
+		case "lint":
+			err = p.handleACLLint(args)
+		case "can":
+			if len(split) != 6 || split[4] != "reach" {
+				p.postEphemeral(args.UserId, args.ChannelId, "Usage: /tailscale acl can <user|tag|device|ip> reach <host:port>")
+				return
+			}
+			err = p.handleACLCan(args, split[3], split[5])
+		case "access":
+			if len(split) != 4 {
+				p.postEphemeral(args.UserId, args.ChannelId, "Usage: /tailscale acl access <user>")
+				return
+			}
+			err = p.handleACLAccess(args, split[3])
+		case "test":
+			if len(split) > 4 || (len(split) == 4 && split[3] != "attached") {
+				p.postEphemeral(args.UserId, args.ChannelId, "Usage: /tailscale acl test [attached]")
+				return
+			}
+			err = p.handleACLTest(args, len(split) == 4)
+		case "sync":
+			if len(split) != 4 {
+				p.postEphemeral(args.UserId, args.ChannelId, "Usage: /tailscale acl sync <group:name>")
+				return
+			}
+			err = p.handleACLSync(args, split[3])
+		case "unsync":
+			err = p.handleACLUnsync(args)
+		case "show", "rollback":
+			if len(split) != 4 {
+				p.postEphemeral(args.UserId, args.ChannelId, fmt.Sprintf("Usage: /tailscale acl %s <version>", split[2]))
+				return
+			}
+			if split[2] == "show" {
+				err = p.handleACLShow(args, split[3])
+			} else {
+				err = p.handleACLRollback(args, split[3])
+			}
+		default:
+			p.postEphemeral(args.UserId, args.ChannelId, "Available acl commands: groups, tagowners, hosts, ssh, autoapprovers, tests, apply, watch, unwatch, history, show, rollback, lint, can, access, test, sync, unsync")
+			return
+		}
+	case "access":
+		if len(split) < 3 {
+			p.postEphemeral(args.UserId, args.ChannelId, "Available access commands: request, active")
+			return
+		}
+		switch split[2] {
+		case "request":
+			err = p.handleAccessRequest(args, splitCommandLine(args.Command)[3:])
+		case "active":
+			err = p.handleAccessActive(args)
+		default:
+			p.postEphemeral(args.UserId, args.ChannelId, "Available access commands: request, active")
+			return
+		}
+	case "keys":
+		if len(split) < 3 {
+			p.postEphemeral(args.UserId, args.ChannelId, "Available keys commands: create, list, revoke, request")
+			return
+		}
+		switch split[2] {
+		case "create":
+			err = p.handleKeysCreate(args, splitCommandLine(args.Command)[3:])
+		case "list":
+			err = p.handleKeysList(args)
+		case "revoke":
+			if len(split) != 4 {
+				p.postEphemeral(args.UserId, args.ChannelId, "Usage: /tailscale keys revoke <id>")
+				return
+			}
+			err = p.handleKeysRevoke(args, split[3])
+		case "request":
+			err = p.handleKeysRequest(args, splitCommandLine(args.Command)[3:])
+		default:
+			p.postEphemeral(args.UserId, args.ChannelId, "Available keys commands: create, list, revoke, request")
+			return
+		}
+	case "dns":
+		if len(split) < 3 {
+			p.postEphemeral(args.UserId, args.ChannelId, "Available dns commands: show, nameservers, searchpaths, split")
+			return
+		}
+		switch split[2] {
+		case "show":
+			err = p.handleDNSShow(args)
+		case "nameservers", "searchpaths":
+			if len(split) < 4 || (split[3] != "set" && len(split) < 5) {
+				p.postEphemeral(args.UserId, args.ChannelId, fmt.Sprintf("Usage: /tailscale dns %s set|add|remove <value...>", split[2]))
+				return
+			}
+			switch split[3] {
+			case "set", "add", "remove":
+				err = p.handleDNSList(args, split[2], split[3], split[4:])
+			default:
+				p.postEphemeral(args.UserId, args.ChannelId, fmt.Sprintf("Usage: /tailscale dns %s set|add|remove <value...>", split[2]))
+				return
+			}
+		case "split":
+			if len(split) < 5 {
+				p.postEphemeral(args.UserId, args.ChannelId, "Usage: /tailscale dns split <domain> <nameserver...|clear>")
+				return
+			}
+			nameservers := split[4:]
+			if len(nameservers) == 1 && nameservers[0] == "clear" {
+				nameservers = nil
+			}
+			err = p.handleDNSSplit(args, split[3], nameservers)
+		default:
+			p.postEphemeral(args.UserId, args.ChannelId, "Available dns commands: show, nameservers, searchpaths, split")
+			return
+		}
+	case "users":
+		if len(split) < 3 {
+			err = p.handleUsers(args)
+			break
+		}
+		switch split[2] {
+		case "approve", "suspend", "restore":
+			if len(split) != 4 {
+				p.postEphemeral(args.UserId, args.ChannelId, fmt.Sprintf("Usage: /tailscale users %s <user>", split[2]))
+				return
+			}
+			err = p.handleUsersAction(args, split[2], split[3], "")
+		case "role":
+			if len(split) != 5 {
+				p.postEphemeral(args.UserId, args.ChannelId, "Usage: /tailscale users role <user> <role>")
+				return
+			}
+			err = p.handleUsersAction(args, "role", split[3], split[4])
+		default:
+			p.postEphemeral(args.UserId, args.ChannelId, "Available users commands: approve, suspend, restore, role")
+			return
+		}
+	case "invite":
+		err = p.handleInvite(args, splitCommandLine(args.Command)[2:])
+	case "invites":
+		err = p.handleInvites(args)
+	case "settings":
+		if len(split) < 3 {
+			p.postEphemeral(args.UserId, args.ChannelId, "Available settings commands: show, set <name> <value>")
+			return
+		}
+		switch split[2] {
+		case "show":
+			err = p.handleSettingsShow(args)
+		case "set":
+			if len(split) != 5 {
+				p.postEphemeral(args.UserId, args.ChannelId, fmt.Sprintf("Usage: /tailscale settings set <name> <value>\nAvailable settings: %s", tailnetSettingNames()))
+				return
+			}
+			err = p.handleSettingsSet(args, split[3], split[4])
+		default:
+			p.postEphemeral(args.UserId, args.ChannelId, "Available settings commands: show, set <name> <value>")
+			return
+		}
+	case "webhook":
+		if len(split) < 3 {
+			p.postEphemeral(args.UserId, args.ChannelId, "Available webhook commands: subscribe <event...|all>, unsubscribe [event...]")
+			return
+		}
+		switch split[2] {
+		case "subscribe":
+			err = p.handleWebhookSubscribe(args, split[3:])
+		case "unsubscribe":
+			err = p.handleWebhookUnsubscribe(args, split[3:])
+		default:
+			p.postEphemeral(args.UserId, args.ChannelId, "Available webhook commands: subscribe <event...|all>, unsubscribe [event...]")
+			return
+		}
 	case "tailnet":
 		err = p.handleTailnet(args)
 	case "disconnect":
@@ -161,7 +474,7 @@ func (p *Plugin) executeCommand(_ *plugin.Context, args *model.CommandArgs) {
 	case "about":
 		err = p.handleAbout(args)
 	default:
-		p.postEphemeral(args.UserId, args.ChannelId, "Available commands: connect <tailnet> <api-key>, disconnect, list, acl, tailnet, serve")
+		p.postEphemeral(args.UserId, args.ChannelId, "Available commands: connect <tailnet> <api-key>, disconnect, list, acl, access, keys, dns, users, invite, invites, settings, webhook, tailnet, serve")
 		return
 	}
 
@@ -172,7 +485,7 @@ func (p *Plugin) executeCommand(_ *plugin.Context, args *model.CommandArgs) {
 
 func (p *Plugin) handleConnect(args *model.CommandArgs, tailnet, apiKey string) error {
 	// Validate credentials by creating a client and making a test API call
-	client := tailscale.NewClient(tailnet, tailscale.APIKey(apiKey))
+	client := p.newTailscaleClient(tailnet, apiKey)
 
 	// Try to list devices as a basic API test
 	_, err := client.Devices(context.Background(), nil)
@@ -210,7 +523,7 @@ func (p *Plugin) handleList(args *model.CommandArgs) error {
 		return nil
 	}
 
-	client := tailscale.NewClient(config.Tailnet, tailscale.APIKey(config.APIKey))
+	client := p.newTailscaleClient(config.Tailnet, config.APIKey)
 	devices, err := client.Devices(context.Background(), nil)
 	if err != nil {
 		return fmt.Errorf("failed to retrieve devices from Tailscale API: %w", err)
@@ -292,31 +605,20 @@ func (p *Plugin) getUserTailscaleConfig(userID string) (*UserTailscaleConfig, er
 	return &config, nil
 }
 
-func (p *Plugin) handleACL(args *model.CommandArgs) error {
+// getTailscaleClient returns a Tailscale API client for the user's stored configuration. If the
+// user has not connected yet, it posts a hint and returns a nil client.
+func (p *Plugin) getTailscaleClient(args *model.CommandArgs) (*tailscale.Client, *UserTailscaleConfig, error) {
 	config, err := p.getUserTailscaleConfig(args.UserId)
 	if err != nil {
-		return fmt.Errorf("failed to retrieve Tailscale configuration: %w", err)
+		return nil, nil, fmt.Errorf("failed to retrieve Tailscale configuration: %w", err)
 	}
 
 	if config == nil {
 		p.postEphemeral(args.UserId, args.ChannelId, "Please authenticate first using: `/tailscale connect <tailnet> <api-key>`")
-		return nil
+		return nil, nil, nil
 	}
 
-	client := tailscale.NewClient(config.Tailnet, tailscale.APIKey(config.APIKey))
-	acl, err := client.ACL(context.Background())
-	if err != nil {
-		return fmt.Errorf("failed to retrieve ACL from Tailscale API: %w", err)
-	}
-
-	aclJSON, err := json.MarshalIndent(acl.ACL, "", "  ")
-	if err != nil {
-		return fmt.Errorf("failed to format ACL: %w", err)
-	}
-
-	message := fmt.Sprintf("#### Tailscale ACL\n```json\n%s\n```", string(aclJSON))
-	p.postEphemeral(args.UserId, args.ChannelId, message)
-	return nil
+	return p.newTailscaleClient(config.Tailnet, config.APIKey), config, nil
 }
 
 func (p *Plugin) handleDisconnect(args *model.CommandArgs) error {
@@ -388,7 +690,7 @@ func (p *Plugin) handleServeSetup(args *model.CommandArgs) error {
 	}
 
 	// Store the auth key in configuration
-	config := p.getConfiguration()
+	config := p.getConfiguration().Clone()
 	config.Serve = true
 	config.AuthKey = authKey
 	if err := p.SaveConfiguration(config); err != nil {
@@ -396,7 +698,7 @@ func (p *Plugin) handleServeSetup(args *model.CommandArgs) error {
 	}
 
 	message := "Successfully configured Tailscale serve!\n" +
-		"Use '/tailscale serve start' to start the reverse proxy."
+		"The reverse proxy is starting. System admins will receive a direct message once it is running."
 
 	p.postEphemeral(args.UserId, args.ChannelId, message)
 	return nil
@@ -413,9 +715,17 @@ func (p *Plugin) handleServeStatus(args *model.CommandArgs) error {
 		return errors.New("only system administrators can use the serve command")
 	}
 
-	if p.tsServer == nil {
+	state, serveErr := p.serve.Status()
+	switch state {
+	case serveStopped:
 		p.postEphemeral(args.UserId, args.ChannelId, "Tailscale serve is not running")
 		return nil
+	case serveFailed:
+		p.postEphemeral(args.UserId, args.ChannelId, fmt.Sprintf("Tailscale serve failed: %s", serveErr.Error()))
+		return nil
+	case serveStarting, serveStopping:
+		p.postEphemeral(args.UserId, args.ChannelId, fmt.Sprintf("Tailscale serve is %s", state))
+		return nil
 	}
 
 	dnsName, err := p.tsDNSName()
@@ -449,15 +759,23 @@ func (p *Plugin) handleServeStart(args *model.CommandArgs) error {
 	}
 
 	config := p.getConfiguration()
-	if !config.Serve {
+	if config.AuthKey == "" {
 		return errors.New("Tailscale serve is not configured. Use '/tailscale serve setup <auth-key>' first")
 	}
 
-	if p.tsServer != nil {
-		return errors.New("Tailscale serve is already running")
+	// Enabling serve in the configuration starts it once the configuration change is applied.
+	if !config.Serve {
+		config = config.Clone()
+		config.Serve = true
+		if err := p.SaveConfiguration(config); err != nil {
+			return fmt.Errorf("failed to save plugin configuration: %w", err)
+		}
+
+		p.postEphemeral(args.UserId, args.ChannelId, "Starting Tailscale serve. System admins will receive a direct message once it is running.")
+		return nil
 	}
 
-	err = p.startTSSever()
+	err = p.serve.Start(config.serveOptions())
 	if err != nil {
 		return fmt.Errorf("failed to start Tailscale serve: %w", err)
 	}
@@ -483,7 +801,6 @@ func (p *Plugin) handleServeStart(args *model.CommandArgs) error {
 	return nil
 }
 
-// TODO: This command crashes the plugin every time with no good error message. Check what is going wrong.
 func (p *Plugin) handleServeStop(args *model.CommandArgs) error {
 	// Check if user is system admin
 	user, err := p.client.User.Get(args.UserId)
@@ -495,24 +812,30 @@ func (p *Plugin) handleServeStop(args *model.CommandArgs) error {
 		return errors.New("only system administrators can use the serve command")
 	}
 
-	if p.tsServer == nil {
-		return errors.New("Tailscale serve is not running")
-	}
+	ctx, cancel := context.WithTimeout(context.Background(), serveShutdownTimeout)
+	defer cancel()
 
-	err = p.tsServer.Close()
-	if err != nil {
+	message := "Successfully stopped Tailscale serve"
+	err = p.serve.Stop(ctx)
+	switch {
+	case errors.Is(err, errServeNotRunning):
+		message = "Tailscale serve is not running"
+	case err != nil:
 		return fmt.Errorf("failed to stop Tailscale serve: %w", err)
 	}
-	p.tsServer = nil
 
-	// Store the auth key in configuration
-	config := p.getConfiguration()
-	config.Serve = false
-	if err := p.SaveConfiguration(config); err != nil {
-		return fmt.Errorf("failed to save plugin configuration: %w", err)
+	// Disabling serve keeps it from being started again on the next activation, even if it was
+	// not running.
+	if config := p.getConfiguration(); config.Serve {
+		config = config.Clone()
+		config.Serve = false
+		if err := p.SaveConfiguration(config); err != nil {
+			return fmt.Errorf("failed to save plugin configuration: %w", err)
+		}
+		message += ". It has been disabled in the plugin settings"
 	}
 
-	p.postEphemeral(args.UserId, args.ChannelId, "Successfully stopped Tailscale serve")
+	p.postEphemeral(args.UserId, args.ChannelId, message+".")
 	return nil
 }
 
@@ -520,17 +843,7 @@ func (p *Plugin) tsDNSName() (string, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	lc, err := p.tsServer.LocalClient()
-	if err != nil {
-		return "", fmt.Errorf("Failed get local ts client: %w", err)
-	}
-	status, err := lc.Status(ctx)
-	if err != nil {
-		return "", fmt.Errorf("Failed to get status: %w", err)
-	}
-	dnsName := status.Self.DNSName
-
-	return dnsName, nil
+	return p.serve.DNSName(ctx)
 }
 
 func (p *Plugin) checkSiteURL(dnsName string) (bool, error) {
@@ -542,6 +855,52 @@ func (p *Plugin) checkSiteURL(dnsName string) (bool, error) {
 	return siteURL.Host == dnsName, nil
 }
 
+// resolveChannel looks up a channel configured as team-name/channel-name or by its ID.
+func (p *Plugin) resolveChannel(setting string) (*model.Channel, error) {
+	if teamName, channelName, ok := strings.Cut(setting, "/"); ok {
+		return p.client.Channel.GetByNameForTeamName(teamName, channelName, false)
+	}
+
+	return p.client.Channel.Get(setting)
+}
+
+// adminClient returns a client using the admin credential configured in the plugin settings.
+func (p *Plugin) adminClient() (*tailscale.Client, string, error) {
+	config := p.getConfiguration()
+	if config.AdminTailnet == "" || config.AdminAPIKey == "" {
+		return nil, "", errors.New("the admin tailnet or API key is not configured. Ask a System Admin to configure them in the plugin settings")
+	}
+
+	return p.newTailscaleClient(config.AdminTailnet, config.AdminAPIKey), config.AdminTailnet, nil
+}
+
+// newTailscaleClient returns a Tailscale API client for the tailnet, authenticated with the API key.
+func (p *Plugin) newTailscaleClient(tailnet, apiKey string) *tailscale.Client {
+	client := tailscale.NewClient(tailnet, tailscale.APIKey(apiKey))
+	client.BaseURL = p.tailscaleAPIBase
+
+	return client
+}
+
+// sendDirectMessage posts a message into the direct message channel between the bot and the user.
+func (p *Plugin) sendDirectMessage(userID, message string) error {
+	channel, err := p.client.Channel.GetDirect(userID, p.botID)
+	if err != nil {
+		return fmt.Errorf("failed to get direct message channel: %w", err)
+	}
+
+	post := &model.Post{
+		ChannelId: channel.Id,
+		UserId:    p.botID,
+		Message:   message,
+	}
+	if err := p.client.Post.CreatePost(post); err != nil {
+		return fmt.Errorf("failed to create post: %w", err)
+	}
+
+	return nil
+}
+
 func (p *Plugin) postEphemeral(userID, channelID string, message string) {
 	ephemeralPost := &model.Post{
 		ChannelId: channelID,
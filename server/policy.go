@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pmezard/go-difflib/difflib"
+	"github.com/tailscale/hujson"
+	"tailscale.com/client/tailscale"
+)
+
+// policy is the parsed form of a tailnet policy file. Only the sections the plugin works with are
+// decoded; the HuJSON source stays the authoritative representation whenever a policy is shown or
+// modified.
+type policy struct {
+	ACLs          []tailscale.ACLRow  `json:"acls"`
+	Groups        map[string][]string `json:"groups"`
+	TagOwners     map[string][]string `json:"tagOwners"`
+	Hosts         map[string]string   `json:"hosts"`
+	SSH           []policySSHRule     `json:"ssh"`
+	AutoApprovers policyAutoApprovers `json:"autoApprovers"`
+	Tests         []tailscale.ACLTest `json:"tests"`
+}
+
+// policySSHRule is a single entry of the ssh section of a policy file.
+type policySSHRule struct {
+	Action      string   `json:"action"`
+	Src         []string `json:"src"`
+	Dst         []string `json:"dst"`
+	Users       []string `json:"users"`
+	CheckPeriod string   `json:"checkPeriod"`
+}
+
+// policyAutoApprovers is the autoApprovers section of a policy file.
+type policyAutoApprovers struct {
+	Routes   map[string][]string `json:"routes"`
+	ExitNode []string            `json:"exitNode"`
+}
+
+// parsePolicy decodes a HuJSON policy file.
+func parsePolicy(huJSON string) (*policy, error) {
+	data, err := hujson.Standardize([]byte(huJSON))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse policy: %w", err)
+	}
+
+	var pol policy
+	if err := json.Unmarshal(data, &pol); err != nil {
+		return nil, fmt.Errorf("failed to decode policy: %w", err)
+	}
+
+	return &pol, nil
+}
+
+// sortedKeys returns the keys of m in lexical order.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// maxDiffRunes limits the size of a diff rendered into a post, leaving room for the surrounding
+// message and attachments.
+const maxDiffRunes = 12000
+
+// policyDiff returns a unified diff between two HuJSON policy files. It returns an empty string
+// if both are equal.
+func policyDiff(from, to, fromName, toName string) (string, error) {
+	return difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(normalizePolicy(from)),
+		B:        difflib.SplitLines(normalizePolicy(to)),
+		FromFile: fromName,
+		ToFile:   toName,
+		Context:  3,
+	})
+}
+
+// normalizePolicy trims surrounding whitespace, so that differences in trailing newlines do not
+// show up as changes.
+func normalizePolicy(huJSON string) string {
+	return strings.TrimSpace(huJSON) + "\n"
+}
+
+// formatDiff renders a diff as a markdown code block, truncating it if it would not fit into a
+// post.
+func formatDiff(diff string) string {
+	truncated := false
+	if runes := []rune(diff); len(runes) > maxDiffRunes {
+		diff = string(runes[:maxDiffRunes])
+		if i := strings.LastIndex(diff, "\n"); i > 0 {
+			diff = diff[:i+1]
+		}
+		truncated = true
+	}
+
+	message := fmt.Sprintf("```diff\n%s```", diff)
+	if truncated {
+		message += "\n_The diff has been truncated._"
+	}
+
+	return message
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/tailscale/hujson"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+const (
+	lintSeverityHigh   = "high"
+	lintSeverityMedium = "medium"
+	lintSeverityLow    = "low"
+)
+
+// lintSeverityRank orders findings from most to least severe.
+var lintSeverityRank = map[string]int{
+	lintSeverityHigh:   0,
+	lintSeverityMedium: 1,
+	lintSeverityLow:    2,
+}
+
+// lintFinding is a single issue found in a policy file.
+type lintFinding struct {
+	Severity string
+	Message  string
+}
+
+// lintPolicy checks a HuJSON policy file for risky or dead rules. The findings are ordered by
+// severity.
+func lintPolicy(huJSON string) ([]lintFinding, error) {
+	pol, err := parsePolicy(huJSON)
+	if err != nil {
+		return nil, err
+	}
+
+	// References are collected from the generic representation of the policy, so that sections
+	// not modelled by policy, like grants or nodeAttrs, are taken into account too.
+	data, err := hujson.Standardize([]byte(huJSON))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse policy: %w", err)
+	}
+	var raw map[string]any
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("failed to decode policy: %w", err)
+	}
+
+	var findings []lintFinding
+	add := func(severity, format string, args ...any) {
+		findings = append(findings, lintFinding{Severity: severity, Message: fmt.Sprintf(format, args...)})
+	}
+
+	for i, rule := range pol.ACLs {
+		if rule.Action != "" && rule.Action != "accept" {
+			continue
+		}
+
+		src := append(append([]string{}, rule.Src...), rule.Users...)
+		dst := append(append([]string{}, rule.Dst...), rule.Ports...)
+		if !slices.Contains(dst, "*:*") {
+			continue
+		}
+
+		if slices.Contains(src, "*") {
+			add(lintSeverityHigh, "ACL rule %d allows any source to reach any destination on any port (`*` → `*:*`)", i+1)
+		} else {
+			add(lintSeverityMedium, "ACL rule %d allows %s to reach any destination on any port (`*:*`)", i+1, formatNames(src))
+		}
+	}
+
+	for i, rule := range pol.SSH {
+		if !slices.Contains(rule.Users, "root") {
+			continue
+		}
+
+		if rule.Action == "check" {
+			add(lintSeverityMedium, "SSH rule %d allows %s to log in as `root` after re-authentication", i+1, formatNames(rule.Src))
+		} else {
+			add(lintSeverityHigh, "SSH rule %d allows %s to log in as `root`", i+1, formatNames(rule.Src))
+		}
+	}
+
+	for _, route := range sortedKeys(pol.AutoApprovers.Routes) {
+		for _, approver := range pol.AutoApprovers.Routes[route] {
+			if isTag(approver) && !hasTagOwner(pol, approver) {
+				add(lintSeverityHigh, "autoApprovers for route `%s` reference `%s`, which has no tagOwners entry", route, approver)
+			}
+		}
+	}
+	for _, approver := range pol.AutoApprovers.ExitNode {
+		if isTag(approver) && !hasTagOwner(pol, approver) {
+			add(lintSeverityHigh, "autoApprovers for exit nodes reference `%s`, which has no tagOwners entry", approver)
+		}
+	}
+
+	var tags []string
+	for _, s := range collectPolicyStrings(raw, "") {
+		if tag := tagName(s); tag != "" && !slices.Contains(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+	sort.Strings(tags)
+	for _, tag := range tags {
+		if !hasTagOwner(pol, tag) && !slices.Contains(pol.AutoApprovers.ExitNode, tag) && !isAutoApproverTag(pol, tag) {
+			add(lintSeverityMedium, "`%s` is used but has no tagOwners entry, so no device can be tagged with it", tag)
+		}
+	}
+
+	groupReferences := collectPolicyStrings(raw, "groups")
+	for _, group := range sortedKeys(pol.Groups) {
+		if !isReferenced(groupReferences, group) {
+			add(lintSeverityLow, "`%s` is defined but never referenced", group)
+		}
+	}
+
+	hostReferences := collectPolicyStrings(raw, "hosts")
+	for _, host := range sortedKeys(pol.Hosts) {
+		if !isReferenced(hostReferences, host) {
+			add(lintSeverityLow, "Host `%s` is defined but never referenced", host)
+		}
+	}
+
+	sort.SliceStable(findings, func(i, j int) bool {
+		return lintSeverityRank[findings[i].Severity] < lintSeverityRank[findings[j].Severity]
+	})
+
+	return findings, nil
+}
+
+// collectPolicyStrings returns all string values of a decoded policy, skipping the top level
+// section named skip.
+func collectPolicyStrings(raw map[string]any, skip string) []string {
+	var result []string
+
+	var walk func(v any)
+	walk = func(v any) {
+		switch v := v.(type) {
+		case string:
+			result = append(result, v)
+		case []any:
+			for _, e := range v {
+				walk(e)
+			}
+		case map[string]any:
+			for _, e := range v {
+				walk(e)
+			}
+		}
+	}
+
+	for key, value := range raw {
+		if !strings.EqualFold(key, skip) {
+			walk(value)
+		}
+	}
+
+	return result
+}
+
+// isReferenced reports whether name is used in any of the values, either on its own or with a
+// port suffix like name:22.
+func isReferenced(values []string, name string) bool {
+	for _, v := range values {
+		if v == name || strings.HasPrefix(v, name+":") {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isTag(name string) bool {
+	return strings.HasPrefix(name, "tag:")
+}
+
+// tagName extracts the tag from a value like tag:web or tag:web:443. It returns an empty string if
+// the value does not reference a tag.
+func tagName(value string) string {
+	if !isTag(value) {
+		return ""
+	}
+
+	name, _, _ := strings.Cut(strings.TrimPrefix(value, "tag:"), ":")
+	if name == "" {
+		return ""
+	}
+
+	return "tag:" + name
+}
+
+func hasTagOwner(pol *policy, tag string) bool {
+	_, ok := pol.TagOwners[tag]
+	return ok
+}
+
+// isAutoApproverTag reports whether the tag is used as a route auto approver. Such tags are
+// reported by the more specific autoApprovers check already.
+func isAutoApproverTag(pol *policy, tag string) bool {
+	for _, approvers := range pol.AutoApprovers.Routes {
+		if slices.Contains(approvers, tag) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// formatNames formats a list of policy names for use in a sentence.
+func formatNames(names []string) string {
+	if len(names) == 0 {
+		return "nobody"
+	}
+
+	return "`" + strings.Join(names, "`, `") + "`"
+}
+
+func (p *Plugin) handleACLLint(args *model.CommandArgs) error {
+	client, config, err := p.getTailscaleClient(args)
+	if err != nil || client == nil {
+		return err
+	}
+
+	acl, err := p.fetchACL(client)
+	if err != nil {
+		return err
+	}
+
+	findings, err := lintPolicy(acl.ACL)
+	if err != nil {
+		return err
+	}
+
+	if len(findings) == 0 {
+		p.postEphemeral(args.UserId, args.ChannelId, fmt.Sprintf("#### Policy lint report for %s\nNo issues found.", config.Tailnet))
+		return nil
+	}
+
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("#### Policy lint report for %s\n%d issues found.\n\n| Severity | Finding |\n| --- | --- |\n", config.Tailnet, len(findings)))
+	for _, finding := range findings {
+		b.WriteString(fmt.Sprintf("| %s | %s |\n", strings.ToUpper(finding.Severity), escapeCell(finding.Message)))
+	}
+
+	return p.postEphemeralOrFile(args.UserId, args.ChannelId, b.String(), "lint.md", []byte(b.String()), "")
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLintPolicy(t *testing.T) {
+	for name, tc := range map[string]struct {
+		policy   string
+		expected []lintFinding
+	}{
+		"clean policy": {
+			policy: `{
+				"groups": {"group:eng": ["alice@example.com"]},
+				"tagOwners": {"tag:prod": ["group:eng"]},
+				"acls": [{"action": "accept", "src": ["group:eng"], "dst": ["tag:prod:443"]}],
+			}`,
+		},
+		"allow all": {
+			policy: `{"acls": [{"action": "accept", "src": ["*"], "dst": ["*:*"]}]}`,
+			expected: []lintFinding{
+				{Severity: lintSeverityHigh, Message: "ACL rule 1 allows any source to reach any destination on any port (`*` → `*:*`)"},
+			},
+		},
+		"any destination": {
+			policy: `{
+				"groups": {"group:eng": ["alice@example.com"]},
+				"acls": [{"action": "accept", "src": ["group:eng"], "dst": ["*:*"]}],
+			}`,
+			expected: []lintFinding{
+				{Severity: lintSeverityMedium, Message: "ACL rule 1 allows `group:eng` to reach any destination on any port (`*:*`)"},
+			},
+		},
+		"root ssh": {
+			policy: `{
+				"groups": {"group:eng": ["alice@example.com"]},
+				"ssh": [
+					{"action": "accept", "src": ["group:eng"], "dst": ["autogroup:self"], "users": ["root"]},
+					{"action": "check", "src": ["group:eng"], "dst": ["autogroup:self"], "users": ["root"]},
+				],
+			}`,
+			expected: []lintFinding{
+				{Severity: lintSeverityHigh, Message: "SSH rule 1 allows `group:eng` to log in as `root`"},
+				{Severity: lintSeverityMedium, Message: "SSH rule 2 allows `group:eng` to log in as `root` after re-authentication"},
+			},
+		},
+		"auto approver without tag owner": {
+			policy: `{
+				"autoApprovers": {
+					"routes": {"10.0.0.0/8": ["tag:router"]},
+					"exitNode": ["tag:exit"],
+				},
+			}`,
+			expected: []lintFinding{
+				{Severity: lintSeverityHigh, Message: "autoApprovers for route `10.0.0.0/8` reference `tag:router`, which has no tagOwners entry"},
+				{Severity: lintSeverityHigh, Message: "autoApprovers for exit nodes reference `tag:exit`, which has no tagOwners entry"},
+			},
+		},
+		"tag without owner": {
+			policy: `{"acls": [{"action": "accept", "src": ["tag:ci"], "dst": ["tag:prod:22"]}]}`,
+			expected: []lintFinding{
+				{Severity: lintSeverityMedium, Message: "`tag:ci` is used but has no tagOwners entry, so no device can be tagged with it"},
+				{Severity: lintSeverityMedium, Message: "`tag:prod` is used but has no tagOwners entry, so no device can be tagged with it"},
+			},
+		},
+		"unused group and host": {
+			policy: `{
+				"groups": {"group:unused": ["alice@example.com"]},
+				"hosts": {"db": "10.0.0.5"},
+			}`,
+			expected: []lintFinding{
+				{Severity: lintSeverityLow, Message: "`group:unused` is defined but never referenced"},
+				{Severity: lintSeverityLow, Message: "Host `db` is defined but never referenced"},
+			},
+		},
+		"ordered by severity": {
+			policy: `{
+				"groups": {"group:unused": []},
+				"acls": [{"action": "accept", "src": ["*"], "dst": ["*:*"]}],
+			}`,
+			expected: []lintFinding{
+				{Severity: lintSeverityHigh, Message: "ACL rule 1 allows any source to reach any destination on any port (`*` → `*:*`)"},
+				{Severity: lintSeverityLow, Message: "`group:unused` is defined but never referenced"},
+			},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			findings, err := lintPolicy(tc.policy)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, findings)
+		})
+	}
+
+	t.Run("invalid policy", func(t *testing.T) {
+		_, err := lintPolicy(`{"acls": [`)
+		assert.Error(t, err)
+	})
+}
+
+func TestEscapeCell(t *testing.T) {
+	assert.Equal(t, "`a\\|b`", escapeCell("`a|b`"))
+	assert.Equal(t, "group:a, group:b", joinCell([]string{"group:a", "group:b"}))
+	assert.Equal(t, "a\\|b, c", joinCell([]string{"a|b", "c"}))
+	assert.Equal(t, "-", joinCell(nil))
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"html/template"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/pkg/errors"
+	"tailscale.com/client/tailscale"
+	"tailscale.com/client/tailscale/apitype"
+)
+
+// whoIsContextKey is the request context key of the WhoIs response of the peer.
+type whoIsContextKey struct{}
+
+// serveAccessRules are the allow and deny rules of the Serve proxy. A rule is a Tailscale login,
+// which may start with * to match a domain, a tag:name or a node:name.
+type serveAccessRules struct {
+	Allow []string
+	Deny  []string
+}
+
+// getServeAccessRules parses the configured allow and deny rules.
+func (c *configuration) getServeAccessRules() serveAccessRules {
+	return serveAccessRules{
+		Allow: splitServeRules(c.ServeAllow),
+		Deny:  splitServeRules(c.ServeDeny),
+	}
+}
+
+// splitServeRules parses a comma or newline separated list of rules.
+func splitServeRules(list string) []string {
+	var rules []string
+	for _, rule := range strings.FieldsFunc(list, func(r rune) bool { return r == ',' || r == '\n' }) {
+		rule = strings.ToLower(strings.TrimSpace(rule))
+		if rule != "" {
+			rules = append(rules, rule)
+		}
+	}
+
+	return rules
+}
+
+// validateServeRule checks that a rule is a login pattern, a tag:name or a node:name.
+func validateServeRule(rule string) error {
+	switch {
+	case strings.HasPrefix(rule, "tag:"), strings.HasPrefix(rule, "node:"):
+		if strings.TrimSpace(rule[strings.Index(rule, ":")+1:]) == "" {
+			return errors.Errorf("serve rule %q is missing a name", rule)
+		}
+	case !strings.Contains(rule, "@"):
+		return errors.Errorf("serve rule %q is neither a Tailscale login, a tag:name nor a node:name", rule)
+	default:
+		if _, err := path.Match(rule, ""); err != nil {
+			return errors.Errorf("serve rule %q is not a valid pattern", rule)
+		}
+	}
+
+	return nil
+}
+
+func (r serveAccessRules) empty() bool {
+	return len(r.Allow) == 0 && len(r.Deny) == 0
+}
+
+// check returns whether the peer may reach Mattermost and, if not, the reason.
+func (r serveAccessRules) check(who *apitype.WhoIsResponse) (bool, string) {
+	if rule := matchServeRule(r.Deny, who); rule != "" {
+		return false, "matches deny rule " + rule
+	}
+	if len(r.Allow) > 0 && matchServeRule(r.Allow, who) == "" {
+		return false, "matches no allow rule"
+	}
+
+	return true, ""
+}
+
+// matchServeRule returns the first rule matching the peer, or an empty string.
+func matchServeRule(rules []string, who *apitype.WhoIsResponse) string {
+	var loginName, nodeName string
+	var tags []string
+	if who.Node != nil {
+		// The computed name is assigned by the coordination server and unique in the tailnet,
+		// unlike the hostname, which the device reports itself.
+		nodeName = strings.ToLower(strings.TrimSuffix(who.Node.ComputedName, "."))
+		tags = who.Node.Tags
+		if who.UserProfile != nil && !who.Node.IsTagged() {
+			loginName = strings.ToLower(who.UserProfile.LoginName)
+		}
+	}
+
+	for _, rule := range rules {
+		switch {
+		case strings.HasPrefix(rule, "tag:"):
+			if slices.Contains(tags, rule) {
+				return rule
+			}
+		case strings.HasPrefix(rule, "node:"):
+			name := strings.TrimPrefix(rule, "node:")
+			if name != "" && name == nodeName {
+				return rule
+			}
+		default:
+			if loginName == "" {
+				continue
+			}
+			if matched, _ := path.Match(rule, loginName); matched {
+				return rule
+			}
+		}
+	}
+
+	return ""
+}
+
+// serveAccess identifies the peer of each request to the Serve proxy and rejects peers denied by
+// the configured rules. The WhoIs response is passed on in the request context.
+func (p *Plugin) serveAccess(lc *tailscale.LocalClient, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		config := p.getConfiguration()
+		rules := config.getServeAccessRules()
+		if rules.empty() && !config.ServeSSO {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		who, err := lc.WhoIs(r.Context(), r.RemoteAddr)
+		if err != nil {
+			if rules.empty() {
+				next.ServeHTTP(w, r)
+				return
+			}
+			p.API.LogWarn("Denied Serve access", "remote_addr", r.RemoteAddr, "reason", "unknown peer", "error", err.Error())
+			writeServeAccessDenied(w, "")
+			return
+		}
+
+		if allowed, reason := rules.check(who); !allowed {
+			var loginName, nodeName string
+			var tags []string
+			if who.Node != nil {
+				nodeName, tags = who.Node.ComputedName, who.Node.Tags
+				if who.UserProfile != nil && !who.Node.IsTagged() {
+					loginName = who.UserProfile.LoginName
+				}
+			}
+
+			p.API.LogWarn("Denied Serve access", "remote_addr", r.RemoteAddr, "login_name", loginName, "node", nodeName,
+				"tags", strings.Join(tags, ","), "reason", reason, "method", r.Method, "path", r.URL.Path)
+			writeServeAccessDenied(w, loginName)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), whoIsContextKey{}, who)))
+	})
+}
+
+// whoIs returns the WhoIs response of the request's peer, looking it up if serveAccess did not.
+func whoIs(r *http.Request, lc *tailscale.LocalClient) (*apitype.WhoIsResponse, error) {
+	if who, ok := r.Context().Value(whoIsContextKey{}).(*apitype.WhoIsResponse); ok {
+		return who, nil
+	}
+
+	return lc.WhoIs(r.Context(), r.RemoteAddr)
+}
+
+var serveAccessDeniedPage = template.Must(template.New("denied").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Access denied</title>
+<style>
+body { font-family: "Open Sans", sans-serif; background: #f4f4f6; color: #3f4350; display: flex; align-items: center; justify-content: center; min-height: 100vh; margin: 0; }
+main { background: #fff; border-radius: 8px; box-shadow: 0 2px 8px rgba(0, 0, 0, 0.08); padding: 40px; max-width: 480px; text-align: center; }
+h1 { font-size: 22px; margin-top: 0; }
+</style>
+</head>
+<body>
+<main>
+<h1>Access denied</h1>
+<p>{{if .}}Your Tailscale login <strong>{{.}}</strong> is{{else}}Your device is{{end}} not allowed to reach this Mattermost server.</p>
+<p>If you think this is a mistake, contact your Mattermost System Admin.</p>
+</main>
+</body>
+</html>
+`))
+
+func writeServeAccessDenied(w http.ResponseWriter, loginName string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusForbidden)
+	_ = serveAccessDeniedPage.Execute(w, loginName)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/tailcfg"
+)
+
+func TestValidateServeRule(t *testing.T) {
+	for rule, valid := range map[string]bool{
+		"alice@example.com":  true,
+		"*@example.com":      true,
+		"alice@*":            true,
+		"tag:office":         true,
+		"node:laptop":        true,
+		"tag:":               false,
+		"node: ":             false,
+		"laptop":             false,
+		"*":                  false,
+		"[alice@example.com": false,
+	} {
+		t.Run(rule, func(t *testing.T) {
+			err := validateServeRule(rule)
+			if valid {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestMatchServeRule(t *testing.T) {
+	user := &apitype.WhoIsResponse{
+		Node: &tailcfg.Node{
+			ComputedName: "Laptop.",
+			Hostinfo:     (&tailcfg.Hostinfo{Hostname: "build"}).View(),
+		},
+		UserProfile: &tailcfg.UserProfile{LoginName: "Alice@Example.com"},
+	}
+	tagged := &apitype.WhoIsResponse{
+		Node: &tailcfg.Node{
+			ComputedName: "runner",
+			Tags:         []string{"tag:ci"},
+		},
+		UserProfile: &tailcfg.UserProfile{LoginName: "tagged-devices"},
+	}
+
+	for name, tc := range map[string]struct {
+		rules    []string
+		who      *apitype.WhoIsResponse
+		expected string
+	}{
+		"login":                   {rules: []string{"alice@example.com"}, who: user, expected: "alice@example.com"},
+		"login pattern":           {rules: []string{"*@example.com"}, who: user, expected: "*@example.com"},
+		"other login":             {rules: []string{"bob@example.com", "*@other.com"}, who: user},
+		"node name":               {rules: []string{"node:laptop"}, who: user, expected: "node:laptop"},
+		"reported hostname":       {rules: []string{"node:build"}, who: user},
+		"first matching rule":     {rules: []string{"tag:ci", "node:laptop", "alice@example.com"}, who: user, expected: "node:laptop"},
+		"tag":                     {rules: []string{"tag:ci"}, who: tagged, expected: "tag:ci"},
+		"other tag":               {rules: []string{"tag:prod"}, who: tagged},
+		"tagged node name":        {rules: []string{"node:runner"}, who: tagged, expected: "node:runner"},
+		"login of a tagged node":  {rules: []string{"*"}, who: tagged},
+		"user without tag":        {rules: []string{"tag:ci"}, who: user},
+		"unknown node":            {rules: []string{"*@example.com", "node:laptop"}, who: &apitype.WhoIsResponse{}},
+		"no rules":                {who: user},
+		"empty node name in rule": {rules: []string{"node:"}, who: &apitype.WhoIsResponse{Node: &tailcfg.Node{}}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, matchServeRule(tc.rules, tc.who))
+		})
+	}
+}
+
+func TestServeAccessRulesCheck(t *testing.T) {
+	who := &apitype.WhoIsResponse{
+		Node:        &tailcfg.Node{ComputedName: "laptop"},
+		UserProfile: &tailcfg.UserProfile{LoginName: "alice@example.com"},
+	}
+
+	for name, tc := range map[string]struct {
+		rules   serveAccessRules
+		allowed bool
+	}{
+		"no rules":          {allowed: true},
+		"allowed":           {rules: serveAccessRules{Allow: []string{"*@example.com"}}, allowed: true},
+		"not allowed":       {rules: serveAccessRules{Allow: []string{"*@other.com"}}},
+		"denied":            {rules: serveAccessRules{Deny: []string{"node:laptop"}}},
+		"deny takes effect": {rules: serveAccessRules{Allow: []string{"*@example.com"}, Deny: []string{"alice@example.com"}}},
+		"other denied":      {rules: serveAccessRules{Deny: []string{"bob@example.com"}}, allowed: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			allowed, _ := tc.rules.check(who)
+			assert.Equal(t, tc.allowed, allowed)
+		})
+	}
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"tailscale.com/client/tailscale"
+	"tailscale.com/client/tailscale/apitype"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// ssoSessionCheckTimeout limits how long the proxy waits for Mattermost to check an existing
+// session before a page load.
+const ssoSessionCheckTimeout = 5 * time.Second
+
+// tailscaleSSO logs users reaching Mattermost over Tailscale Serve in as the Mattermost user with
+// the email address of their Tailscale login. Only page loads without a valid session are handled;
+// API requests and static assets are passed through unchanged.
+func (p *Plugin) tailscaleSSO(lc *tailscale.LocalClient, upstream *url.URL, next http.Handler) http.Handler {
+	sessionClient := &http.Client{Timeout: ssoSessionCheckTimeout}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !p.getConfiguration().ServeSSO || r.Method != http.MethodGet || !isPageLoad(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if cookie, err := r.Cookie(model.SessionCookieToken); err == nil && hasValidSession(r.Context(), sessionClient, upstream, cookie.Value) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		who, err := whoIs(r, lc)
+		if err != nil {
+			p.API.LogWarn("Failed to identify Tailscale peer", "remote_addr", r.RemoteAddr, "error", err.Error())
+			next.ServeHTTP(w, r)
+			return
+		}
+		if who.Node == nil || who.Node.IsTagged() || who.UserProfile == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		user, err := p.ssoUser(who)
+		if err != nil {
+			p.API.LogWarn("Tailscale sign-in failed", "login_name", who.UserProfile.LoginName, "node", who.Node.ComputedName, "error", err.Error())
+			next.ServeHTTP(w, r)
+			return
+		}
+		if user == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		session, err := p.createSSOSession(user, who.UserProfile.LoginName)
+		if err != nil {
+			p.API.LogError("Failed to create session for Tailscale sign-in", "user_id", user.Id, "error", err.Error())
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		p.API.LogInfo("Signed in user with Tailscale identity", "user_id", user.Id, "login_name", who.UserProfile.LoginName, "node", who.Node.ComputedName)
+
+		setSessionCookies(w, session)
+		http.Redirect(w, r, ssoRedirectTarget(r.URL), http.StatusFound)
+	})
+}
+
+// ssoRedirectTarget returns the path and query to reload after signing in. Leading slashes are
+// collapsed, so a request for //example.com cannot redirect to another site.
+func ssoRedirectTarget(u *url.URL) string {
+	target := "/" + strings.TrimLeft(u.EscapedPath(), "/")
+	if u.RawQuery != "" {
+		target += "?" + u.RawQuery
+	}
+
+	return target
+}
+
+// isPageLoad reports whether the path is loaded by a browser navigating to Mattermost, as opposed
+// to API calls, websockets and static assets.
+func isPageLoad(path string) bool {
+	for _, prefix := range []string{"/api/", "/static/", "/plugins/", "/hooks/"} {
+		if strings.HasPrefix(path, prefix) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// hasValidSession asks Mattermost whether the session token belongs to an active session.
+func hasValidSession(ctx context.Context, client *http.Client, upstream *url.URL, token string) bool {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, upstream.JoinPath("/api/v4/users/me").String(), nil)
+	if err != nil {
+		return false
+	}
+	req.Header.Set(model.HeaderAuth, model.HeaderBearer+" "+token)
+	req.Header.Set(model.HeaderRequestedWith, model.HeaderRequestedWithXML)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+
+	return resp.StatusCode == http.StatusOK
+}
+
+// ssoUser returns the Mattermost user matching the Tailscale login. If no user matches and auto
+// provisioning is enabled, a user is created. It returns nil if the peer cannot be signed in.
+// Users with multi-factor authentication or another sign-in method, like SAML or LDAP, are never
+// signed in, so Tailscale cannot bypass them. Neither are users whose email is not verified, as
+// anyone could have set it.
+func (p *Plugin) ssoUser(who *apitype.WhoIsResponse) (*model.User, error) {
+	loginName := who.UserProfile.LoginName
+	if !model.IsValidEmail(loginName) {
+		return nil, nil
+	}
+
+	user, appErr := p.API.GetUserByEmail(loginName)
+	if appErr == nil {
+		switch {
+		case user.DeleteAt != 0 || user.IsBot:
+			return nil, errors.New("the matching user is deactivated or a bot")
+		case user.MfaActive:
+			return nil, errors.New("the matching user has multi-factor authentication enabled")
+		case !user.EmailVerified:
+			return nil, errors.New("the email of the matching user is not verified")
+		case user.AuthService != "":
+			return nil, errors.Errorf("the matching user signs in with %s", user.AuthService)
+		}
+		return user, nil
+	}
+	if appErr.StatusCode != http.StatusNotFound {
+		return nil, fmt.Errorf("failed to get user by email: %w", appErr)
+	}
+
+	if !p.getConfiguration().ServeSSOAutoProvision {
+		return nil, nil
+	}
+
+	return p.provisionSSOUser(loginName, who.UserProfile.DisplayName)
+}
+
+// provisionSSOUser creates a Mattermost user for a Tailscale login. The email address is marked as
+// verified, since Tailscale already authenticated it.
+func (p *Plugin) provisionSSOUser(email, displayName string) (*model.User, error) {
+	username, err := p.ssoUsername(email)
+	if err != nil {
+		return nil, err
+	}
+
+	firstName, lastName, _ := strings.Cut(displayName, " ")
+	user, appErr := p.API.CreateUser(&model.User{
+		Email:         email,
+		Username:      username,
+		FirstName:     firstName,
+		LastName:      lastName,
+		EmailVerified: true,
+		// The user signs in with Tailscale and never uses this password. The fixed prefix
+		// satisfies password policies requiring upper case letters, numbers and symbols.
+		Password: "Ts1!" + model.NewRandomString(32),
+	})
+	if appErr != nil {
+		return nil, fmt.Errorf("failed to create user: %w", appErr)
+	}
+
+	p.API.LogInfo("Provisioned user for Tailscale sign-in", "user_id", user.Id, "username", user.Username, "email", email)
+
+	return user, nil
+}
+
+// ssoUsername derives an unused username from the local part of an email address.
+func (p *Plugin) ssoUsername(email string) (string, error) {
+	local, _, _ := strings.Cut(strings.ToLower(email), "@")
+	base := strings.Trim(strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '.' || r == '-' || r == '_' {
+			return r
+		}
+		return '-'
+	}, local), "-._")
+	if base == "" || base[0] < 'a' || base[0] > 'z' {
+		base = "ts-" + base
+	}
+	if len(base) > model.UserNameMaxLength-5 {
+		base = base[:model.UserNameMaxLength-5]
+	}
+
+	username := base
+	for attempt := 0; attempt < 5; attempt++ {
+		if model.IsValidUsername(username) {
+			if _, appErr := p.API.GetUserByUsername(username); appErr != nil {
+				return username, nil
+			}
+		}
+		username = base + "-" + strings.ToLower(model.NewRandomString(4))
+	}
+
+	return "", errors.Errorf("failed to find an unused username for %s", email)
+}
+
+// createSSOSession creates a web session for the user, lasting as long as regular web sessions.
+func (p *Plugin) createSSOSession(user *model.User, loginName string) (*model.Session, error) {
+	hours := 30 * 24
+	if length := p.client.Configuration.GetConfig().ServiceSettings.SessionLengthWebInHours; length != nil && *length > 0 {
+		hours = *length
+	}
+
+	session := &model.Session{
+		UserId:    user.Id,
+		Roles:     user.GetRawRoles(),
+		ExpiresAt: model.GetMillis() + int64(hours)*int64(time.Hour/time.Millisecond),
+	}
+	session.GenerateCSRF()
+	session.AddProp("tailscale_login", loginName)
+
+	created, appErr := p.API.CreateSession(session)
+	if appErr != nil {
+		return nil, appErr
+	}
+
+	return created, nil
+}
+
+// setSessionCookies sets the cookies the Mattermost web app expects after logging in.
+func setSessionCookies(w http.ResponseWriter, session *model.Session) {
+	expires := time.UnixMilli(session.ExpiresAt)
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     model.SessionCookieToken,
+		Value:    session.Token,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.SetCookie(w, &http.Cookie{
+		Name:     model.SessionCookieUser,
+		Value:    session.UserId,
+		Path:     "/",
+		Expires:  expires,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.SetCookie(w, &http.Cookie{
+		Name:     model.SessionCookieCsrf,
+		Value:    session.GetCSRF(),
+		Path:     "/",
+		Expires:  expires,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
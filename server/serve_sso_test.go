@@ -0,0 +1,125 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/tailcfg"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+)
+
+func TestSSORedirectTarget(t *testing.T) {
+	for name, tc := range map[string]struct {
+		url      string
+		expected string
+	}{
+		"root":                  {url: "/", expected: "/"},
+		"path and query":        {url: "/team/channels/town-square?a=1", expected: "/team/channels/town-square?a=1"},
+		"escaped path":          {url: "/team/a%2Fb", expected: "/team/a%2Fb"},
+		"protocol relative":     {url: "//example.com/path", expected: "/example.com/path"},
+		"many slashes":          {url: "///example.com", expected: "/example.com"},
+		"backslash":             {url: "/%5Cexample.com", expected: "/%5Cexample.com"},
+		"slash and backslash":   {url: "/\\example.com", expected: "/%5Cexample.com"},
+		"query without a path":  {url: "/?redirect_to=/team", expected: "/?redirect_to=/team"},
+		"double slash in query": {url: "/login?redirect_to=//example.com", expected: "/login?redirect_to=//example.com"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			u, err := url.ParseRequestURI(tc.url)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, ssoRedirectTarget(u))
+		})
+	}
+}
+
+func TestSSOUser(t *testing.T) {
+	who := &apitype.WhoIsResponse{
+		Node:        &tailcfg.Node{ComputedName: "laptop"},
+		UserProfile: &tailcfg.UserProfile{LoginName: "alice@example.com"},
+	}
+
+	for name, tc := range map[string]struct {
+		user *model.User
+		err  bool
+	}{
+		"active user":      {user: &model.User{Id: "alice", Email: "alice@example.com", EmailVerified: true}},
+		"unverified email": {user: &model.User{Id: "alice", Email: "alice@example.com"}, err: true},
+		"deactivated user": {user: &model.User{Id: "alice", DeleteAt: 1}, err: true},
+		"bot":              {user: &model.User{Id: "alice", IsBot: true}, err: true},
+		"mfa user":         {user: &model.User{Id: "alice", MfaActive: true}, err: true},
+		"saml user":        {user: &model.User{Id: "alice", AuthService: model.UserAuthServiceSaml}, err: true},
+		"ldap user":        {user: &model.User{Id: "alice", AuthService: model.UserAuthServiceLdap}, err: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			api := &plugintest.API{}
+			defer api.AssertExpectations(t)
+			api.On("GetUserByEmail", "alice@example.com").Return(tc.user, nil)
+
+			p := &Plugin{}
+			p.SetAPI(api)
+
+			user, err := p.ssoUser(who)
+			if tc.err {
+				assert.Error(t, err)
+				assert.Nil(t, user)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.user, user)
+		})
+	}
+}
+
+func TestSSOUsername(t *testing.T) {
+	notFound := model.NewAppError("GetUserByUsername", "app.user.missing_account.const", nil, "", http.StatusNotFound)
+
+	for name, tc := range map[string]struct {
+		email    string
+		taken    []string
+		expected string
+	}{
+		"plain":              {email: "alice@example.com", expected: "alice"},
+		"upper case":         {email: "Alice.Smith@Example.com", expected: "alice.smith"},
+		"invalid characters": {email: "alice+test@example.com", expected: "alice-test"},
+		"leading digit":      {email: "1alice@example.com", expected: "ts-1alice"},
+		"only symbols":       {email: "++@example.com", expected: "ts-"},
+		"trimmed symbols":    {email: "-alice_@example.com", expected: "alice"},
+		"reserved name":      {email: "admin@example.com", expected: "admin"},
+		"long name":          {email: "abcdefghijklmnopqrstuvwxyzabcdefghijklmnopqrstuvwxyzabcdefghijklmnopqrstuvwxyz@example.com", expected: "abcdefghijklmnopqrstuvwxyzabcdefghijklmnopqrstuvwxyzabcdefghijklmnopqrstuvwxyz"[:model.UserNameMaxLength-5]},
+		"taken":              {email: "bob@example.com", taken: []string{"bob"}, expected: "bob-"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			api := &plugintest.API{}
+			for _, username := range tc.taken {
+				api.On("GetUserByUsername", username).Return(&model.User{Username: username}, nil)
+			}
+			api.On("GetUserByUsername", mock.Anything).Return(nil, notFound)
+
+			p := &Plugin{}
+			p.SetAPI(api)
+
+			username, err := p.ssoUsername(tc.email)
+			require.NoError(t, err)
+			assert.Contains(t, username, tc.expected)
+			assert.True(t, model.IsValidUsername(username), "expected %s to be a valid username", username)
+			assert.NotContains(t, tc.taken, username)
+		})
+	}
+
+	t.Run("no unused username", func(t *testing.T) {
+		api := &plugintest.API{}
+		api.On("GetUserByUsername", mock.Anything).Return(&model.User{}, nil)
+
+		p := &Plugin{}
+		p.SetAPI(api)
+
+		_, err := p.ssoUsername("bob@example.com")
+		assert.Error(t, err)
+	})
+}
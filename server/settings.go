@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// maxKeyExpiryDays is the longest node key expiry Tailscale accepts.
+const maxKeyExpiryDays = 180
+
+// tailnetSetting is a tailnet-wide setting that can be viewed and changed from Mattermost.
+type tailnetSetting struct {
+	Name        string
+	Field       string
+	Description string
+	Days        bool
+}
+
+var tailnetSettingDefinitions = []tailnetSetting{
+	{Name: "device-approval", Field: "devicesApprovalOn", Description: "New devices need to be approved by an admin"},
+	{Name: "user-approval", Field: "usersApprovalOn", Description: "New users need to be approved by an admin"},
+	{Name: "key-expiry", Field: "devicesKeyDurationDays", Description: "Days until node keys expire", Days: true},
+	{Name: "posture-identity-collection", Field: "postureIdentityCollectionOn", Description: "Devices report serial numbers and other identifiers"},
+	{Name: "network-flow-logging", Field: "networkFlowLoggingOn", Description: "Network flow logs are recorded"},
+}
+
+func findTailnetSetting(name string) *tailnetSetting {
+	for i := range tailnetSettingDefinitions {
+		if tailnetSettingDefinitions[i].Name == name {
+			return &tailnetSettingDefinitions[i]
+		}
+	}
+
+	return nil
+}
+
+func tailnetSettingNames() string {
+	names := make([]string, 0, len(tailnetSettingDefinitions))
+	for _, setting := range tailnetSettingDefinitions {
+		names = append(names, setting.Name)
+	}
+
+	return strings.Join(names, ", ")
+}
+
+// parseValue converts a value given on the command line to the type the API expects.
+func (s *tailnetSetting) parseValue(value string) (any, error) {
+	if s.Days {
+		days, err := strconv.Atoi(value)
+		if err != nil || days < 1 || days > maxKeyExpiryDays {
+			return nil, errors.Errorf("%s must be a number of days between 1 and %d", s.Name, maxKeyExpiryDays)
+		}
+		return days, nil
+	}
+
+	switch strings.ToLower(value) {
+	case "on", "true", "yes", "enabled":
+		return true, nil
+	case "off", "false", "no", "disabled":
+		return false, nil
+	default:
+		return nil, errors.Errorf("%s must be on or off", s.Name)
+	}
+}
+
+// formatValue describes a value returned by the API.
+func (s *tailnetSetting) formatValue(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return "-"
+	case bool:
+		if v {
+			return "on"
+		}
+		return "off"
+	case float64:
+		if s.Days {
+			return fmt.Sprintf("%d days", int(v))
+		}
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func (p *Plugin) handleSettingsShow(args *model.CommandArgs) error {
+	client, config, err := p.getTailscaleClient(args)
+	if err != nil || client == nil {
+		return err
+	}
+
+	settings, err := tailnetSettings(context.Background(), client)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve settings from Tailscale API: %w", err)
+	}
+
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("#### Settings of %s\n| Setting | Value | Description |\n| --- | --- | --- |\n", config.Tailnet))
+	for _, setting := range tailnetSettingDefinitions {
+		b.WriteString(fmt.Sprintf("| %s | %s | %s |\n", setting.Name, setting.formatValue(settings[setting.Field]), setting.Description))
+	}
+
+	p.postEphemeral(args.UserId, args.ChannelId, b.String())
+	return nil
+}
+
+// handleSettingsSet changes a tailnet setting. Only system admins can change settings, and every
+// change is posted to the audit channel.
+func (p *Plugin) handleSettingsSet(args *model.CommandArgs, name, value string) error {
+	if !p.API.HasPermissionTo(args.UserId, model.PermissionManageSystem) {
+		p.postEphemeral(args.UserId, args.ChannelId, "Only system admins can change tailnet settings.")
+		return nil
+	}
+
+	setting := findTailnetSetting(name)
+	if setting == nil {
+		p.postEphemeral(args.UserId, args.ChannelId, fmt.Sprintf("Unknown setting `%s`. Available settings: %s", name, tailnetSettingNames()))
+		return nil
+	}
+
+	parsed, err := setting.parseValue(value)
+	if err != nil {
+		p.postEphemeral(args.UserId, args.ChannelId, err.Error())
+		return nil
+	}
+
+	auditChannel := strings.TrimSpace(p.getConfiguration().AuditChannel)
+	if auditChannel == "" {
+		p.postEphemeral(args.UserId, args.ChannelId, "Tailnet settings cannot be changed until an audit channel is configured in the plugin settings.")
+		return nil
+	}
+	channel, err := p.resolveChannel(auditChannel)
+	if err != nil {
+		return fmt.Errorf("failed to get audit channel: %w", err)
+	}
+
+	client, config, err := p.getTailscaleClient(args)
+	if err != nil || client == nil {
+		return err
+	}
+
+	ctx := context.Background()
+	current, err := tailnetSettings(ctx, client)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve settings from Tailscale API: %w", err)
+	}
+
+	previous := setting.formatValue(current[setting.Field])
+	updated, err := updateTailnetSettings(ctx, client, map[string]any{setting.Field: parsed})
+	if err != nil {
+		return fmt.Errorf("failed to update %s: %w", setting.Name, err)
+	}
+	now := setting.formatValue(updated[setting.Field])
+
+	p.API.LogInfo("Changed tailnet setting", "tailnet", config.Tailnet, "setting", setting.Name, "old_value", previous, "new_value", now, "user_id", args.UserId)
+
+	post := &model.Post{
+		ChannelId: channel.Id,
+		UserId:    p.botID,
+		Message:   fmt.Sprintf("%s changed **%s** of tailnet %s from %s to %s.", p.mentionUser(args.UserId), setting.Name, config.Tailnet, previous, now),
+	}
+	if err := p.client.Post.CreatePost(post); err != nil {
+		p.API.LogError("Failed to post tailnet setting change to audit channel", "setting", setting.Name, "error", err.Error())
+	}
+
+	p.postEphemeral(args.UserId, args.ChannelId, fmt.Sprintf("Changed **%s** of tailnet %s from %s to %s.", setting.Name, config.Tailnet, previous, now))
+	return nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+func TestTailnetSettings(t *testing.T) {
+	setup := func(t *testing.T, auditChannel string) (*Plugin, *fakeAPI, *fakeTailscale) {
+		p, api, ts := newTestPlugin(t)
+		p.setConfiguration(&configuration{AuditChannel: auditChannel})
+		api.admins["alice"] = true
+		api.users["alice"] = &model.User{Id: "alice", Username: "alice"}
+		ts.addTailnet("key", &fakeTailnet{ID: "1001", Settings: map[string]any{
+			"devicesApprovalOn":      false,
+			"devicesKeyDurationDays": 90,
+		}})
+		connectUser(t, p, "alice", "example.com", "key")
+		connectUser(t, p, "bob", "example.com", "key")
+		return p, api, ts
+	}
+
+	setting := func(ts *fakeTailscale, field string) any {
+		var value any
+		ts.tailnet("key", func(tailnet *fakeTailnet) {
+			value = tailnet.Settings[field]
+		})
+		return value
+	}
+
+	t.Run("only system admins can change settings", func(t *testing.T) {
+		p, api, ts := setup(t, "audit")
+
+		require.NoError(t, p.handleSettingsSet(&model.CommandArgs{UserId: "bob", ChannelId: "channel"}, "device-approval", "on"))
+		assert.Equal(t, "Only system admins can change tailnet settings.", api.lastEphemeral(t, "bob"))
+		assert.Equal(t, false, setting(ts, "devicesApprovalOn"))
+		assert.Empty(t, api.postsIn("audit"))
+	})
+
+	t.Run("changes need an audit channel", func(t *testing.T) {
+		p, api, ts := setup(t, "")
+
+		require.NoError(t, p.handleSettingsSet(&model.CommandArgs{UserId: "alice", ChannelId: "channel"}, "device-approval", "on"))
+		assert.Equal(t, "Tailnet settings cannot be changed until an audit channel is configured in the plugin settings.", api.lastEphemeral(t, "alice"))
+		assert.Equal(t, false, setting(ts, "devicesApprovalOn"))
+	})
+
+	t.Run("changes are posted to the audit channel", func(t *testing.T) {
+		p, api, ts := setup(t, "audit")
+
+		require.NoError(t, p.handleSettingsSet(&model.CommandArgs{UserId: "alice", ChannelId: "channel"}, "key-expiry", "30"))
+		assert.Equal(t, "Changed **key-expiry** of tailnet example.com from 90 days to 30 days.", api.lastEphemeral(t, "alice"))
+		assert.Equal(t, float64(30), setting(ts, "devicesKeyDurationDays"))
+		assert.Equal(t, []string{"@alice changed **key-expiry** of tailnet example.com from 90 days to 30 days."}, api.postsIn("audit"))
+	})
+
+	t.Run("invalid value", func(t *testing.T) {
+		p, api, ts := setup(t, "audit")
+
+		require.NoError(t, p.handleSettingsSet(&model.CommandArgs{UserId: "alice", ChannelId: "channel"}, "key-expiry", "365"))
+		assert.Equal(t, "key-expiry must be a number of days between 1 and 180", api.lastEphemeral(t, "alice"))
+		assert.Equal(t, 90, setting(ts, "devicesKeyDurationDays"))
+		assert.Empty(t, api.postsIn("audit"))
+	})
+}
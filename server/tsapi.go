@@ -0,0 +1,362 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"tailscale.com/client/tailscale"
+)
+
+// The tailscale client package only covers part of the Tailscale API. The helpers in this file
+// implement the remaining endpoints on top of tailscale.Client.Do, which takes care of
+// authentication.
+
+const (
+	defaultTailscaleAPIBase = "https://api.tailscale.com"
+
+	// maxTailscaleAPIResponseSize limits how much of a response body is read.
+	maxTailscaleAPIResponseSize = 10 << 20
+)
+
+// apiURL returns the URL of an API endpoint that is not scoped to a tailnet.
+func apiURL(client *tailscale.Client, endpoint string) string {
+	base := client.BaseURL
+	if base == "" {
+		base = defaultTailscaleAPIBase
+	}
+
+	return fmt.Sprintf("%s/api/v2/%s", base, endpoint)
+}
+
+// tailnetAPIURL returns the URL of a tailnet scoped API endpoint.
+func tailnetAPIURL(client *tailscale.Client, endpoint string) string {
+	return apiURL(client, fmt.Sprintf("tailnet/%s/%s", url.PathEscape(client.Tailnet()), endpoint))
+}
+
+// validateACL runs the full validation of a HuJSON policy file, including its embedded tests,
+// without applying it. It returns a nil ACLTestError if the policy is valid.
+func validateACL(ctx context.Context, client *tailscale.Client, huJSON string) (*tailscale.ACLTestError, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, tailnetAPIURL(client, "acl/validate"), bytes.NewBufferString(huJSON))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/hujson")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(io.LimitReader(resp.Body, maxTailscaleAPIResponseSize))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(bytes.TrimSpace(b)) == 0 {
+		if resp.StatusCode != http.StatusOK {
+			return nil, tailscale.ErrResponse{Status: resp.StatusCode, Message: http.StatusText(resp.StatusCode)}
+		}
+		return nil, nil
+	}
+
+	var res tailscale.ACLTestError
+	if err := json.Unmarshal(b, &res); err != nil {
+		return nil, fmt.Errorf("failed to decode validation response: %w", err)
+	}
+	res.Status = resp.StatusCode
+
+	if resp.StatusCode == http.StatusOK && res.Message == "" && len(res.Data) == 0 {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusBadRequest {
+		return nil, res.ErrResponse
+	}
+
+	return &res, nil
+}
+
+// doTailscaleRequest sends a JSON request to an API URL and decodes the JSON response into out, if
+// out is not nil. Non-2xx responses are returned as tailscale.ErrResponse.
+func doTailscaleRequest(ctx context.Context, client *tailscale.Client, method, requestURL string, body, out any) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, requestURL, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(io.LimitReader(resp.Body, maxTailscaleAPIResponseSize))
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		errResp := tailscale.ErrResponse{Status: resp.StatusCode}
+		if err := json.Unmarshal(b, &errResp); err != nil || errResp.Message == "" {
+			errResp.Message = http.StatusText(resp.StatusCode)
+		}
+		errResp.Status = resp.StatusCode
+		return errResp
+	}
+
+	if out == nil || len(bytes.TrimSpace(b)) == 0 {
+		return nil
+	}
+
+	if err := json.Unmarshal(b, out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return nil
+}
+
+// tailnetKey is an auth key or API key of a tailnet. tailscale.Key lacks the description and the
+// key type.
+type tailnetKey struct {
+	ID           string                    `json:"id"`
+	KeyType      string                    `json:"keyType"`
+	Description  string                    `json:"description"`
+	Created      time.Time                 `json:"created"`
+	Expires      time.Time                 `json:"expires"`
+	Invalid      bool                      `json:"invalid"`
+	Capabilities tailscale.KeyCapabilities `json:"capabilities"`
+}
+
+// createAuthKey creates an auth key. It returns the secret, which cannot be retrieved again later,
+// and the key metadata. A zero expiry uses the default expiry of the tailnet.
+func createAuthKey(ctx context.Context, client *tailscale.Client, caps tailscale.KeyCapabilities, expiry time.Duration, description string) (string, *tailnetKey, error) {
+	request := struct {
+		Capabilities  tailscale.KeyCapabilities `json:"capabilities"`
+		ExpirySeconds int64                     `json:"expirySeconds,omitempty"`
+		Description   string                    `json:"description,omitempty"`
+	}{caps, int64(expiry.Seconds()), description}
+
+	var response struct {
+		tailnetKey
+		Secret string `json:"key"`
+	}
+	if err := doTailscaleRequest(ctx, client, http.MethodPost, tailnetAPIURL(client, "keys"), request, &response); err != nil {
+		return "", nil, err
+	}
+
+	return response.Secret, &response.tailnetKey, nil
+}
+
+// listTailnetKeys returns the auth keys and API keys of the tailnet visible to the client.
+func listTailnetKeys(ctx context.Context, client *tailscale.Client) ([]*tailnetKey, error) {
+	var response struct {
+		Keys []*tailnetKey `json:"keys"`
+	}
+	if err := doTailscaleRequest(ctx, client, http.MethodGet, tailnetAPIURL(client, "keys?all=true"), nil, &response); err != nil {
+		return nil, err
+	}
+
+	return response.Keys, nil
+}
+
+// splitDNS returns the split DNS configuration of the tailnet, mapping domains to nameservers.
+func splitDNS(ctx context.Context, client *tailscale.Client) (map[string][]string, error) {
+	routes := map[string][]string{}
+	if err := doTailscaleRequest(ctx, client, http.MethodGet, tailnetAPIURL(client, "dns/split-dns"), nil, &routes); err != nil {
+		return nil, err
+	}
+
+	return routes, nil
+}
+
+// updateSplitDNS sets the nameservers of the given domains. Domains mapped to nil are removed.
+func updateSplitDNS(ctx context.Context, client *tailscale.Client, routes map[string][]string) (map[string][]string, error) {
+	updated := map[string][]string{}
+	if err := doTailscaleRequest(ctx, client, http.MethodPatch, tailnetAPIURL(client, "dns/split-dns"), routes, &updated); err != nil {
+		return nil, err
+	}
+
+	return updated, nil
+}
+
+// tailnetID is the ID of a tailnet. The API returns it as a number in some responses and as a
+// string in others.
+type tailnetID string
+
+func (id *tailnetID) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		*id = ""
+		return nil
+	}
+
+	*id = tailnetID(strings.Trim(string(data), `"`))
+	return nil
+}
+
+// tailnetUser is a user of a tailnet.
+type tailnetUser struct {
+	ID                 string    `json:"id"`
+	TailnetID          tailnetID `json:"tailnetId"`
+	DisplayName        string    `json:"displayName"`
+	LoginName          string    `json:"loginName"`
+	Created            time.Time `json:"created"`
+	Type               string    `json:"type"`
+	Role               string    `json:"role"`
+	Status             string    `json:"status"`
+	DeviceCount        int       `json:"deviceCount"`
+	LastSeen           time.Time `json:"lastSeen"`
+	CurrentlyConnected bool      `json:"currentlyConnected"`
+}
+
+// listTailnetUsers returns the users of the tailnet.
+func listTailnetUsers(ctx context.Context, client *tailscale.Client) ([]*tailnetUser, error) {
+	var response struct {
+		Users []*tailnetUser `json:"users"`
+	}
+	if err := doTailscaleRequest(ctx, client, http.MethodGet, tailnetAPIURL(client, "users"), nil, &response); err != nil {
+		return nil, err
+	}
+
+	return response.Users, nil
+}
+
+// resolveTailnetID returns the ID of the tailnet the client's API key belongs to. Unlike the
+// tailnet name given at /tailscale connect, which is often "-", the ID tells tailnets apart, so
+// everything the plugin stores per tailnet is keyed by it.
+func resolveTailnetID(ctx context.Context, client *tailscale.Client) (string, error) {
+	users, err := listTailnetUsers(ctx, client)
+	if err != nil {
+		return "", fmt.Errorf("failed to identify tailnet: %w", err)
+	}
+
+	for _, user := range users {
+		if user.TailnetID != "" {
+			return string(user.TailnetID), nil
+		}
+	}
+
+	return "", errors.New("failed to identify tailnet: the Tailscale API returned no tailnet ID")
+}
+
+// updateTailnetUser runs an action like approve, suspend, restore or role on a tailnet user.
+func updateTailnetUser(ctx context.Context, client *tailscale.Client, userID, action string, body any) error {
+	return doTailscaleRequest(ctx, client, http.MethodPost, apiURL(client, fmt.Sprintf("users/%s/%s", url.PathEscape(userID), action)), body, nil)
+}
+
+// userInvite is an invite for a new user to join a tailnet.
+type userInvite struct {
+	ID              string    `json:"id"`
+	Role            string    `json:"role"`
+	TailnetID       tailnetID `json:"tailnetId"`
+	InviterID       int64     `json:"inviterId"`
+	Email           string    `json:"email"`
+	LastEmailSentAt time.Time `json:"lastEmailSentAt"`
+	InviteURL       string    `json:"inviteUrl"`
+}
+
+// createUserInvite invites a user with the given email and role to the tailnet.
+func createUserInvite(ctx context.Context, client *tailscale.Client, email, role string) (*userInvite, error) {
+	request := []map[string]string{{"email": email, "role": role}}
+
+	var invites []*userInvite
+	if err := doTailscaleRequest(ctx, client, http.MethodPost, tailnetAPIURL(client, "user-invites"), request, &invites); err != nil {
+		return nil, err
+	}
+	if len(invites) == 0 {
+		return nil, errors.New("no invite was returned")
+	}
+
+	return invites[0], nil
+}
+
+// listUserInvites returns the pending user invites of the tailnet.
+func listUserInvites(ctx context.Context, client *tailscale.Client) ([]*userInvite, error) {
+	var invites []*userInvite
+	if err := doTailscaleRequest(ctx, client, http.MethodGet, tailnetAPIURL(client, "user-invites"), nil, &invites); err != nil {
+		return nil, err
+	}
+
+	return invites, nil
+}
+
+// tailnetSettings returns the tailnet-wide settings, keyed by their API field names.
+func tailnetSettings(ctx context.Context, client *tailscale.Client) (map[string]any, error) {
+	settings := map[string]any{}
+	if err := doTailscaleRequest(ctx, client, http.MethodGet, tailnetAPIURL(client, "settings"), nil, &settings); err != nil {
+		return nil, err
+	}
+
+	return settings, nil
+}
+
+// updateTailnetSettings changes the given tailnet-wide settings and returns the resulting settings.
+func updateTailnetSettings(ctx context.Context, client *tailscale.Client, changes map[string]any) (map[string]any, error) {
+	settings := map[string]any{}
+	if err := doTailscaleRequest(ctx, client, http.MethodPatch, tailnetAPIURL(client, "settings"), changes, &settings); err != nil {
+		return nil, err
+	}
+
+	return settings, nil
+}
+
+// webhookEndpoint is a webhook endpoint Tailscale sends tailnet events to.
+type webhookEndpoint struct {
+	EndpointID    string   `json:"endpointId"`
+	EndpointURL   string   `json:"endpointUrl"`
+	ProviderType  string   `json:"providerType"`
+	Subscriptions []string `json:"subscriptions"`
+	Secret        string   `json:"secret,omitempty"`
+}
+
+// createWebhook creates a generic webhook endpoint. The returned endpoint includes the signing
+// secret, which the API does not return again.
+func createWebhook(ctx context.Context, client *tailscale.Client, endpointURL string, subscriptions []string) (*webhookEndpoint, error) {
+	request := webhookEndpoint{
+		EndpointURL:   endpointURL,
+		Subscriptions: subscriptions,
+	}
+
+	var webhook webhookEndpoint
+	if err := doTailscaleRequest(ctx, client, http.MethodPost, tailnetAPIURL(client, "webhooks"), request, &webhook); err != nil {
+		return nil, err
+	}
+
+	return &webhook, nil
+}
+
+// updateWebhookSubscriptions replaces the events a webhook endpoint is subscribed to.
+func updateWebhookSubscriptions(ctx context.Context, client *tailscale.Client, endpointID string, subscriptions []string) error {
+	request := map[string][]string{"subscriptions": subscriptions}
+	return doTailscaleRequest(ctx, client, http.MethodPatch, apiURL(client, "webhooks/"+url.PathEscape(endpointID)), request, nil)
+}
+
+// deleteWebhook deletes a webhook endpoint. An endpoint that no longer exists counts as deleted.
+func deleteWebhook(ctx context.Context, client *tailscale.Client, endpointID string) error {
+	err := doTailscaleRequest(ctx, client, http.MethodDelete, apiURL(client, "webhooks/"+url.PathEscape(endpointID)), nil, nil)
+	var errResp tailscale.ErrResponse
+	if errors.As(err, &errResp) && errResp.Status == http.StatusNotFound {
+		return nil
+	}
+
+	return err
+}
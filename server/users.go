@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// tailnetUserRoles are the roles that can be assigned to tailnet users. The owner role can only be
+// transferred in the admin console.
+var tailnetUserRoles = []string{"member", "admin", "it-admin", "network-admin", "billing-admin", "auditor"}
+
+// isUserAdmin reports whether the user is one of the configured tailnet user admins.
+func (p *Plugin) isUserAdmin(userID string) (bool, error) {
+	user, err := p.client.User.Get(userID)
+	if err != nil {
+		return false, fmt.Errorf("failed to get user: %w", err)
+	}
+
+	return slices.Contains(p.getConfiguration().getUserAdmins(), strings.ToLower(user.Username)), nil
+}
+
+// requireUserAdmin posts a hint and returns false if the user is not a tailnet user admin.
+func (p *Plugin) requireUserAdmin(args *model.CommandArgs) (bool, error) {
+	isAdmin, err := p.isUserAdmin(args.UserId)
+	if err != nil {
+		return false, err
+	}
+	if !isAdmin {
+		p.postEphemeral(args.UserId, args.ChannelId, "Only the configured tailnet user admins can manage tailnet users.")
+	}
+
+	return isAdmin, nil
+}
+
+func (p *Plugin) handleUsers(args *model.CommandArgs) error {
+	if ok, err := p.requireUserAdmin(args); err != nil || !ok {
+		return err
+	}
+
+	client, config, err := p.getTailscaleClient(args)
+	if err != nil || client == nil {
+		return err
+	}
+
+	users, err := listTailnetUsers(context.Background(), client)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve users from Tailscale API: %w", err)
+	}
+
+	if len(users) == 0 {
+		p.postEphemeral(args.UserId, args.ChannelId, fmt.Sprintf("No users found in tailnet %s.", config.Tailnet))
+		return nil
+	}
+
+	sort.Slice(users, func(i, j int) bool {
+		return strings.ToLower(users[i].LoginName) < strings.ToLower(users[j].LoginName)
+	})
+
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("#### Users of %s\n| User | Name | Role | Status | Devices | Last seen |\n| --- | --- | --- | --- | --- | --- |\n", config.Tailnet))
+	for _, user := range users {
+		lastSeen := "-"
+		switch {
+		case user.CurrentlyConnected:
+			lastSeen = "connected"
+		case !user.LastSeen.IsZero():
+			lastSeen = user.LastSeen.UTC().Format(time.RFC3339)
+		}
+
+		b.WriteString(fmt.Sprintf("| %s | %s | %s | %s | %d | %s |\n",
+			user.LoginName, joinCell([]string{user.DisplayName}), user.Role, user.Status, user.DeviceCount, lastSeen))
+	}
+
+	return p.postEphemeralOrFile(args.UserId, args.ChannelId, b.String(), "users.md", []byte(b.String()), "")
+}
+
+// findTailnetUser looks up a tailnet user by login name or ID.
+func findTailnetUser(users []*tailnetUser, name string) *tailnetUser {
+	for _, user := range users {
+		if strings.EqualFold(user.LoginName, name) || user.ID == name {
+			return user
+		}
+	}
+
+	return nil
+}
+
+// handleUsersAction asks for confirmation before approving, suspending or restoring a tailnet user
+// or changing their role.
+func (p *Plugin) handleUsersAction(args *model.CommandArgs, action, name, role string) error {
+	if ok, err := p.requireUserAdmin(args); err != nil || !ok {
+		return err
+	}
+
+	if action == "role" && !slices.Contains(tailnetUserRoles, role) {
+		p.postEphemeral(args.UserId, args.ChannelId, fmt.Sprintf("Unknown role `%s`. Available roles: %s", role, strings.Join(tailnetUserRoles, ", ")))
+		return nil
+	}
+
+	client, config, err := p.getTailscaleClient(args)
+	if err != nil || client == nil {
+		return err
+	}
+
+	users, err := listTailnetUsers(context.Background(), client)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve users from Tailscale API: %w", err)
+	}
+
+	user := findTailnetUser(users, name)
+	if user == nil {
+		p.postEphemeral(args.UserId, args.ChannelId, fmt.Sprintf("User %s was not found in tailnet %s.", name, config.Tailnet))
+		return nil
+	}
+
+	question := fmt.Sprintf("%s %s", action, user.LoginName)
+	if action == "role" {
+		question = fmt.Sprintf("change the role of %s from %s to %s", user.LoginName, user.Role, role)
+	}
+
+	post := &model.Post{
+		ChannelId: args.ChannelId,
+		UserId:    p.botID,
+		Message: fmt.Sprintf("#### Do you want to %s in tailnet %s?\n**Role:** %s\n**Status:** %s\n**Devices:** %d",
+			question, config.Tailnet, user.Role, user.Status, user.DeviceCount),
+	}
+	model.ParseSlackAttachment(post, []*model.SlackAttachment{{
+		Actions: []*model.PostAction{
+			tailnetUserAction("Confirm", "primary", config.Tailnet, user, action, role),
+			tailnetUserAction("Cancel", "default", config.Tailnet, user, "cancel", ""),
+		},
+	}})
+	p.client.Post.SendEphemeralPost(args.UserId, post)
+
+	return nil
+}
+
+func tailnetUserAction(name, style, tailnet string, user *tailnetUser, action, role string) *model.PostAction {
+	return &model.PostAction{
+		Type:  model.PostActionTypeButton,
+		Name:  name,
+		Style: style,
+		Integration: &model.PostActionIntegration{
+			URL: actionURL("/api/v1/users/actions"),
+			Context: map[string]any{
+				"tailnet":         tailnet,
+				"tailnet_user_id": user.ID,
+				"login_name":      user.LoginName,
+				"action":          action,
+				"role":            role,
+			},
+		},
+	}
+}
+
+// handleTailnetUserAction runs a confirmed user action with the credentials of the admin who
+// confirmed it.
+func (p *Plugin) handleTailnetUserAction(userID string, request *model.PostActionIntegrationRequest) (string, error) {
+	if contextString(request, "action") == "cancel" {
+		return "Cancelled.", nil
+	}
+
+	return p.runTailnetUserAction(userID, request), nil
+}
+
+// runTailnetUserAction runs a user action and returns a message describing the outcome.
+func (p *Plugin) runTailnetUserAction(userID string, request *model.PostActionIntegrationRequest) string {
+	isAdmin, err := p.isUserAdmin(userID)
+	if err != nil {
+		p.API.LogError("Failed to check tailnet user admin", "error", err.Error())
+		return "Failed to check your permissions."
+	}
+	if !isAdmin {
+		return "Only the configured tailnet user admins can manage tailnet users."
+	}
+
+	tailnet := contextString(request, "tailnet")
+	config, err := p.getUserTailscaleConfig(userID)
+	if err != nil {
+		p.API.LogError("Failed to retrieve Tailscale configuration", "user_id", userID, "error", err.Error())
+		return "Failed to retrieve your Tailscale configuration."
+	}
+	if config == nil || config.Tailnet != tailnet {
+		return fmt.Sprintf("You are no longer connected to tailnet %s.", tailnet)
+	}
+
+	action, role := contextString(request, "action"), contextString(request, "role")
+	loginName := contextString(request, "login_name")
+	if !slices.Contains([]string{"approve", "suspend", "restore", "role"}, action) {
+		return fmt.Sprintf("Unknown action %q.", action)
+	}
+
+	var body any
+	if action == "role" {
+		body = map[string]string{"role": role}
+	}
+
+	client := p.newTailscaleClient(config.Tailnet, config.APIKey)
+	if err := updateTailnetUser(context.Background(), client, contextString(request, "tailnet_user_id"), action, body); err != nil {
+		return fmt.Sprintf("Failed to %s %s: %s", action, loginName, err.Error())
+	}
+
+	p.API.LogInfo("Updated tailnet user", "tailnet", tailnet, "login_name", loginName, "action", action, "role", role, "user_id", userID)
+
+	switch action {
+	case "role":
+		return fmt.Sprintf("Changed the role of %s to %s in tailnet %s.", loginName, role, tailnet)
+	case "approve":
+		return fmt.Sprintf("Approved %s in tailnet %s.", loginName, tailnet)
+	case "suspend":
+		return fmt.Sprintf("Suspended %s in tailnet %s.", loginName, tailnet)
+	default:
+		return fmt.Sprintf("Restored %s in tailnet %s.", loginName, tailnet)
+	}
+}
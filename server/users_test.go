@@ -0,0 +1,83 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+func TestTailnetUserAdmins(t *testing.T) {
+	setup := func(t *testing.T) (*Plugin, *fakeAPI, *fakeTailscale) {
+		p, api, ts := newTestPlugin(t)
+		p.setConfiguration(&configuration{UserAdmins: "@Carol"})
+		api.users["carol"] = &model.User{Id: "carol", Username: "carol"}
+		api.users["dave"] = &model.User{Id: "dave", Username: "dave"}
+		ts.addTailnet("key", &fakeTailnet{ID: "1001", Users: []*tailnetUser{
+			{ID: "u1", LoginName: "alice@example.com", Role: "member", Status: "active"},
+		}})
+		connectUser(t, p, "carol", "example.com", "key")
+		connectUser(t, p, "dave", "example.com", "key")
+		return p, api, ts
+	}
+
+	user := func(ts *fakeTailscale) tailnetUser {
+		var user tailnetUser
+		ts.tailnet("key", func(tailnet *fakeTailnet) {
+			user = *tailnet.Users[0]
+		})
+		return user
+	}
+
+	t.Run("non-admins cannot list or change users", func(t *testing.T) {
+		p, api, _ := setup(t)
+		args := &model.CommandArgs{UserId: "dave", ChannelId: "channel"}
+
+		require.NoError(t, p.handleUsers(args))
+		assert.Equal(t, "Only the configured tailnet user admins can manage tailnet users.", api.lastEphemeral(t, "dave"))
+
+		require.NoError(t, p.handleUsersAction(args, "suspend", "alice@example.com", ""))
+		assert.Equal(t, "Only the configured tailnet user admins can manage tailnet users.", api.lastEphemeral(t, "dave"))
+	})
+
+	t.Run("admin suspends a user", func(t *testing.T) {
+		p, api, ts := setup(t)
+
+		require.NoError(t, p.handleUsersAction(&model.CommandArgs{UserId: "carol", ChannelId: "channel"}, "suspend", "Alice@example.com", ""))
+		post := api.lastEphemeralPost(t, "carol")
+		assert.Contains(t, post.Message, "#### Do you want to suspend alice@example.com in tailnet example.com?")
+		assert.Equal(t, "active", user(ts).Status)
+
+		message, err := p.handleTailnetUserAction("carol", actionRequest(t, post, "Confirm"))
+		require.NoError(t, err)
+		assert.Equal(t, "Suspended alice@example.com in tailnet example.com.", message)
+		assert.Equal(t, "suspended", user(ts).Status)
+	})
+
+	t.Run("confirmation is checked again", func(t *testing.T) {
+		p, api, ts := setup(t)
+
+		require.NoError(t, p.handleUsersAction(&model.CommandArgs{UserId: "carol", ChannelId: "channel"}, "role", "u1", "admin"))
+		request := actionRequest(t, api.lastEphemeralPost(t, "carol"), "Confirm")
+
+		message, err := p.handleTailnetUserAction("dave", request)
+		require.NoError(t, err)
+		assert.Equal(t, "Only the configured tailnet user admins can manage tailnet users.", message)
+
+		p.setConfiguration(&configuration{})
+		message, err = p.handleTailnetUserAction("carol", request)
+		require.NoError(t, err)
+		assert.Equal(t, "Only the configured tailnet user admins can manage tailnet users.", message)
+		assert.Equal(t, "member", user(ts).Role)
+	})
+
+	t.Run("unknown role", func(t *testing.T) {
+		p, api, ts := setup(t)
+
+		require.NoError(t, p.handleUsersAction(&model.CommandArgs{UserId: "carol", ChannelId: "channel"}, "role", "u1", "owner"))
+		assert.Contains(t, api.lastEphemeral(t, "carol"), "Unknown role `owner`.")
+		assert.Equal(t, "member", user(ts).Role)
+	})
+}
@@ -0,0 +1,468 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"tailscale.com/client/tailscale"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/pluginapi"
+)
+
+const (
+	webhookKeyPrefix = "webhook_"
+
+	// webhookSignatureHeader carries the timestamp and HMAC signature of a webhook request.
+	webhookSignatureHeader = "Tailscale-Webhook-Signature"
+
+	// webhookTimestampTolerance is how far the timestamp of a webhook request may be from the
+	// current time. Older requests are rejected as replays.
+	webhookTimestampTolerance = 5 * time.Minute
+
+	// maxWebhookBodySize is the largest webhook request body accepted.
+	maxWebhookBodySize = 1 << 20
+)
+
+// webhookEventTypes are the event types Tailscale can send webhooks for.
+var webhookEventTypes = []string{
+	"nodeCreated",
+	"nodeNeedsApproval",
+	"nodeApproved",
+	"nodeKeyExpiringInOneDay",
+	"nodeKeyExpired",
+	"nodeDeleted",
+	"policyUpdate",
+	"userCreated",
+	"userNeedsApproval",
+	"userSuspended",
+	"userRestored",
+	"userDeleted",
+	"userApproved",
+	"userRoleUpdated",
+	"subnetIPForwardingNotEnabled",
+	"exitNodeIPForwardingNotEnabled",
+}
+
+// webhookSubscription is the webhook endpoint of a tailnet and the channels each event type is
+// posted to. The endpoint is created on the first subscription and deleted with the last one.
+// Subscriptions are keyed by TailnetID, as the tailnet name is often "-" for every connected
+// tailnet.
+type webhookSubscription struct {
+	Tailnet    string
+	TailnetID  string
+	EndpointID string
+	Secret     string
+	Channels   map[string][]string
+}
+
+// events returns the event types with at least one subscribed channel.
+func (s *webhookSubscription) events() []string {
+	events := make([]string, 0, len(s.Channels))
+	for event := range s.Channels {
+		events = append(events, event)
+	}
+	sort.Strings(events)
+
+	return events
+}
+
+// webhookEvent is a single event of a webhook request.
+type webhookEvent struct {
+	Timestamp time.Time      `json:"timestamp"`
+	Version   int            `json:"version"`
+	Type      string         `json:"type"`
+	Tailnet   string         `json:"tailnet"`
+	Message   string         `json:"message"`
+	Data      map[string]any `json:"data"`
+}
+
+func webhookKey(tailnetID string) string {
+	return webhookKeyPrefix + tailnetID
+}
+
+func (p *Plugin) getWebhookSubscription(tailnetID string) (*webhookSubscription, error) {
+	var subscription webhookSubscription
+	if err := p.client.KV.Get(webhookKey(tailnetID), &subscription); err != nil {
+		return nil, fmt.Errorf("failed to get webhook subscription: %w", err)
+	}
+	if subscription.Tailnet == "" {
+		return nil, nil
+	}
+
+	return &subscription, nil
+}
+
+// storeWebhookSubscription replaces the stored subscription of a tailnet, or deletes it if
+// subscription is nil. It fails if the stored subscription no longer matches original, the
+// subscription as it was read, so concurrent changes are not overwritten.
+func (p *Plugin) storeWebhookSubscription(tailnetID string, original []byte, subscription *webhookSubscription) error {
+	var value any
+	if subscription != nil {
+		value = subscription
+	}
+
+	written, err := p.client.KV.Set(webhookKey(tailnetID), value, pluginapi.SetAtomic(original))
+	if err != nil {
+		return fmt.Errorf("failed to store webhook subscription: %w", err)
+	}
+	if !written {
+		return errors.New("the webhook subscriptions of this tailnet were changed at the same time, please try again")
+	}
+
+	return nil
+}
+
+// webhookURL returns the URL Tailscale sends the webhooks of the tailnet with the given ID to.
+func (p *Plugin) webhookURL(tailnetID string) (string, error) {
+	siteURL := p.client.Configuration.GetConfig().ServiceSettings.SiteURL
+	if siteURL == nil || *siteURL == "" {
+		return "", errors.New("the Site URL is not configured")
+	}
+
+	return strings.TrimSuffix(*siteURL, "/") + actionURL("/webhooks/"+url.PathEscape(tailnetID)), nil
+}
+
+// parseWebhookEvents validates the event types given on the command line. "all" selects every
+// event type.
+func parseWebhookEvents(fields []string) ([]string, error) {
+	var events []string
+	for _, field := range fields {
+		for _, event := range strings.Split(field, ",") {
+			event = strings.TrimSpace(event)
+			switch {
+			case event == "":
+				continue
+			case event == "all":
+				return webhookEventTypes, nil
+			case !slices.Contains(webhookEventTypes, event):
+				return nil, errors.Errorf("unknown event %q", event)
+			case !slices.Contains(events, event):
+				events = append(events, event)
+			}
+		}
+	}
+
+	return events, nil
+}
+
+// handleWebhookSubscribe posts the given events of the user's tailnet to the current channel. The
+// webhook endpoint of the tailnet is created on the first subscription.
+func (p *Plugin) handleWebhookSubscribe(args *model.CommandArgs, fields []string) error {
+	events, err := parseWebhookEvents(fields)
+	if err == nil && len(events) == 0 {
+		err = errors.New("no events given")
+	}
+	if err != nil {
+		p.postEphemeral(args.UserId, args.ChannelId, fmt.Sprintf("%s\nUsage: /tailscale webhook subscribe <event...|all>\nAvailable events: %s", err.Error(), strings.Join(webhookEventTypes, ", ")))
+		return nil
+	}
+
+	client, config, err := p.getTailscaleClient(args)
+	if err != nil || client == nil {
+		return err
+	}
+
+	ctx := context.Background()
+	tailnetID, err := resolveTailnetID(ctx, client)
+	if err != nil {
+		return err
+	}
+
+	subscription, err := p.getWebhookSubscription(tailnetID)
+	if err != nil {
+		return err
+	}
+	var original []byte
+	if subscription == nil {
+		subscription = &webhookSubscription{Tailnet: config.Tailnet, TailnetID: tailnetID}
+	} else if original, err = json.Marshal(subscription); err != nil {
+		return err
+	}
+	if subscription.Channels == nil {
+		subscription.Channels = map[string][]string{}
+	}
+
+	previous := subscription.events()
+	for _, event := range events {
+		if !slices.Contains(subscription.Channels[event], args.ChannelId) {
+			subscription.Channels[event] = append(subscription.Channels[event], args.ChannelId)
+		}
+	}
+
+	created := subscription.EndpointID == ""
+	switch {
+	case created:
+		endpointURL, err := p.webhookURL(tailnetID)
+		if err != nil {
+			return err
+		}
+
+		webhook, err := createWebhook(ctx, client, endpointURL, subscription.events())
+		if err != nil {
+			return fmt.Errorf("failed to create webhook: %w", err)
+		}
+		subscription.EndpointID, subscription.Secret = webhook.EndpointID, webhook.Secret
+
+		p.API.LogInfo("Created webhook", "tailnet", config.Tailnet, "endpoint_id", webhook.EndpointID, "user_id", args.UserId)
+	case !slices.Equal(previous, subscription.events()):
+		if err := updateWebhookSubscriptions(ctx, client, subscription.EndpointID, subscription.events()); err != nil {
+			return fmt.Errorf("failed to update webhook subscriptions: %w", err)
+		}
+	}
+
+	if err := p.storeWebhookSubscription(tailnetID, original, subscription); err != nil {
+		if created {
+			if deleteErr := deleteWebhook(ctx, client, subscription.EndpointID); deleteErr != nil {
+				p.API.LogError("Failed to delete unused webhook", "tailnet", config.Tailnet, "endpoint_id", subscription.EndpointID, "error", deleteErr.Error())
+			}
+		} else {
+			p.revertWebhookSubscriptions(ctx, client, subscription, previous)
+		}
+		return err
+	}
+
+	p.postEphemeral(args.UserId, args.ChannelId, fmt.Sprintf("This channel will be notified about these events of tailnet %s: %s", config.Tailnet, strings.Join(events, ", ")))
+	return nil
+}
+
+// handleWebhookUnsubscribe stops posting the given events, or all events, to the current channel.
+// The webhook endpoint of the tailnet is deleted once no channel is subscribed anymore.
+func (p *Plugin) handleWebhookUnsubscribe(args *model.CommandArgs, fields []string) error {
+	events, err := parseWebhookEvents(fields)
+	if err != nil {
+		p.postEphemeral(args.UserId, args.ChannelId, fmt.Sprintf("%s\nUsage: /tailscale webhook unsubscribe [event...]", err.Error()))
+		return nil
+	}
+	if len(events) == 0 {
+		events = webhookEventTypes
+	}
+
+	client, config, err := p.getTailscaleClient(args)
+	if err != nil || client == nil {
+		return err
+	}
+
+	ctx := context.Background()
+	tailnetID, err := resolveTailnetID(ctx, client)
+	if err != nil {
+		return err
+	}
+
+	subscription, err := p.getWebhookSubscription(tailnetID)
+	if err != nil {
+		return err
+	}
+	if subscription == nil {
+		p.postEphemeral(args.UserId, args.ChannelId, fmt.Sprintf("No channel is subscribed to events of tailnet %s.", config.Tailnet))
+		return nil
+	}
+	original, err := json.Marshal(subscription)
+	if err != nil {
+		return err
+	}
+
+	previous := subscription.events()
+	var removed []string
+	for _, event := range events {
+		channels := subscription.Channels[event]
+		if !slices.Contains(channels, args.ChannelId) {
+			continue
+		}
+
+		removed = append(removed, event)
+		channels = slices.DeleteFunc(channels, func(channelID string) bool { return channelID == args.ChannelId })
+		if len(channels) == 0 {
+			delete(subscription.Channels, event)
+		} else {
+			subscription.Channels[event] = channels
+		}
+	}
+
+	if len(removed) == 0 {
+		p.postEphemeral(args.UserId, args.ChannelId, "This channel is not subscribed to these events.")
+		return nil
+	}
+
+	if len(subscription.Channels) == 0 {
+		if err := deleteWebhook(ctx, client, subscription.EndpointID); err != nil {
+			return fmt.Errorf("failed to delete webhook: %w", err)
+		}
+		if err := p.storeWebhookSubscription(tailnetID, original, nil); err != nil {
+			return err
+		}
+
+		p.API.LogInfo("Deleted webhook", "tailnet", config.Tailnet, "endpoint_id", subscription.EndpointID, "user_id", args.UserId)
+		p.postEphemeral(args.UserId, args.ChannelId, fmt.Sprintf("This channel will no longer be notified about events of tailnet %s.", config.Tailnet))
+		return nil
+	}
+
+	if !slices.Equal(previous, subscription.events()) {
+		if err := updateWebhookSubscriptions(ctx, client, subscription.EndpointID, subscription.events()); err != nil {
+			return fmt.Errorf("failed to update webhook subscriptions: %w", err)
+		}
+	}
+
+	if err := p.storeWebhookSubscription(tailnetID, original, subscription); err != nil {
+		p.revertWebhookSubscriptions(ctx, client, subscription, previous)
+		return err
+	}
+
+	p.postEphemeral(args.UserId, args.ChannelId, fmt.Sprintf("This channel will no longer be notified about these events of tailnet %s: %s", config.Tailnet, strings.Join(removed, ", ")))
+	return nil
+}
+
+// revertWebhookSubscriptions restores the events of the endpoint after the changed subscription
+// could not be stored.
+func (p *Plugin) revertWebhookSubscriptions(ctx context.Context, client *tailscale.Client, subscription *webhookSubscription, previous []string) {
+	if slices.Equal(previous, subscription.events()) {
+		return
+	}
+
+	if err := updateWebhookSubscriptions(ctx, client, subscription.EndpointID, previous); err != nil {
+		p.API.LogError("Failed to revert webhook subscriptions", "tailnet", subscription.Tailnet, "endpoint_id", subscription.EndpointID, "error", err.Error())
+	}
+}
+
+// verifyWebhookSignature checks the signature header of a webhook request. The header has the
+// form t=<unix timestamp>,v1=<hex HMAC-SHA256 of "<timestamp>.<body>">.
+func verifyWebhookSignature(header string, body []byte, secret string, now time.Time) error {
+	var timestamp string
+	var signatures []string
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			signatures = append(signatures, value)
+		}
+	}
+	if timestamp == "" || len(signatures) == 0 {
+		return errors.New("malformed signature header")
+	}
+
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errors.New("malformed signature timestamp")
+	}
+	if age := now.Sub(time.Unix(seconds, 0)); age > webhookTimestampTolerance || age < -webhookTimestampTolerance {
+		return errors.New("signature timestamp is outside of the accepted window")
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	expected := mac.Sum(nil)
+
+	for _, signature := range signatures {
+		decoded, err := hex.DecodeString(signature)
+		if err == nil && hmac.Equal(decoded, expected) {
+			return nil
+		}
+	}
+
+	return errors.New("signature mismatch")
+}
+
+// handleWebhook receives the webhooks of a tailnet, identified by its ID in the path, and posts
+// each event to the subscribed channels.
+func (p *Plugin) handleWebhook(w http.ResponseWriter, r *http.Request) {
+	tailnetID := r.PathValue("tailnet_id")
+
+	subscription, err := p.getWebhookSubscription(tailnetID)
+	if err != nil {
+		p.API.LogError("Failed to get webhook subscription", "tailnet_id", tailnetID, "error", err.Error())
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+	if subscription == nil || subscription.Secret == "" {
+		http.NotFound(w, r)
+		return
+	}
+	tailnet := subscription.Tailnet
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxWebhookBodySize+1))
+	if err != nil {
+		http.Error(w, "Failed to read body", http.StatusBadRequest)
+		return
+	}
+	if len(body) > maxWebhookBodySize {
+		http.Error(w, "Body too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	if err := verifyWebhookSignature(r.Header.Get(webhookSignatureHeader), body, subscription.Secret, time.Now()); err != nil {
+		p.API.LogWarn("Rejected webhook request", "tailnet", tailnet, "remote_addr", r.RemoteAddr, "error", err.Error())
+		http.Error(w, "Invalid signature", http.StatusUnauthorized)
+		return
+	}
+
+	var events []*webhookEvent
+	if err := json.Unmarshal(body, &events); err != nil {
+		http.Error(w, "Failed to decode events", http.StatusBadRequest)
+		return
+	}
+
+	for _, event := range events {
+		channels := subscription.Channels[event.Type]
+		if len(channels) == 0 {
+			continue
+		}
+
+		message := formatWebhookEvent(tailnet, event)
+		for _, channelID := range channels {
+			post := &model.Post{
+				ChannelId: channelID,
+				UserId:    p.botID,
+				Message:   message,
+			}
+			if err := p.client.Post.CreatePost(post); err != nil {
+				p.API.LogError("Failed to post webhook event", "tailnet", tailnet, "event", event.Type, "channel_id", channelID, "error", err.Error())
+			}
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// formatWebhookEvent describes a webhook event, listing its data fields.
+func formatWebhookEvent(tailnet string, event *webhookEvent) string {
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("#### %s in tailnet %s\n", event.Type, tailnet))
+	if event.Message != "" {
+		b.WriteString(event.Message + "\n")
+	}
+
+	keys := make([]string, 0, len(event.Data))
+	for key := range event.Data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		value := event.Data[key]
+		if _, ok := value.(string); !ok {
+			data, err := json.Marshal(value)
+			if err != nil {
+				continue
+			}
+			value = string(data)
+		}
+		b.WriteString(fmt.Sprintf("**%s:** %v\n", key, value))
+	}
+
+	return strings.TrimSuffix(b.String(), "\n")
+}
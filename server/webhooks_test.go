@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+func TestVerifyWebhookSignature(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	body := []byte(`[{"type":"nodeCreated"}]`)
+	sign := func(secret string, timestamp time.Time) string {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(strconv.FormatInt(timestamp.Unix(), 10) + "."))
+		mac.Write(body)
+		return hex.EncodeToString(mac.Sum(nil))
+	}
+	signature := sign("secret", now)
+
+	for name, tc := range map[string]struct {
+		header string
+		err    string
+	}{
+		"valid signature": {
+			header: fmt.Sprintf("t=%d,v1=%s", now.Unix(), signature),
+		},
+		"valid signature with spaces": {
+			header: fmt.Sprintf("t=%d, v1=%s", now.Unix(), signature),
+		},
+		"timestamp within the window": {
+			header: fmt.Sprintf("t=%d,v1=%s", now.Add(-4*time.Minute).Unix(), sign("secret", now.Add(-4*time.Minute))),
+		},
+		"multiple v1 values": {
+			header: fmt.Sprintf("t=%d,v1=%s,v1=%s", now.Unix(), sign("old secret", now), signature),
+		},
+		"wrong secret": {
+			header: fmt.Sprintf("t=%d,v1=%s", now.Unix(), sign("other secret", now)),
+			err:    "signature mismatch",
+		},
+		"multiple wrong v1 values": {
+			header: fmt.Sprintf("t=%d,v1=%s,v1=zz", now.Unix(), sign("other secret", now)),
+			err:    "signature mismatch",
+		},
+		"old timestamp": {
+			header: fmt.Sprintf("t=%d,v1=%s", now.Add(-6*time.Minute).Unix(), sign("secret", now.Add(-6*time.Minute))),
+			err:    "signature timestamp is outside of the accepted window",
+		},
+		"future timestamp": {
+			header: fmt.Sprintf("t=%d,v1=%s", now.Add(6*time.Minute).Unix(), sign("secret", now.Add(6*time.Minute))),
+			err:    "signature timestamp is outside of the accepted window",
+		},
+		"empty header": {
+			header: "",
+			err:    "malformed signature header",
+		},
+		"missing signature": {
+			header: fmt.Sprintf("t=%d", now.Unix()),
+			err:    "malformed signature header",
+		},
+		"missing timestamp": {
+			header: "v1=" + signature,
+			err:    "malformed signature header",
+		},
+		"malformed timestamp": {
+			header: "t=yesterday,v1=" + signature,
+			err:    "malformed signature timestamp",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			err := verifyWebhookSignature(tc.header, body, "secret", now)
+			if tc.err != "" {
+				assert.EqualError(t, err, tc.err)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestParseWebhookEvents(t *testing.T) {
+	for name, tc := range map[string]struct {
+		args     []string
+		expected []string
+		err      string
+	}{
+		"none":           {},
+		"single event":   {args: []string{"nodeCreated"}, expected: []string{"nodeCreated"}},
+		"separate words": {args: []string{"nodeCreated", "policyUpdate"}, expected: []string{"nodeCreated", "policyUpdate"}},
+		"comma list":     {args: []string{"nodeCreated, policyUpdate,"}, expected: []string{"nodeCreated", "policyUpdate"}},
+		"duplicates":     {args: []string{"nodeCreated", "nodeCreated,policyUpdate"}, expected: []string{"nodeCreated", "policyUpdate"}},
+		"all":            {args: []string{"nodeCreated", "all"}, expected: webhookEventTypes},
+		"unknown event":  {args: []string{"nodeCreated", "nodeRenamed"}, err: `unknown event "nodeRenamed"`},
+		"wrong case":     {args: []string{"nodecreated"}, err: `unknown event "nodecreated"`},
+	} {
+		t.Run(name, func(t *testing.T) {
+			events, err := parseWebhookEvents(tc.args)
+			if tc.err != "" {
+				assert.EqualError(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, events)
+		})
+	}
+}
+
+func TestWebhookSubscriptions(t *testing.T) {
+	setup := func(t *testing.T) (*Plugin, *fakeAPI, *fakeTailscale) {
+		p, api, ts := newTestPlugin(t)
+		api.config.ServiceSettings.SiteURL = model.NewString("https://mattermost.example.com")
+		p.router = p.initRouter()
+		ts.addTailnet("key-a", &fakeTailnet{ID: "1001"})
+		ts.addTailnet("key-b", &fakeTailnet{ID: "2002"})
+		// Both users connect to the default tailnet of their API key.
+		connectUser(t, p, "alice", "-", "key-a")
+		connectUser(t, p, "bob", "-", "key-b")
+		return p, api, ts
+	}
+
+	endpoint := func(t *testing.T, ts *fakeTailscale, apiKey string) webhookEndpoint {
+		var webhooks []webhookEndpoint
+		ts.tailnet(apiKey, func(tailnet *fakeTailnet) {
+			for _, webhook := range tailnet.Webhooks {
+				webhooks = append(webhooks, *webhook)
+			}
+		})
+		require.Len(t, webhooks, 1)
+		return webhooks[0]
+	}
+
+	deliver := func(p *Plugin, tailnetID, secret string) int {
+		body := []byte(`[{"type":"nodeCreated","tailnet":"example.com","message":"Node created"}]`)
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(timestamp + "."))
+		mac.Write(body)
+
+		r := httptest.NewRequest(http.MethodPost, "/webhooks/"+tailnetID, bytes.NewReader(body))
+		r.Header.Set(webhookSignatureHeader, fmt.Sprintf("t=%s,v1=%s", timestamp, hex.EncodeToString(mac.Sum(nil))))
+		w := httptest.NewRecorder()
+		p.ServeHTTP(nil, w, r)
+		return w.Code
+	}
+
+	t.Run("endpoints are created per tailnet ID", func(t *testing.T) {
+		p, api, ts := setup(t)
+
+		require.NoError(t, p.handleWebhookSubscribe(&model.CommandArgs{UserId: "alice", ChannelId: "channel-a"}, []string{"nodeCreated"}))
+		require.NoError(t, p.handleWebhookSubscribe(&model.CommandArgs{UserId: "bob", ChannelId: "channel-b"}, []string{"nodeCreated"}))
+		assert.Equal(t, "This channel will be notified about these events of tailnet -: nodeCreated", api.lastEphemeral(t, "bob"))
+
+		webhookA, webhookB := endpoint(t, ts, "key-a"), endpoint(t, ts, "key-b")
+		assert.True(t, strings.HasSuffix(webhookA.EndpointURL, "/webhooks/1001"), webhookA.EndpointURL)
+		assert.True(t, strings.HasSuffix(webhookB.EndpointURL, "/webhooks/2002"), webhookB.EndpointURL)
+
+		assert.Equal(t, http.StatusOK, deliver(p, "1001", webhookA.Secret))
+		assert.Equal(t, []string{"#### nodeCreated in tailnet -\nNode created"}, api.postsIn("channel-a"))
+		assert.Empty(t, api.postsIn("channel-b"))
+
+		assert.Equal(t, http.StatusUnauthorized, deliver(p, "2002", webhookA.Secret))
+		assert.Empty(t, api.postsIn("channel-b"))
+	})
+
+	t.Run("unsubscribe only touches the caller's tailnet", func(t *testing.T) {
+		p, api, ts := setup(t)
+
+		require.NoError(t, p.handleWebhookSubscribe(&model.CommandArgs{UserId: "alice", ChannelId: "channel-a"}, []string{"nodeCreated"}))
+		require.NoError(t, p.handleWebhookUnsubscribe(&model.CommandArgs{UserId: "bob", ChannelId: "channel-a"}, nil))
+		assert.Equal(t, "No channel is subscribed to events of tailnet -.", api.lastEphemeral(t, "bob"))
+
+		subscription, err := p.getWebhookSubscription("1001")
+		require.NoError(t, err)
+		require.NotNil(t, subscription)
+		assert.Equal(t, map[string][]string{"nodeCreated": {"channel-a"}}, subscription.Channels)
+		endpoint(t, ts, "key-a")
+	})
+
+	t.Run("unknown tailnet ID", func(t *testing.T) {
+		p, _, _ := setup(t)
+
+		assert.Equal(t, http.StatusNotFound, deliver(p, "1001", "secret"))
+	})
+}